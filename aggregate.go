@@ -0,0 +1,257 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"database/sql/driver"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Aggregator computes the result of an aggregate function registered by
+// RegisterAggregateFunction over the rows of a group. A new Aggregator is
+// used for every group, so it needs no reset.
+//
+// The result returned by Final is converted like the result of a scalar
+// function, see RegisterScalarFunction. To return several results, eg. a sum
+// and a count, Final can return them encoded in a TEXT or BLOB value, like
+// the JSON text of an object, to be taken apart by a scalar function, like
+// json_extract or a registered one:
+//
+//	select json_extract(stats(x), '$.sum'), json_extract(stats(x), '$.count') from t
+//
+// Setting the subtype of a JSON result to 'J' (74) with
+// FunctionContext.SetResultSubtype makes the JSON functions treat it as JSON
+// instead of as a string when it is passed to them directly.
+type Aggregator interface {
+	// Step adds a row, whose function arguments are args, to the
+	// aggregation. An error aborts the statement.
+	Step(ctx *FunctionContext, args []driver.Value) error
+	// Final returns the result of the aggregation. It is called once, also
+	// for an empty group, eg. of a query without GROUP BY over no rows, in
+	// which case Step was never called.
+	Final(ctx *FunctionContext) (driver.Value, error)
+}
+
+// WindowFunction computes the result of a window function registered by
+// RegisterWindowFunction over the rows of a window frame. It is an
+// Aggregator, whose Step adds rows entering the frame, that also removes rows
+// leaving the frame and reports intermediate results, so that SQLite can
+// slide the frame without recomputing it:
+//
+//	select x, moving_avg(x) over (order by t rows between 2 preceding and current row) from t
+//
+// A WindowFunction can be used as an ordinary aggregate function as well.
+type WindowFunction interface {
+	Aggregator
+	// Value returns the result for the current frame. It is like Final but
+	// leaves the state unchanged, Step and Inverse may be called after.
+	Value(ctx *FunctionContext) (driver.Value, error)
+	// Inverse removes a row, whose function arguments are args, that
+	// leaves the frame. It is called for the oldest row added by Step and
+	// not removed yet.
+	Inverse(ctx *FunctionContext, args []driver.Value) error
+}
+
+// aggregate is the state of an aggregation, referred to by a handle stored in
+// its sqlite3_aggregate_context.
+type aggregate struct {
+	agg    Aggregator
+	failed bool // Step returned an error
+}
+
+// RegisterAggregateFunction registers an aggregate function named zFuncName
+// with nArg arguments, or a variadic one if nArg is -1, whose result for a
+// group of rows is computed by an Aggregator returned by makeAggregate:
+//
+//	type sum struct{ n int64 }
+//
+//	func (s *sum) Step(ctx *sqlite.FunctionContext, args []driver.Value) error {
+//		n, _ := args[0].(int64)
+//		s.n += n
+//		return nil
+//	}
+//
+//	func (s *sum) Final(ctx *sqlite.FunctionContext) (driver.Value, error) { return s.n, nil }
+//
+//	...
+//	err := sqlite.RegisterAggregateFunction("my_sum", 1, func() sqlite.Aggregator { return &sum{} })
+//
+// The arguments are passed like to scalar functions, see
+// RegisterScalarFunction. Functions of the same name and nArg, scalar or
+// aggregate, cannot be registered twice.
+//
+// The new function will be available to all new connections opened after
+// executing RegisterAggregateFunction.
+func RegisterAggregateFunction(zFuncName string, nArg int32, makeAggregate func() Aggregator) error {
+	k := functionKey{zFuncName, nArg}
+	if _, ok := d.udfs[k]; ok {
+		return errFunctionRegistered(k)
+	}
+
+	// dont free, functions registered on the driver live as long as the program
+	name, err := libc.CString(zFuncName)
+	if err != nil {
+		return err
+	}
+
+	d.udfs[k] = newAggregateFunction(name, nArg, makeAggregate)
+	return nil
+}
+
+// MustRegisterAggregateFunction is like RegisterAggregateFunction but panics
+// on error.
+func MustRegisterAggregateFunction(zFuncName string, nArg int32, makeAggregate func() Aggregator) {
+	if err := RegisterAggregateFunction(zFuncName, nArg, makeAggregate); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterWindowFunction registers a window function named zFuncName with
+// nArg arguments, or a variadic one if nArg is -1, whose result for a window
+// frame is computed by a WindowFunction returned by makeWindow, like
+// RegisterAggregateFunction does for aggregate functions. A new
+// WindowFunction is used for every partition, and also for every group when
+// the function is used as an aggregate function.
+//
+// The new function will be available to all new connections opened after
+// executing RegisterWindowFunction.
+func RegisterWindowFunction(zFuncName string, nArg int32, makeWindow func() WindowFunction) error {
+	k := functionKey{zFuncName, nArg}
+	if _, ok := d.udfs[k]; ok {
+		return errFunctionRegistered(k)
+	}
+
+	// dont free, functions registered on the driver live as long as the program
+	name, err := libc.CString(zFuncName)
+	if err != nil {
+		return err
+	}
+
+	d.udfs[k] = newWindowFunction(name, nArg, makeWindow)
+	return nil
+}
+
+// MustRegisterWindowFunction is like RegisterWindowFunction but panics on
+// error.
+func MustRegisterWindowFunction(zFuncName string, nArg int32, makeWindow func() WindowFunction) {
+	if err := RegisterWindowFunction(zFuncName, nArg, makeWindow); err != nil {
+		panic(err)
+	}
+}
+
+func newAggregateFunction(name uintptr, nArg int32, makeAggregate func() Aggregator) *userDefinedFunction {
+	return &userDefinedFunction{
+		zFuncName: name,
+		nArg:      nArg,
+		eTextRep:  sqlite3.SQLITE_UTF8,
+		xStep: func(tls *libc.TLS, ctx uintptr, argc int32, argv uintptr) {
+			aggregateStep(tls, ctx, argc, argv, makeAggregate)
+		},
+		xFinal: func(tls *libc.TLS, ctx uintptr) {
+			aggregateFinal(tls, ctx, makeAggregate)
+		},
+	}
+}
+
+func newWindowFunction(name uintptr, nArg int32, makeWindow func() WindowFunction) *userDefinedFunction {
+	makeAggregate := func() Aggregator { return makeWindow() }
+	fun := newAggregateFunction(name, nArg, makeAggregate)
+	fun.xValue = func(tls *libc.TLS, ctx uintptr) {
+		windowValue(tls, ctx, makeAggregate)
+	}
+	fun.xInverse = func(tls *libc.TLS, ctx uintptr, argc int32, argv uintptr) {
+		windowInverse(tls, ctx, argc, argv, makeAggregate)
+	}
+	return fun
+}
+
+// aggregateState returns the state of the aggregation of the function call
+// ctx and its handle. If there is none yet, a new one using makeAggregate is
+// created, unless makeAggregate is nil, in which case aggregateState returns
+// nil.
+//
+// void *sqlite3_aggregate_context(sqlite3_context*, int nBytes);
+func aggregateState(tls *libc.TLS, ctx uintptr, makeAggregate func() Aggregator) (uintptr, *aggregate) {
+	var n int32
+	if makeAggregate != nil {
+		n = int32(unsafe.Sizeof(uintptr(0)))
+	}
+	p := sqlite3.Xsqlite3_aggregate_context(tls, ctx, n)
+	if p == 0 {
+		return 0, nil
+	}
+
+	if h := *(*uintptr)(unsafe.Pointer(p)); h != 0 {
+		return h, handleValue(h).(*aggregate)
+	}
+
+	a := &aggregate{agg: makeAggregate()}
+	h := newHandle(a)
+	*(*uintptr)(unsafe.Pointer(p)) = h
+	return h, a
+}
+
+// aggregateStep is the xStep callback of aggregate functions.
+func aggregateStep(tls *libc.TLS, ctx uintptr, argc int32, argv uintptr, makeAggregate func() Aggregator) {
+	_, a := aggregateState(tls, ctx, makeAggregate)
+	if a == nil {
+		sqlite3.Xsqlite3_result_error_nomem(tls, ctx)
+		return
+	}
+
+	if err := a.agg.Step(newFunctionContext(tls, ctx, argc, argv), functionArgs(tls, argc, argv)); err != nil {
+		a.failed = true
+		errorResultFunction(tls, ctx)(err)
+	}
+}
+
+// aggregateFinal is the xFinal callback of aggregate functions. SQLite calls
+// it also to clean up after a failed Step, so it always releases the state.
+func aggregateFinal(tls *libc.TLS, ctx uintptr, makeAggregate func() Aggregator) {
+	h, a := aggregateState(tls, ctx, nil)
+	switch {
+	case a == nil:
+		// No rows.
+		a = &aggregate{agg: makeAggregate()}
+	default:
+		defer deleteHandle(h)
+	}
+
+	if a.failed {
+		return
+	}
+
+	fc := newFunctionContext(tls, ctx, 0, 0)
+	fc.setResult(a.agg.Final(fc))
+}
+
+// windowValue is the xValue callback of window functions.
+func windowValue(tls *libc.TLS, ctx uintptr, makeAggregate func() Aggregator) {
+	_, a := aggregateState(tls, ctx, makeAggregate)
+	if a == nil {
+		sqlite3.Xsqlite3_result_error_nomem(tls, ctx)
+		return
+	}
+
+	fc := newFunctionContext(tls, ctx, 0, 0)
+	fc.setResult(a.agg.(WindowFunction).Value(fc))
+}
+
+// windowInverse is the xInverse callback of window functions.
+func windowInverse(tls *libc.TLS, ctx uintptr, argc int32, argv uintptr, makeAggregate func() Aggregator) {
+	_, a := aggregateState(tls, ctx, makeAggregate)
+	if a == nil {
+		sqlite3.Xsqlite3_result_error_nomem(tls, ctx)
+		return
+	}
+
+	if err := a.agg.(WindowFunction).Inverse(newFunctionContext(tls, ctx, argc, argv), functionArgs(tls, argc, argv)); err != nil {
+		a.failed = true
+		errorResultFunction(tls, ctx)(err)
+	}
+}
@@ -10,10 +10,13 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"embed"
+	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"net/url"
 	"os"
@@ -257,6 +260,70 @@ func TestIssue100(t *testing.T) {
 	}
 }
 
+func TestBooleanNull(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	if _, err := db.Exec("create table t(i int, b boolean); insert into t values(1, null), (2, 0), (3, 1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bool
+	if err := db.QueryRow("select b from t where i = 1").Scan(&b); err == nil {
+		t.Fatalf("unexpected success, got %v", b)
+	}
+
+	for _, tt := range []struct {
+		i    int
+		want sql.NullBool
+	}{
+		{1, sql.NullBool{}},
+		{2, sql.NullBool{Bool: false, Valid: true}},
+		{3, sql.NullBool{Bool: true, Valid: true}},
+	} {
+		var got sql.NullBool
+		if err := db.QueryRow("select b from t where i = ?", tt.i).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%v: got %+v, want %+v", tt.i, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		i    int
+		want reflect.Type
+	}{
+		{1, reflect.TypeOf(sql.NullBool{})},
+		{3, reflect.TypeOf(false)},
+	} {
+		rows, err := db.Query("select b from t where i = ?", tt.i)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !rows.Next() {
+			t.Fatal(rows.Err())
+		}
+
+		types, err := rows.ColumnTypes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g, e := types[0].ScanType(), tt.want; g != e {
+			t.Errorf("%v: got %v, want %v", tt.i, g, e)
+		}
+
+		rows.Close()
+	}
+}
+
 // https://gitlab.com/cznic/sqlite/issues/98
 func TestIssue98(t *testing.T) {
 	dir, db := tempDB(t)
@@ -277,6 +344,166 @@ func TestIssue98(t *testing.T) {
 	}
 }
 
+func TestTooBig(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if _, err := c.ExecContext(ctx, "create table t(b)"); err != nil {
+		t.Fatal(err)
+	}
+
+	isTooBig := func(err error) bool {
+		var e *Error
+		return errors.As(err, &e) && e.Code() == sqlite3.SQLITE_TOOBIG
+	}
+
+	if err := c.Raw(func(driverConn interface{}) error {
+		driverConn.(*conn).limit(sqlite3.SQLITE_LIMIT_LENGTH, 1000)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, arg := range []interface{}{make([]byte, 1001), strings.Repeat("x", 1001)} {
+		if _, err := c.ExecContext(ctx, "insert into t values(?)", arg); !isTooBig(err) {
+			t.Fatalf("%T: got %v, want SQLITE_TOOBIG", arg, err)
+		}
+	}
+
+	if _, err := c.ExecContext(ctx, "insert into t values(?)", make([]byte, 500)); err != nil {
+		t.Fatal(err)
+	}
+
+	if strconv.IntSize < 64 || testing.Short() {
+		return
+	}
+
+	// A slice beyond the 2GB limit of SQLite is rejected before it is
+	// copied, so its pages are never touched.
+	if _, err := c.ExecContext(ctx, "insert into t values(?)", make([]byte, math.MaxInt32+1)); !isTooBig(err) {
+		t.Fatalf("got %v, want SQLITE_TOOBIG", err)
+	}
+}
+
+func TestAsBlobAsText(t *testing.T) {
+	dir, db := tempDB(t)
+
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+
+	if _, err := db.Exec("create table t(i integer primary key, v)"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range []struct {
+		arg  interface{}
+		want string
+	}{
+		{"abc", "text"},
+		{[]byte("abc"), "blob"},
+		{AsBlob("abc"), "blob"},
+		{AsBlob(""), "blob"},
+		{AsText([]byte("abc")), "text"},
+		{AsText(nil), "text"},
+	} {
+		if _, err := db.Exec("insert into t values(?, ?)", i, tt.arg); err != nil {
+			t.Fatal(err)
+		}
+
+		var typ string
+		if err := db.QueryRow("select typeof(v) from t where i = ?", i).Scan(&typ); err != nil {
+			t.Fatal(err)
+		}
+
+		if typ != tt.want {
+			t.Errorf("%v: got %s, want %s", i, typ, tt.want)
+		}
+	}
+
+	var n int
+	if err := db.QueryRow("select count(*) from t where v = ?", AsBlob("abc")).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 2 {
+		t.Fatalf("got %v rows, want 2", n)
+	}
+}
+
+func TestBindPointer(t *testing.T) {
+	const typ = "sqlite.test.int64s"
+
+	if err := RegisterDeterministicScalarFunction("test_pointer_sum", 1, func(ctx *FunctionContext, args []driver.Value) (driver.Value, error) {
+		if args[0] != nil {
+			return nil, fmt.Errorf("pointer is not NULL: %v", args[0])
+		}
+
+		a, ok := ctx.Value(0).Pointer(typ).([]int64)
+		if !ok {
+			return nil, fmt.Errorf("not a %s pointer", typ)
+		}
+
+		var sum int64
+		for _, v := range a {
+			sum += v
+		}
+		return sum, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	// Opening the connection creates handles for the registered functions.
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	handles.Lock()
+	n0 := len(handles.m)
+	handles.Unlock()
+
+	var sum int64
+	if err := db.QueryRow("select test_pointer_sum(?)", BindPointer(typ, []int64{1, 2, 3})).Scan(&sum); err != nil {
+		t.Fatal(err)
+	}
+
+	if sum != 6 {
+		t.Fatalf("got %v, want 6", sum)
+	}
+
+	if err := db.QueryRow("select test_pointer_sum(?)", BindPointer("other", []int64{1})).Scan(&sum); err == nil || !strings.Contains(err.Error(), "not a "+typ) {
+		t.Fatalf("got %v, want a type tag mismatch", err)
+	}
+
+	handles.Lock()
+	n := len(handles.m)
+	handles.Unlock()
+	if n != n0 {
+		t.Fatalf("got %v handles, want %v", n, n0)
+	}
+}
+
 // https://gitlab.com/cznic/sqlite/issues/97
 func TestIssue97(t *testing.T) {
 	name := filepath.Join(t.TempDir(), "tmp.db")
@@ -449,6 +676,79 @@ func TestRedefineUserDefinedFunction(t *testing.T) {
 	}
 }
 
+func TestUserDefinedFunctionDestroy(t *testing.T) {
+	db, err := sql.Open(driverName, "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nhandles := func() int {
+		handles.Lock()
+		defer handles.Unlock()
+
+		return len(handles.m)
+	}
+
+	n0 := nhandles()
+	for i := 0; i < 1000; i++ {
+		i := i
+		if err := c.Raw(func(driverConn interface{}) error {
+			dc := driverConn.(*conn)
+			name, err := libc.CString("test_destroy")
+			if err != nil {
+				return err
+			}
+
+			// SQLite copies the name.
+			defer dc.free(name)
+
+			return dc.createFunctionInternal(&userDefinedFunction{
+				zFuncName: name,
+				nArg:      0,
+				eTextRep:  sqlite3.SQLITE_UTF8,
+				xFunc: func(tls *libc.TLS, ctx uintptr, argc int32, argv uintptr) {
+					sqlite3.Xsqlite3_result_int(tls, ctx, int32(i))
+				},
+			})
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Replacing the function destroys the previous one.
+		if g, e := nhandles(), n0+1; g != e {
+			t.Fatalf("%v: got %v handles, want %v", i, g, e)
+		}
+	}
+
+	var r int
+	if err := c.QueryRowContext(ctx, "select test_destroy()").Scan(&r); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := r, 999; g != e {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	if err := c.Raw(func(driverConn interface{}) error { return driver.ErrBadConn }); err != driver.ErrBadConn {
+		t.Fatal(err)
+	}
+
+	// Closing the connection destroys the last one and the functions
+	// registered on the driver.
+	c.Close()
+	if g, e := nhandles(), n0-len(d.udfs); g != e {
+		t.Fatalf("after close: got %v handles, want %v", g, e)
+	}
+}
+
 func TestRegexpUserDefinedFunction(t *testing.T) {
 	dir, db := tempDB(t)
 	ctx := context.Background()
@@ -662,6 +962,127 @@ func TestBlob(t *testing.T) {
 	}
 }
 
+type gobRecord struct {
+	Name  string
+	Tags  []string
+	Score float64
+}
+
+func (r gobRecord) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(struct {
+		Name  string
+		Tags  []string
+		Score float64
+	}(r)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (r *gobRecord) UnmarshalBinary(b []byte) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode((*struct {
+		Name  string
+		Tags  []string
+		Score float64
+	})(r))
+}
+
+func TestBinaryMarshaler(t *testing.T) {
+	db, err := sql.Open(driverName, "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	if _, err := db.Exec("create table t(b blob)"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := gobRecord{Name: "foo", Tags: []string{"a", "b"}, Score: 42.5}
+	if _, err := db.Exec("insert into t values(?)", want); err != nil {
+		t.Fatal(err)
+	}
+
+	var typ string
+	if err := db.QueryRow("select typeof(b) from t").Scan(&typ); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := typ, "blob"; g != e {
+		t.Fatalf("got %q, want %q", g, e)
+	}
+
+	var got gobRecord
+	if err := db.QueryRow("select b from t").Scan(ScanBinary(&got)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	// Values that are already valid driver values keep their usual binding.
+	now := time.Now()
+	if err := db.QueryRow("select typeof(?)", now).Scan(&typ); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := typ, "text"; g != e {
+		t.Fatalf("time.Time: got %q, want %q", g, e)
+	}
+}
+
+func TestScanNumber(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("create table t(i integer primary key, v); insert into t(v) values(9007199254740993), (2.0), (0.1), (-1.5e10), (null), ('x')"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query("select v from t order by i")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		n := json.Number("unchanged")
+		if err := rows.Scan(ScanNumber(&n)); err != nil {
+			got = append(got, "error")
+			continue
+		}
+
+		got = append(got, n.String())
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := strings.Join(got, " "), "9007199254740993 2.0 0.1 -1.5e+10 unchanged error"; g != e {
+		t.Fatalf("got %s, want %s", g, e)
+	}
+
+	b, err := json.Marshal([]json.Number{json.Number(got[0]), json.Number(got[1])})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := string(b), "[9007199254740993,2.0]"; g != e {
+		t.Fatalf("got %s, want %s", g, e)
+	}
+}
+
 func benchmarkInsertMemory(b *testing.B, n int) {
 	db, err := sql.Open(driverName, "file::memory:")
 	if err != nil {
@@ -1666,24 +2087,58 @@ func TestTimeFormat(t *testing.T) {
 	}
 }
 
-func TestTimeFormatBad(t *testing.T) {
-	db, err := sql.Open(driverName, "file::memory:?_time_format=bogus")
+func TestTimeNanosecondRoundTrip(t *testing.T) {
+	db, err := sql.Open(driverName, "file::memory:")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer db.Close()
 
-	// Error doesn't appear until a connection is opened.
-	_, err = db.Exec("select 1")
-	if err == nil {
-		t.Fatal("wanted error")
-	}
-
-	want := `unknown _time_format "bogus"`
-	if got := err.Error(); got != want {
-		t.Fatalf("got error %q, want %q", got, want)
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("create table x (i integer primary key, y datetime)"); err != nil {
+		t.Fatal(err)
 	}
-}
+
+	for i, v := range []time.Time{
+		time.Now(),
+		time.Date(2021, 1, 2, 16, 39, 17, 123456789, time.UTC),
+		time.Date(2021, 1, 2, 16, 39, 17, 1, time.FixedZone("", -(3*3600+30*60))),
+		time.Date(2021, 1, 2, 16, 39, 17, 100000000, time.FixedZone("", 14*3600)),
+		time.Date(1890, 1, 2, 16, 39, 17, 999999999, time.FixedZone("LMT", 9*60+21)),
+	} {
+		if _, err := db.Exec("insert into x values (?, ?)", i, v); err != nil {
+			t.Fatal(err)
+		}
+
+		var got time.Time
+		if err := db.QueryRow("select y from x where i = ?", i).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if !got.Equal(v) || got.Nanosecond() != v.Nanosecond() {
+			t.Errorf("%v: got %v, want %v", i, got, v)
+		}
+	}
+}
+
+func TestTimeFormatBad(t *testing.T) {
+	db, err := sql.Open(driverName, "file::memory:?_time_format=bogus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Error doesn't appear until a connection is opened.
+	_, err = db.Exec("select 1")
+	if err == nil {
+		t.Fatal("wanted error")
+	}
+
+	want := `unknown _time_format "bogus"`
+	if got := err.Error(); got != want {
+		t.Fatalf("got error %q, want %q", got, want)
+	}
+}
 
 // https://sqlite.org/lang_expr.html#varparam
 // https://gitlab.com/cznic/sqlite/-/issues/42
@@ -1805,6 +2260,40 @@ func testBinding(t *testing.T, query func(db *sql.DB, query string, args ...inte
 	}
 }
 
+func BenchmarkNamedParams(b *testing.B) {
+	db, err := sql.Open(driverName, "file::memory:")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer db.Close()
+
+	const n = 50
+	var cols []string
+	var args []interface{}
+	for i := 0; i < n; i++ {
+		cols = append(cols, fmt.Sprintf(":p%d", i))
+		args = append(args, sql.Named(fmt.Sprintf("p%d", i), i))
+	}
+	s, err := db.Prepare("select " + strings.Join(cols, ", "))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer s.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r, err := s.Query(args...)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		r.Close()
+	}
+}
+
 func TestBindingError(t *testing.T) {
 	t.Run("DB", func(t *testing.T) {
 		testBindingError(t, func(db *sql.DB, query string, args ...interface{}) (*sql.Row, func()) {
@@ -2072,222 +2561,1066 @@ CREATE TABLE IF NOT EXISTS loginst (
 }
 
 // https://gitlab.com/cznic/sqlite/-/issues/37
-func TestPersistPragma(t *testing.T) {
-	tempDir, err := os.MkdirTemp("", "")
+func TestRefreshSchema(t *testing.T) {
+	dir, db := tempDB(t)
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+
+	db2, err := sql.Open(driverName, filepath.Join(dir, "tmp.db"))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	defer func() {
-		os.RemoveAll(tempDir)
-	}()
+	defer db2.Close()
 
-	wd, err := os.Getwd()
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	defer os.Chdir(wd)
+	defer c.Close()
 
-	if err := os.Chdir(tempDir); err != nil {
+	if _, err := c.ExecContext(ctx, "create table t(a)"); err != nil {
 		t.Fatal(err)
 	}
 
-	pragmas := []pragmaCfg{
-		{"foreign_keys", "on", int64(1)},
-		{"analysis_limit", "1000", int64(1000)},
-		{"application_id", "214", int64(214)},
-		{"encoding", "'UTF-16le'", "UTF-16le"}}
-
-	if err := testPragmas("testpersistpragma.sqlite", "testpersistpragma.sqlite", pragmas); err != nil {
+	if _, err := c.ExecContext(ctx, "insert into t values(1)"); err != nil {
 		t.Fatal(err)
 	}
-	if err := testPragmas("file::memory:", "", pragmas); err != nil {
+
+	// Change the schema behind the back of c.
+	if _, err := db2.Exec(`
+	drop table t;
+	create table t(a, b);
+	insert into t values(2, 3);
+	`); err != nil {
 		t.Fatal(err)
 	}
-	if err := testPragmas(":memory:", "", pragmas); err != nil {
+
+	if err := RefreshSchema(c); err != nil {
 		t.Fatal(err)
 	}
-}
 
-type pragmaCfg struct {
-	name     string
-	value    string
-	expected interface{}
-}
+	var a, b int
+	if err := c.QueryRowContext(ctx, "select a, b from t").Scan(&a, &b); err != nil {
+		t.Fatal(err)
+	}
 
-func testPragmas(name, diskFile string, pragmas []pragmaCfg) error {
-	if diskFile != "" {
-		os.Remove(diskFile)
+	if a != 2 || b != 3 {
+		t.Fatalf("got %v, %v, want 2, 3", a, b)
 	}
 
-	q := url.Values{}
-	for _, pragma := range pragmas {
-		q.Add("_pragma", pragma.name+"="+pragma.value)
+	// Without an explicit refresh the change must not surface as an error
+	// either.
+	if _, err := db2.Exec("alter table t add column c"); err != nil {
+		t.Fatal(err)
 	}
 
-	dsn := name + "?" + q.Encode()
-	db, err := sql.Open(driverName, dsn)
-	if err != nil {
-		return err
+	var n int
+	if err := c.QueryRowContext(ctx, "select count(*) from pragma_table_info('t')").Scan(&n); err != nil {
+		t.Fatal(err)
 	}
 
-	db.SetMaxOpenConns(1)
+	if g, e := n, 3; g != e {
+		t.Fatalf("got %v columns, want %v", g, e)
+	}
+}
 
-	if err := checkPragmas(db, pragmas); err != nil {
-		return err
+func TestStatementIsReadOnly(t *testing.T) {
+	db, err := sql.Open(driverName, "file::memory:")
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	c, err := db.Conn(context.Background())
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
 
-	// Kill the connection to spawn a new one. Pragma configs should persist
-	c.Raw(func(interface{}) error { return driver.ErrBadConn })
+	defer c.Close()
 
-	if err := checkPragmas(db, pragmas); err != nil {
-		return err
+	if _, err := c.ExecContext(ctx, "create table t(a)"); err != nil {
+		t.Fatal(err)
 	}
 
-	if diskFile == "" {
-		// Make sure in memory databases aren't being written to disk
-		return testInMemory(db)
+	for _, tt := range []struct {
+		sql  string
+		want bool
+	}{
+		{"select * from t", true},
+		{"insert into t values(1)", false},
+		{"begin", true},
+		{"select 1; delete from t", false},
+		{"-- comment only", true},
+	} {
+		got, err := StatementIsReadOnly(c, tt.sql)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.sql, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.sql, got, tt.want)
+		}
 	}
 
-	return nil
-}
+	if _, err := StatementIsReadOnly(c, "select * from nosuchtable"); err == nil {
+		t.Fatal("expected error")
+	}
 
-func checkPragmas(db *sql.DB, pragmas []pragmaCfg) error {
-	for _, pragma := range pragmas {
-		row := db.QueryRow(`PRAGMA ` + pragma.name)
+	var n int
+	if err := c.QueryRowContext(ctx, "select count(*) from t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
 
-		var result interface{}
-		if err := row.Scan(&result); err != nil {
-			return err
-		}
-		if result != pragma.expected {
-			return fmt.Errorf("expected PRAGMA %s to return %v but got %v", pragma.name, pragma.expected, result)
-		}
+	if n != 0 {
+		t.Fatalf("statements were executed: got %v rows", n)
 	}
-	return nil
 }
 
-func TestInMemory(t *testing.T) {
-	tempDir, err := os.MkdirTemp("", "")
+func TestSchemaChangeHook(t *testing.T) {
+	db, err := sql.Open(driverName, "file::memory:")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	defer func() {
-		os.RemoveAll(tempDir)
-	}()
+	defer db.Close()
 
-	wd, err := os.Getwd()
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	defer os.Chdir(wd)
+	defer c.Close()
 
-	if err := os.Chdir(tempDir); err != nil {
+	var n int
+	if err := RegisterSchemaChangeHook(c, func() { n++ }); err != nil {
 		t.Fatal(err)
 	}
 
-	if err := testMemoryPath(":memory:"); err != nil {
-		t.Fatal(err)
+	for i, tt := range []struct {
+		sql   string
+		query bool
+		want  int
+	}{
+		{"create table t(a)", false, 1},
+		{"insert into t values(1)", false, 1},
+		{"select * from t", true, 1},
+		{"create index t_a on t(a)", true, 2},
+		{"insert into t values(2); alter table t add column b", false, 3},
+	} {
+		if tt.query {
+			rows, err := c.QueryContext(ctx, tt.sql)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for rows.Next() {
+			}
+			if err := rows.Close(); err != nil {
+				t.Fatal(err)
+			}
+		} else if _, err := c.ExecContext(ctx, tt.sql); err != nil {
+			t.Fatal(err)
+		}
+
+		if n != tt.want {
+			t.Fatalf("%v: %q: got %v hook calls, want %v", i, tt.sql, n, tt.want)
+		}
 	}
-	if err := testMemoryPath("file::memory:"); err != nil {
+
+	if err := RegisterSchemaChangeHook(c, nil); err != nil {
 		t.Fatal(err)
 	}
 
-	// This parameter should be ignored
-	q := url.Values{}
-	q.Add("mode", "readonly")
-	if err := testMemoryPath(":memory:?" + q.Encode()); err != nil {
+	if _, err := c.ExecContext(ctx, "drop table t"); err != nil {
 		t.Fatal(err)
 	}
+
+	if n != 3 {
+		t.Fatalf("hook called after removal")
+	}
 }
 
-func testMemoryPath(mPath string) error {
-	db, err := sql.Open(driverName, mPath)
+func TestSchemaVersion(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open(driverName, fn)
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
-	defer db.Close()
 
-	return testInMemory(db)
-}
+	defer db.Close()
 
-func testInMemory(db *sql.DB) error {
-	_, err := db.Exec(`
-	create table in_memory_test(i int, f double);
-	insert into in_memory_test values(12, 3.14);
-	`)
+	db2, err := sql.Open(driverName, fn+"?_pragma=busy_timeout(0)")
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
 
-	dirEntries, err := os.ReadDir("./")
+	defer db2.Close()
+
+	ctx := context.Background()
+	c, err := db2.Conn(ctx)
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
 
-	for _, dirEntry := range dirEntries {
-		if strings.Contains(dirEntry.Name(), "memory") {
-			return fmt.Errorf("file was created for in memory database")
-		}
+	defer c.Close()
+
+	v, err := SchemaVersion(c)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	return nil
-}
+	if _, err := db.Exec("create table t(i)"); err != nil {
+		t.Fatal(err)
+	}
 
-func emptyDir(s string) error {
-	m, err := filepath.Glob(filepath.FromSlash(s + "/*"))
+	v2, err := SchemaVersion(c)
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
 
-	for _, v := range m {
-		fi, err := os.Stat(v)
-		if err != nil {
-			return err
-		}
+	if v2 <= v {
+		t.Fatalf("got schema version %v after DDL on another connection, want > %v", v2, v)
+	}
 
-		switch {
-		case fi.IsDir():
-			if err = os.RemoveAll(v); err != nil {
-				return err
-			}
-		default:
-			if err = os.Remove(v); err != nil {
-				return err
-			}
-		}
+	if _, err := db.Exec("insert into t values(1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if v3, err := SchemaVersion(c); err != nil || v3 != v2 {
+		t.Fatalf("got schema version %v, %v after an insert, want %v", v3, err, v2)
 	}
-	return nil
 }
 
-// https://gitlab.com/cznic/sqlite/-/issues/70
-func TestIssue70(t *testing.T) {
+func TestQueryVMSteps(t *testing.T) {
 	db, err := sql.Open(driverName, "file::memory:")
-	if _, err = db.Exec(`create table t (foo)`); err != nil {
-		t.Fatalf("create: %v", err)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	defer func() {
-		if err := db.Close(); err != nil {
-			t.Errorf("conn close: %v", err)
-		}
-	}()
+	defer db.Close()
 
-	r, err := db.Query("select * from t")
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
 	if err != nil {
-		t.Errorf("select a: %v", err)
-		return
+		t.Fatal(err)
 	}
 
-	if err := r.Close(); err != nil {
+	defer c.Close()
+
+	if _, err := c.ExecContext(ctx, `
+	create table t(a, b);
+	insert into t with recursive n(i) as (select 1 union all select i+1 from n where i < 1000) select i, i from n;
+	create index t_a on t(a);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	indexed, err := QueryVMSteps(ctx, c, "select * from t where a = ?", 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scan, err := QueryVMSteps(ctx, c, "select * from t where b = ?", 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("indexed %v, scan %v", indexed, scan)
+	if indexed <= 0 || scan < 10*indexed {
+		t.Fatalf("unexpected VM steps: indexed %v, scan %v", indexed, scan)
+	}
+
+	again, err := QueryVMSteps(ctx, c, "select * from t where a = ?", 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again != indexed {
+		t.Fatalf("got %v, want %v", again, indexed)
+	}
+
+	if _, err := QueryVMSteps(ctx, c, "select * from nosuchtable"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestSetBusyTimeout(t *testing.T) {
+	db, err := sql.Open(driverName, "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	timeout := func() (ms int64) {
+		if err := c.QueryRowContext(ctx, "pragma busy_timeout").Scan(&ms); err != nil {
+			t.Fatal(err)
+		}
+
+		return ms
+	}
+
+	prev, err := SetBusyTimeout(c, 1500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := prev, 5*time.Second; g != e {
+		t.Fatalf("got previous timeout %v, want %v", g, e)
+	}
+
+	if g, e := timeout(), int64(1500); g != e {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	if _, err := c.ExecContext(ctx, "pragma busy_timeout = 250"); err != nil {
+		t.Fatal(err)
+	}
+
+	if prev, err = SetBusyTimeout(c, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := prev, 250*time.Millisecond; g != e {
+		t.Fatalf("got previous timeout %v, want %v", g, e)
+	}
+
+	if _, err := SetBusyTimeout(c, prev); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := timeout(), int64(250); g != e {
+		t.Fatalf("restored: got %v, want %v", g, e)
+	}
+
+	// Timeouts too long for SQLite are capped instead of wrapping around.
+	if _, err := SetBusyTimeout(c, 30*24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := timeout(), int64(math.MaxInt32); g != e {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	if err := c.Raw(func(driverConn interface{}) error {
+		if g, e := driverConn.(*conn).busyTimeout, math.MaxInt32*time.Millisecond; g != e {
+			t.Fatalf("got %v, want %v", g, e)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A COMMIT failing with SQLITE_BUSY leaves the transaction open, which
+// tx.Commit rolls back, so that the connection can be reused.
+func TestCommitBusy(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.db")
+	reader, err := sql.Open(driverName, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reader.Close()
+
+	if _, err := reader.Exec("pragma journal_mode = delete; create table t(i)"); err != nil {
+		t.Fatal(err)
+	}
+
+	writer, err := sql.Open(driverName, fn+"?_pragma=busy_timeout(0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer writer.Close()
+
+	writer.SetMaxOpenConns(1)
+
+	// The SHARED lock of the read transaction prevents the commit.
+	rtx, err := reader.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rtx.Rollback()
+
+	var n int
+	if err := rtx.QueryRow("select count(*) from t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := writer.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tx.Exec("insert into t values(1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx.Commit()
+	var e *Error
+	if !errors.As(err, &e) || e.Code()&0xff != sqlite3.SQLITE_BUSY {
+		t.Fatalf("got %v, want SQLITE_BUSY", err)
+	}
+
+	ctx := context.Background()
+	c, err := writer.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Raw(func(driverConn interface{}) error {
+		dc := driverConn.(*conn)
+		if sqlite3.Xsqlite3_get_autocommit(dc.tls, dc.db) == 0 {
+			t.Fatal("transaction left open")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+	if err := rtx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection is reused and the failed insert is gone.
+	if _, err := writer.Exec("insert into t values(2)"); err != nil {
+		t.Fatal(err)
+	}
+
+	var s string
+	if err := reader.QueryRow("select group_concat(i) from t").Scan(&s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s != "2" {
+		t.Fatalf("got %q, want 2", s)
+	}
+}
+
+func TestBusyHint(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open(driverName, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db2, err := sql.Open(driverName, fn+"?_pragma=busy_timeout(0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db2.Close()
+
+	ctx := context.Background()
+	c, err := db2.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("create table t(i)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The DSN turned the busy timeout off.
+	_, err = c.ExecContext(ctx, "create table u(i)")
+	if err == nil || !strings.Contains(err.Error(), busyHint) {
+		t.Fatalf("got %v, want the hint", err)
+	}
+
+	// No hint once a timeout is set, or when SetBusyTimeout chose not to wait.
+	for _, timeout := range []time.Duration{time.Millisecond, 0} {
+		if _, err := SetBusyTimeout(c, timeout); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := c.ExecContext(ctx, "create table u(i)")
+		if err == nil {
+			t.Fatal("unexpected success")
+		}
+
+		if strings.Contains(err.Error(), busyHint) {
+			t.Fatalf("timeout %v: %v", timeout, err)
+		}
+	}
+}
+
+func TestResetDatabase(t *testing.T) {
+	db, err := sql.Open(driverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if _, err := c.ExecContext(ctx, "create table t(i); create index x on t(i); create view v as select * from t; insert into t values(1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ResetDatabase(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := c.QueryRowContext(ctx, "select count(*) from sqlite_schema").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 0 {
+		t.Fatalf("got %v schema entries, want 0", n)
+	}
+
+	// The connection remains usable.
+	if _, err := c.ExecContext(ctx, "create table t(i); insert into t values(2)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.QueryRowContext(ctx, "select i from t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 2 {
+		t.Fatalf("got %v, want 2", n)
+	}
+}
+
+func TestSeedRandom(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	defer SeedRandom(0)
+
+	sequence := func(seed int32) (r []int64) {
+		if err := SeedRandom(seed); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 5; i++ {
+			var n int64
+			if err := db.QueryRow("select random()").Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+
+			r = append(r, n)
+		}
+		return r
+	}
+
+	a := sequence(42)
+	if b := sequence(42); !reflect.DeepEqual(a, b) {
+		t.Fatalf("got %v and %v", a, b)
+	}
+
+	if c := sequence(43); reflect.DeepEqual(a, c) {
+		t.Fatalf("different seeds, same sequence %v", a)
+	}
+}
+
+func TestStats(t *testing.T) {
+	db, err := sql.Open(driverName, "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	stats := func() ConnStats {
+		s, err := Stats(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return s
+	}
+
+	if _, err := c.ExecContext(ctx, "create table t(i); insert into t values(1), (2), (3)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ResetStats(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := stats(); s != (ConnStats{}) {
+		t.Fatalf("after reset: got %+v", s)
+	}
+
+	rows, err := c.QueryContext(ctx, "select i from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := c.PrepareContext(ctx, "update t set i = i + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := stats().OpenStatements, 1; g != e {
+		t.Fatalf("got %v open statements, want %v", g, e)
+	}
+
+	for rows.Next() {
+	}
+	rows.Close()
+	if _, err := stmt.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	stmt.Close()
+	s := stats()
+	t.Logf("%+v", s)
+	if s.OpenStatements != 0 || s.TotalSteps == 0 || s.TotalChanges != 3 {
+		t.Fatalf("unexpected %+v", s)
+	}
+}
+
+func TestPersistPragma(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		os.RemoveAll(tempDir)
+	}()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Chdir(wd)
+
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatal(err)
+	}
+
+	pragmas := []pragmaCfg{
+		{"foreign_keys", "on", int64(1)},
+		{"analysis_limit", "1000", int64(1000)},
+		{"application_id", "214", int64(214)},
+		{"encoding", "'UTF-16le'", "UTF-16le"}}
+
+	if err := testPragmas("testpersistpragma.sqlite", "testpersistpragma.sqlite", pragmas); err != nil {
+		t.Fatal(err)
+	}
+	if err := testPragmas("file::memory:", "", pragmas); err != nil {
+		t.Fatal(err)
+	}
+	if err := testPragmas(":memory:", "", pragmas); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type pragmaCfg struct {
+	name     string
+	value    string
+	expected interface{}
+}
+
+func testPragmas(name, diskFile string, pragmas []pragmaCfg) error {
+	if diskFile != "" {
+		os.Remove(diskFile)
+	}
+
+	q := url.Values{}
+	for _, pragma := range pragmas {
+		q.Add("_pragma", pragma.name+"="+pragma.value)
+	}
+
+	dsn := name + "?" + q.Encode()
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return err
+	}
+
+	db.SetMaxOpenConns(1)
+
+	if err := checkPragmas(db, pragmas); err != nil {
+		return err
+	}
+
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+
+	// Kill the connection to spawn a new one. Pragma configs should persist
+	c.Raw(func(interface{}) error { return driver.ErrBadConn })
+
+	if err := checkPragmas(db, pragmas); err != nil {
+		return err
+	}
+
+	if diskFile == "" {
+		// Make sure in memory databases aren't being written to disk
+		return testInMemory(db)
+	}
+
+	return nil
+}
+
+func checkPragmas(db *sql.DB, pragmas []pragmaCfg) error {
+	for _, pragma := range pragmas {
+		row := db.QueryRow(`PRAGMA ` + pragma.name)
+
+		var result interface{}
+		if err := row.Scan(&result); err != nil {
+			return err
+		}
+		if result != pragma.expected {
+			return fmt.Errorf("expected PRAGMA %s to return %v but got %v", pragma.name, pragma.expected, result)
+		}
+	}
+	return nil
+}
+
+func TestInMemory(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		os.RemoveAll(tempDir)
+	}()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Chdir(wd)
+
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testMemoryPath(":memory:"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testMemoryPath("file::memory:"); err != nil {
+		t.Fatal(err)
+	}
+
+	// This parameter should be ignored
+	q := url.Values{}
+	q.Add("mode", "readonly")
+	if err := testMemoryPath(":memory:?" + q.Encode()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNamedInMemory(t *testing.T) {
+	open := func(name string) *sql.DB {
+		db, err := sql.Open(driverName, "file:"+name+"?mode=memory")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return db
+	}
+
+	a := open("testnamedinmemorya")
+	defer a.Close()
+
+	b := open("testnamedinmemoryb")
+	defer b.Close()
+
+	if _, err := a.Exec("create table t(s); insert into t values('a')"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Exec("create table t(s); insert into t values('b1'), ('b2')"); err != nil {
+		t.Fatal(err)
+	}
+
+	// All connections of the pool see the same database.
+	ctx := context.Background()
+	var conns []*sql.Conn
+	for i := 0; i < 3; i++ {
+		c, err := a.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer c.Close()
+
+		conns = append(conns, c)
+		var s string
+		if err := c.QueryRowContext(ctx, "select s from t").Scan(&s); err != nil {
+			t.Fatal(err)
+		}
+
+		if s != "a" {
+			t.Fatalf("got %q, want %q", s, "a")
+		}
+	}
+
+	if _, err := conns[0].ExecContext(ctx, "attach 'file:testnamedinmemoryb?mode=memory' as b"); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := conns[0].QueryRowContext(ctx, "select count(*) from t join b.t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 2 {
+		t.Fatalf("got %v rows, want 2", n)
+	}
+}
+
+func TestReadOnlySharedCache(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open(driverName, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(`
+	create table t(i int, s text);
+	with recursive c(i) as (select 1 union all select i+1 from c where i < 1000)
+	insert into t select i, hex(randomblob(100)) from c;
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Close()
+
+	const ndbs = 4
+	var dbs []*sql.DB
+	for i := 0; i < ndbs; i++ {
+		db, err := sql.Open(driverName, fn+"?cache=shared&mode=ro")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer db.Close()
+
+		dbs = append(dbs, db)
+	}
+
+	if _, err := dbs[0].Exec("insert into t values(0, '')"); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	const query = "select count(*), sum(i) from t where length(s) = 200"
+	check := func(q Querier) error {
+		var n, sum int
+		if err := q.QueryRowContext(context.Background(), query).Scan(&n, &sum); err != nil {
+			return err
+		}
+
+		if n != 1000 || sum != 500500 {
+			return fmt.Errorf("got %v, %v, want 1000, 500500", n, sum)
+		}
+
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*ndbs)
+	for i := 0; i < 4*ndbs; i++ {
+		wg.Add(1)
+		go func(db *sql.DB) {
+			defer wg.Done()
+
+			for j := 0; j < 20; j++ {
+				if err := check(db); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(dbs[i%ndbs])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// Connections of different *sql.DB handles share the page cache, so
+	// SQLITE_DBSTATUS_CACHE_USED_SHARED attributes only part of it to each of
+	// them.
+	ctx := context.Background()
+	var conns []*sql.Conn
+	for _, db := range dbs[:2] {
+		c, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer c.Close()
+
+		if err := check(c); err != nil {
+			t.Fatal(err)
+		}
+
+		conns = append(conns, c)
+	}
+
+	var used, usedShared int32
+	if err := conns[0].Raw(func(driverConn interface{}) error {
+		c := driverConn.(*conn)
+		p, err := c.malloc(8)
+		if err != nil {
+			return err
+		}
+
+		defer c.free(p)
+		for _, v := range []struct {
+			op  int32
+			dst *int32
+		}{
+			{sqlite3.SQLITE_DBSTATUS_CACHE_USED, &used},
+			{sqlite3.SQLITE_DBSTATUS_CACHE_USED_SHARED, &usedShared},
+		} {
+			if rc := sqlite3.Xsqlite3_db_status(c.tls, c.db, v.op, p, p+4, 0); rc != sqlite3.SQLITE_OK {
+				return c.errstr(rc)
+			}
+
+			*v.dst = *(*int32)(unsafe.Pointer(p))
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if usedShared >= used {
+		t.Fatalf("page cache is not shared: used %v, used shared %v", used, usedShared)
+	}
+}
+
+func testMemoryPath(mPath string) error {
+	db, err := sql.Open(driverName, mPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return testInMemory(db)
+}
+
+func testInMemory(db *sql.DB) error {
+	_, err := db.Exec(`
+	create table in_memory_test(i int, f double);
+	insert into in_memory_test values(12, 3.14);
+	`)
+	if err != nil {
+		return err
+	}
+
+	dirEntries, err := os.ReadDir("./")
+	if err != nil {
+		return err
+	}
+
+	for _, dirEntry := range dirEntries {
+		if strings.Contains(dirEntry.Name(), "memory") {
+			return fmt.Errorf("file was created for in memory database")
+		}
+	}
+
+	return nil
+}
+
+func emptyDir(s string) error {
+	m, err := filepath.Glob(filepath.FromSlash(s + "/*"))
+	if err != nil {
+		return err
+	}
+
+	for _, v := range m {
+		fi, err := os.Stat(v)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case fi.IsDir():
+			if err = os.RemoveAll(v); err != nil {
+				return err
+			}
+		default:
+			if err = os.Remove(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// https://gitlab.com/cznic/sqlite/-/issues/70
+func TestIssue70(t *testing.T) {
+	db, err := sql.Open(driverName, "file::memory:")
+	if _, err = db.Exec(`create table t (foo)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("conn close: %v", err)
+		}
+	}()
+
+	r, err := db.Query("select * from t")
+	if err != nil {
+		t.Errorf("select a: %v", err)
+		return
+	}
+
+	if err := r.Close(); err != nil {
 		t.Errorf("rows close: %v", err)
 		return
 	}
@@ -2297,6 +3630,243 @@ func TestIssue70(t *testing.T) {
 	}
 }
 
+// A statement failing mid-iteration reports its error from rows.Next and
+// rows.Close and is not restarted by further steps.
+func TestRowsStepError(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+
+	// abs() of the second row fails with integer overflow mid-iteration.
+	if _, err = db.Exec(`create table t (foo); insert into t values (1), (-9223372036854775808), (2)`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	r, err := db.Query("select abs(foo) from t order by rowid")
+	if err != nil {
+		t.Fatalf("select: %v", err)
+	}
+
+	n := 0
+	for r.Next() {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("got %v rows, want 1", n)
+	}
+
+	if err := r.Err(); err == nil || !strings.Contains(err.Error(), "integer overflow") {
+		t.Errorf("rows err: got %v, want integer overflow", err)
+	}
+
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	err = c.Raw(func(driverConn interface{}) error {
+		rows, err := driverConn.(driver.QueryerContext).QueryContext(context.Background(), "select abs(foo) from t order by rowid", nil)
+		if err != nil {
+			return err
+		}
+
+		dest := make([]driver.Value, 1)
+		if err := rows.Next(dest); err != nil {
+			return err
+		}
+
+		stepErr := rows.Next(dest)
+		if stepErr == nil {
+			return fmt.Errorf("expected an error on the second row")
+		}
+
+		// the statement must not be restarted by another step
+		if err := rows.Next(dest); err != stepErr {
+			return fmt.Errorf("next after error: got %v, want %v", err, stepErr)
+		}
+
+		if err := rows.Close(); err != stepErr {
+			return fmt.Errorf("close: got %v, want %v", err, stepErr)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestColumnError(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("create table t(d datetime, n); insert into t values('not a time', 1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	var tm time.Time
+	if err := db.QueryRow("select d from t").Scan(&tm); err == nil || !strings.Contains(err.Error(), `column index 0, name "d"`) {
+		t.Fatalf("got %v, want a scan error naming the column", err)
+	}
+
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	err = c.Raw(func(driverConn interface{}) error {
+		dr, err := driverConn.(driver.QueryerContext).QueryContext(context.Background(), "select d, n from t", nil)
+		if err != nil {
+			return err
+		}
+
+		defer dr.Close()
+
+		r := dr.(*rows)
+		cause := fmt.Errorf("boom")
+		for i, want := range []string{
+			`sqlite: Next: column 0 "d" (DATETIME): boom`,
+			`sqlite: Next: column 1 "n" (no declared type): boom`,
+		} {
+			if err := r.columnError(i, cause); err.Error() != want || !errors.Is(err, cause) {
+				return fmt.Errorf("got %v, want %v", err, want)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRowsBusy(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if err := c.Raw(func(driverConn interface{}) error {
+		if _, err := driverConn.(driver.ExecerContext).ExecContext(ctx, "create table t(i); insert into t values(1), (2)", nil); err != nil {
+			return err
+		}
+
+		r, err := driverConn.(driver.QueryerContext).QueryContext(ctx, "select i from t order by i", nil)
+		if err != nil {
+			return err
+		}
+
+		rows := r.(interface{ Busy() bool })
+		dest := make([]driver.Value, 1)
+		for i, want := range []bool{false, true, true, false} {
+			if i != 0 {
+				if err := r.Next(dest); err != nil && err != io.EOF {
+					return err
+				}
+			}
+
+			if g := rows.Busy(); g != want {
+				return fmt.Errorf("%v: got busy %v, want %v", i, g, want)
+			}
+		}
+
+		if err := r.Close(); err != nil {
+			return err
+		}
+
+		if rows.Busy() {
+			return fmt.Errorf("busy after Close")
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestColumnValue(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if err := c.Raw(func(driverConn interface{}) error {
+		execer := driverConn.(driver.ExecerContext)
+		if _, err := execer.ExecContext(ctx, "create table src(v); create table dst(v); insert into src values(1), ('2'), (3.5), (x'04'), (null)", nil); err != nil {
+			return err
+		}
+
+		r, err := driverConn.(driver.QueryerContext).QueryContext(ctx, "select v from src order by rowid", nil)
+		if err != nil {
+			return err
+		}
+
+		defer r.Close()
+
+		rows := r.(interface{ ColumnValue(int) Value })
+		dest := make([]driver.Value, 1)
+		for {
+			if err := r.Next(dest); err != nil {
+				if err == io.EOF {
+					break
+				}
+
+				return err
+			}
+
+			if _, err := execer.ExecContext(ctx, "insert into dst values(?)", []driver.NamedValue{{Ordinal: 1, Value: rows.ColumnValue(0)}}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	if err := c.QueryRowContext(ctx, "select group_concat(typeof(v) || ':' || quote(v), ' ') from dst").Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "integer:1 text:'2' real:3.5 blob:X'04' null:NULL"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
 // https://gitlab.com/cznic/sqlite/-/issues/66
 func TestIssue66(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "")
@@ -0,0 +1,70 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"database/sql/driver"
+	"time"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// AuditEvent describes a statement executed by Exec or Query, see Audit.
+type AuditEvent struct {
+	SQL   string // The SQL text, which may consist of several statements.
+	Args  int    // Number of arguments passed.
+	Query bool   // Executed by Query, not Exec.
+
+	// RowsAffected is the number of rows inserted, updated or deleted, as
+	// reported by sql.Result.RowsAffected for Exec. For Query, it is the
+	// number of rows changed on the connection until the rows are closed,
+	// eg. by an INSERT ... RETURNING.
+	RowsAffected int64
+
+	// Duration is the time the execution took. For Query, it lasts until
+	// the rows are closed.
+	Duration time.Duration
+
+	// Err is the error of the execution, if any, including the error of
+	// iterating and closing the rows for Query.
+	Err error
+}
+
+// auditExec runs exec, the Exec of query with args, and reports it to the
+// Audit callback.
+func (c *conn) auditExec(query string, args []driver.NamedValue, exec func() (driver.Result, error)) (driver.Result, error) {
+	start := time.Now()
+	r, err := exec()
+	e := AuditEvent{SQL: query, Args: len(args), Duration: time.Since(start), Err: err}
+	if err == nil {
+		e.RowsAffected, _ = r.RowsAffected()
+	}
+	c.audit(e)
+	return r, err
+}
+
+// auditQuery runs query, the Query of sql with args. The Audit callback is
+// called when the rows are closed, or right away if query fails.
+func (c *conn) auditQuery(sql string, args []driver.NamedValue, query func() (driver.Rows, error)) (driver.Rows, error) {
+	start := time.Now()
+	changes := int64(sqlite3.Xsqlite3_total_changes64(c.tls, c.db))
+	r, err := query()
+	if err != nil {
+		c.audit(AuditEvent{SQL: sql, Args: len(args), Query: true, Duration: time.Since(start), Err: err})
+		return nil, err
+	}
+
+	r.(*rows).audit = func(err error) {
+		c.audit(AuditEvent{
+			SQL:          sql,
+			Args:         len(args),
+			Query:        true,
+			RowsAffected: int64(sqlite3.Xsqlite3_total_changes64(c.tls, c.db)) - changes,
+			Duration:     time.Since(start),
+			Err:          err,
+		})
+	}
+	return r, nil
+}
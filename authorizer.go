@@ -0,0 +1,119 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"database/sql"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Action codes passed to the SetAuthorizer callback, the SQLITE_CREATE_INDEX,
+// ..., SQLITE_RECURSIVE constants of the C API. The comments list the
+// arguments of the callback for each action.
+const (
+	AuthCreateIndex       = sqlite3.SQLITE_CREATE_INDEX        // index name, table name
+	AuthCreateTable       = sqlite3.SQLITE_CREATE_TABLE        // table name, ""
+	AuthCreateTempIndex   = sqlite3.SQLITE_CREATE_TEMP_INDEX   // index name, table name
+	AuthCreateTempTable   = sqlite3.SQLITE_CREATE_TEMP_TABLE   // table name, ""
+	AuthCreateTempTrigger = sqlite3.SQLITE_CREATE_TEMP_TRIGGER // trigger name, table name
+	AuthCreateTempView    = sqlite3.SQLITE_CREATE_TEMP_VIEW    // view name, ""
+	AuthCreateTrigger     = sqlite3.SQLITE_CREATE_TRIGGER      // trigger name, table name
+	AuthCreateView        = sqlite3.SQLITE_CREATE_VIEW         // view name, ""
+	AuthDelete            = sqlite3.SQLITE_DELETE              // table name, ""
+	AuthDropIndex         = sqlite3.SQLITE_DROP_INDEX          // index name, table name
+	AuthDropTable         = sqlite3.SQLITE_DROP_TABLE          // table name, ""
+	AuthDropTempIndex     = sqlite3.SQLITE_DROP_TEMP_INDEX     // index name, table name
+	AuthDropTempTable     = sqlite3.SQLITE_DROP_TEMP_TABLE     // table name, ""
+	AuthDropTempTrigger   = sqlite3.SQLITE_DROP_TEMP_TRIGGER   // trigger name, table name
+	AuthDropTempView      = sqlite3.SQLITE_DROP_TEMP_VIEW      // view name, ""
+	AuthDropTrigger       = sqlite3.SQLITE_DROP_TRIGGER        // trigger name, table name
+	AuthDropView          = sqlite3.SQLITE_DROP_VIEW           // view name, ""
+	AuthInsert            = sqlite3.SQLITE_INSERT              // table name, ""
+	AuthPragma            = sqlite3.SQLITE_PRAGMA              // pragma name, 1st argument or ""
+	AuthRead              = sqlite3.SQLITE_READ                // table name, column name
+	AuthSelect            = sqlite3.SQLITE_SELECT              // "", ""
+	AuthTransaction       = sqlite3.SQLITE_TRANSACTION         // operation, ""
+	AuthUpdate            = sqlite3.SQLITE_UPDATE              // table name, column name
+	AuthAttach            = sqlite3.SQLITE_ATTACH              // file name, ""
+	AuthDetach            = sqlite3.SQLITE_DETACH              // database name, ""
+	AuthAlterTable        = sqlite3.SQLITE_ALTER_TABLE         // database name, table name
+	AuthReindex           = sqlite3.SQLITE_REINDEX             // index name, ""
+	AuthAnalyze           = sqlite3.SQLITE_ANALYZE             // table name, ""
+	AuthCreateVTable      = sqlite3.SQLITE_CREATE_VTABLE       // table name, module name
+	AuthDropVTable        = sqlite3.SQLITE_DROP_VTABLE         // table name, module name
+	AuthFunction          = sqlite3.SQLITE_FUNCTION            // "", function name
+	AuthSavepoint         = sqlite3.SQLITE_SAVEPOINT           // operation, savepoint name
+	AuthRecursive         = sqlite3.SQLITE_RECURSIVE           // "", ""
+)
+
+// Return codes of the SetAuthorizer callback.
+const (
+	AuthOK     = sqlite3.SQLITE_OK     // allow the action
+	AuthDeny   = sqlite3.SQLITE_DENY   // fail the statement with SQLITE_AUTH
+	AuthIgnore = sqlite3.SQLITE_IGNORE // eg. read NULL instead of the column, see the C API
+)
+
+// authorizerFunc is the callback of SetAuthorizer.
+type authorizerFunc func(action int, arg1, arg2, dbName, trigger string) int
+
+// int sqlite3_set_authorizer(
+//
+//	sqlite3*,
+//	int (*xAuth)(void*,int,const char*,const char*,const char*,const char*),
+//	void *pUserData
+//
+// );
+func (c *conn) setAuthorizer(fn authorizerFunc) error {
+	var xAuth, h uintptr
+	if fn != nil {
+		xAuth = *(*uintptr)(unsafe.Pointer(&struct {
+			f func(*libc.TLS, uintptr, int32, uintptr, uintptr, uintptr, uintptr) int32
+		}{authorize}))
+		h = newHandle(fn)
+	}
+
+	if rc := sqlite3.Xsqlite3_set_authorizer(c.tls, c.db, xAuth, h); rc != sqlite3.SQLITE_OK {
+		if h != 0 {
+			deleteHandle(h)
+		}
+		return c.errstr(rc)
+	}
+
+	if c.authorizer != 0 {
+		deleteHandle(c.authorizer)
+	}
+	c.authorizer = h
+	return nil
+}
+
+// authorize is the xAuth callback installed by setAuthorizer.
+func authorize(tls *libc.TLS, h uintptr, action int32, zArg1, zArg2, zDb, zTrigger uintptr) int32 {
+	fn := handleValue(h).(authorizerFunc)
+	return int32(fn(int(action), libc.GoString(zArg1), libc.GoString(zArg2), libc.GoString(zDb), libc.GoString(zTrigger)))
+}
+
+// SetAuthorizer sets fn as the authorizer of the connection c, replacing the
+// previous one, or removes it if fn is nil. SQLite calls fn while preparing
+// statements, for every action they would take, eg. reading a column or
+// attaching a database, so that an application running untrusted SQL can
+// restrict it. The action is one of the Auth* action codes and arg1 and arg2
+// depend on it, dbName is the name of the database, eg. "main", and trigger
+// is the name of the trigger or view causing the action, if any; the
+// arguments that do not apply are empty.
+//
+// fn returns AuthOK to allow the action, AuthDeny to make the preparation,
+// and so Exec or Query, fail with an *Error with code SQLITE_AUTH, or
+// AuthIgnore to disallow the action silently, eg. reading NULL instead of a
+// column. fn must not use c.
+//
+// Statements are prepared when executed, so fn applies to the statements
+// executed after SetAuthorizer, including those of a sql.Stmt prepared
+// before.
+func SetAuthorizer(c *sql.Conn, fn func(action int, arg1, arg2, dbName, trigger string) int) error {
+	return withConn(c, func(c *conn) error { return c.setAuthorizer(fn) })
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func TestSetAuthorizer(t *testing.T) {
+	db, err := sql.Open(driverName, "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if _, err := c.ExecContext(ctx, "create table t(name, password); insert into t values('a', 'secret'); create table log(s)"); err != nil {
+		t.Fatal(err)
+	}
+
+	var actions []int
+	if err := SetAuthorizer(c, func(action int, arg1, arg2, dbName, trigger string) int {
+		actions = append(actions, action)
+		switch {
+		case action == AuthAttach:
+			return AuthDeny
+		case action == AuthInsert && arg1 == "t":
+			return AuthDeny
+		case action == AuthRead && arg1 == "t" && arg2 == "password":
+			return AuthIgnore
+		}
+		return AuthOK
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{
+		"attach ':memory:' as other",
+		"insert into t values('b', 'x')",
+	} {
+		_, err := c.ExecContext(ctx, query)
+		if e, ok := err.(*Error); !ok || e.Code() != sqlite3.SQLITE_AUTH {
+			t.Fatalf("%q: got %v, want SQLITE_AUTH", query, err)
+		}
+	}
+
+	if _, err := c.ExecContext(ctx, "insert into log values('ok')"); err != nil {
+		t.Fatal(err)
+	}
+
+	var name string
+	var password sql.NullString
+	if err := c.QueryRowContext(ctx, "select name, password from t").Scan(&name, &password); err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "a" || password.Valid {
+		t.Fatalf("got %q, %v, want the password hidden", name, password)
+	}
+
+	if len(actions) == 0 {
+		t.Fatal("authorizer not called")
+	}
+
+	if err := SetAuthorizer(c, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	n := len(actions)
+	if _, err := c.ExecContext(ctx, "insert into t values('b', 'x')"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(actions) != n {
+		t.Fatal("authorizer called after removal")
+	}
+}
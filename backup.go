@@ -0,0 +1,306 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	// backupStepPages is the number of pages copied by one step of
+	// BackupDatabase. The context is checked between the steps.
+	backupStepPages = 256
+	// backupBusyDelay is how long BackupDatabase waits before retrying a
+	// step that failed because a database was busy or locked.
+	backupBusyDelay = 10 * time.Millisecond
+)
+
+// backup is an online backup from a source to a destination connection.
+type backup struct {
+	src, dst *conn
+	p        uintptr // *sqlite3_backup
+}
+
+// sqlite3_backup *sqlite3_backup_init(
+//
+//	sqlite3 *pDest,                        /* Destination database handle */
+//	const char *zDestName,                 /* Destination database name */
+//	sqlite3 *pSource,                      /* Source database handle */
+//	const char *zSourceName                /* Source database name */
+//
+// );
+func (c *conn) backupInit(dst *conn, dstName, srcName string) (*backup, error) {
+	zDst, err := libc.CString(dstName)
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.free(zDst)
+
+	zSrc, err := libc.CString(srcName)
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.free(zSrc)
+
+	p := sqlite3.Xsqlite3_backup_init(dst.tls, dst.db, zDst, c.db, zSrc)
+	if p == 0 {
+		return nil, dst.errstr(sqlite3.Xsqlite3_errcode(dst.tls, dst.db))
+	}
+
+	return &backup{src: c, dst: dst, p: p}, nil
+}
+
+// int sqlite3_backup_step(sqlite3_backup *p, int nPage);
+func (b *backup) step(nPage int) (done bool, err error) {
+	switch rc := sqlite3.Xsqlite3_backup_step(b.dst.tls, b.p, int32(nPage)); rc {
+	case sqlite3.SQLITE_OK:
+		return false, nil
+	case sqlite3.SQLITE_DONE:
+		return true, nil
+	default:
+		return false, b.dst.errstr(rc)
+	}
+}
+
+// int sqlite3_backup_finish(sqlite3_backup *p);
+func (b *backup) finish() error {
+	if b.p == 0 {
+		return nil
+	}
+
+	rc := sqlite3.Xsqlite3_backup_finish(b.dst.tls, b.p)
+	b.p = 0
+	if rc != sqlite3.SQLITE_OK {
+		return b.dst.errstr(rc)
+	}
+
+	return nil
+}
+
+// int sqlite3_backup_remaining(sqlite3_backup *p);
+func (b *backup) remaining() int {
+	return int(sqlite3.Xsqlite3_backup_remaining(b.dst.tls, b.p))
+}
+
+// int sqlite3_backup_pagecount(sqlite3_backup *p);
+func (b *backup) pageCount() int {
+	return int(sqlite3.Xsqlite3_backup_pagecount(b.dst.tls, b.p))
+}
+
+// BackupDatabase copies the database srcName of the connection src to the
+// database dstName of the connection dst using the online backup API,
+// https://www.sqlite.org/backup.html. The names are "main", "temp" or the
+// name of an attached database. The source may be used by other connections
+// while the backup runs; a write to it from another connection restarts the
+// backup.
+//
+// The pages are copied in steps and ctx is checked between them. When ctx is
+// done, or a step fails with an error other than SQLITE_BUSY or SQLITE_LOCKED,
+// which are retried, the backup is abandoned, its resources are released and
+// ctx.Err(), or the error, is returned. The destination is then left as it
+// was before the backup started, any pages already copied are rolled back.
+func BackupDatabase(ctx context.Context, dst, src *sql.Conn, dstName, srcName string) error {
+	return withConn(dst, func(dst *conn) error {
+		return withConn(src, func(src *conn) error {
+			return src.backup(ctx, dst, dstName, srcName)
+		})
+	})
+}
+
+func (c *conn) backup(ctx context.Context, dst *conn, dstName, srcName string) (err error) {
+	b, err := c.backupInit(dst, dstName, srcName)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err2 := b.finish(); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		done, err := b.step(backupStepPages)
+		if done {
+			return nil
+		}
+
+		if err != nil {
+			if e, ok := err.(*Error); !ok || e.code&0xff != sqlite3.SQLITE_BUSY && e.code&0xff != sqlite3.SQLITE_LOCKED {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backupBusyDelay):
+			}
+		}
+	}
+}
+
+// errBackupFinished is returned by Backup.Step after Finish.
+var errBackupFinished = errors.New("sqlite: backup already finished")
+
+// Backup is an online backup started by NewBackup and stepped by the caller.
+type Backup struct {
+	mu sync.Mutex
+	b  *backup
+}
+
+// backups guards the conn.backups sets of all connections.
+var backups sync.Mutex
+
+// NewBackup starts an online backup of the database srcName of the connection
+// src to the database dstName of the connection dst, see BackupDatabase for
+// the names. Unlike BackupDatabase, it leaves stepping to the caller, eg. to
+// report progress or to control the pace of the backup:
+//
+//	b, err := sqlite.NewBackup(dst, src, "main", "main")
+//	...
+//	defer b.Finish()
+//	for {
+//		done, err := b.Step(100)
+//		...
+//		log.Printf("%d of %d pages left", b.Remaining(), b.PageCount())
+//	}
+//
+// Neither connection may be used by anything else than the backup until
+// Finish, which must always be called. A backup still unfinished when either
+// connection is closed is finished by Close, so that its resources do not
+// leak.
+func NewBackup(dst, src *sql.Conn, dstName, srcName string) (r *Backup, err error) {
+	err = withConn(dst, func(dst *conn) error {
+		return withConn(src, func(src *conn) error {
+			b, err := src.backupInit(dst, dstName, srcName)
+			if err != nil {
+				return err
+			}
+
+			r = &Backup{b: b}
+			backups.Lock()
+			src.addBackup(r)
+			dst.addBackup(r)
+			backups.Unlock()
+			return nil
+		})
+	})
+	return r, err
+}
+
+func (c *conn) addBackup(b *Backup) {
+	if c.backups == nil {
+		c.backups = map[*Backup]struct{}{}
+	}
+	c.backups[b] = struct{}{}
+}
+
+// Step copies up to nPage pages, or all remaining pages if nPage is negative,
+// and reports whether the backup is complete. An *Error with code SQLITE_BUSY
+// or SQLITE_LOCKED means that a database was busy and the step can be retried
+// later; other errors are fatal.
+//
+// int sqlite3_backup_step(sqlite3_backup *p, int nPage);
+func (b *Backup) Step(nPage int) (done bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.b.p == 0 {
+		return false, errBackupFinished
+	}
+
+	return b.b.step(nPage)
+}
+
+// Remaining returns the number of pages still to be copied as of the last
+// Step.
+//
+// int sqlite3_backup_remaining(sqlite3_backup *p);
+func (b *Backup) Remaining() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.b.p == 0 {
+		return 0
+	}
+
+	return b.b.remaining()
+}
+
+// PageCount returns the number of pages of the source database as of the last
+// Step.
+//
+// int sqlite3_backup_pagecount(sqlite3_backup *p);
+func (b *Backup) PageCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.b.p == 0 {
+		return 0
+	}
+
+	return b.b.pageCount()
+}
+
+// Finish releases the resources of the backup. If the backup is not complete,
+// the destination is left as it was before the backup started. Finish reports
+// a fatal error of a previous Step, if any, and does nothing if called again.
+//
+// int sqlite3_backup_finish(sqlite3_backup *p);
+func (b *Backup) Finish() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	backups.Lock()
+	delete(b.b.src.backups, b)
+	delete(b.b.dst.backups, b)
+	backups.Unlock()
+	return b.b.finish()
+}
+
+// finishBackups finishes the backups from or to c left unfinished.
+func (c *conn) finishBackups() {
+	backups.Lock()
+	var a []*Backup
+	for b := range c.backups {
+		a = append(a, b)
+	}
+	backups.Unlock()
+	for _, b := range a {
+		b.Finish()
+	}
+}
+
+// BackupToFile copies the main database of c to the main database of the
+// file at path, which is created if it does not exist and overwritten
+// otherwise, like BackupDatabase does.
+func BackupToFile(ctx context.Context, c *sql.Conn, path string) error {
+	return withConn(c, func(c *conn) error {
+		dst, err := newConn(path, defaultOpenFlags)
+		if err != nil {
+			return err
+		}
+
+		if err := c.backup(ctx, dst, "main", "main"); err != nil {
+			dst.Close()
+			return err
+		}
+
+		return dst.Close()
+	})
+}
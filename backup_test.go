@@ -0,0 +1,264 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// cancelAfterContext is a context that is canceled after its Err method was
+// called n times.
+type cancelAfterContext struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfterContext) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+
+	return nil
+}
+
+func TestBackupDatabase(t *testing.T) {
+	dir := t.TempDir()
+	src, err := sql.Open(driverName, filepath.Join(dir, "src.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer src.Close()
+
+	if _, err := src.Exec(`
+	create table t(i integer primary key, b blob);
+	with recursive c(i) as (select 1 union all select i+1 from c where i < 5000)
+	insert into t select i, randomblob(1000) from c;
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := sql.Open(driverName, filepath.Join(dir, "dst.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dst.Close()
+
+	if _, err := dst.Exec("create table old(i); insert into old values(42)"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	sc, err := src.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sc.Close()
+
+	dc, err := dst.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dc.Close()
+
+	var pages int
+	if err := sc.QueryRowContext(ctx, "pragma page_count").Scan(&pages); err != nil {
+		t.Fatal(err)
+	}
+
+	if pages <= 3*backupStepPages {
+		t.Fatalf("source too small: %v pages", pages)
+	}
+
+	// Cancel after three steps, in the middle of the backup.
+	if err := BackupDatabase(&cancelAfterContext{ctx, 3}, dc, sc, "main", "main"); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+
+	var n int
+	if err := dc.QueryRowContext(ctx, "select i from old").Scan(&n); err != nil || n != 42 {
+		t.Fatalf("destination changed by a canceled backup: %v, %v", n, err)
+	}
+
+	if _, err := dc.ExecContext(ctx, "insert into old values(43)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := BackupDatabase(ctx, dc, sc, "main", "main"); err != nil {
+		t.Fatal(err)
+	}
+
+	d0, err := DatabaseDigest(ctx, sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d1, err := DatabaseDigest(ctx, dc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(d0, d1) {
+		t.Fatal("backup differs from the source")
+	}
+
+	if err := BackupDatabase(ctx, dc, sc, "main", "nonexistent"); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestBackup(t *testing.T) {
+	dir := t.TempDir()
+	src, err := sql.Open(driverName, filepath.Join(dir, "src.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer src.Close()
+
+	if _, err := src.Exec(`
+	create table t(i integer primary key, b blob);
+	with recursive c(i) as (select 1 union all select i+1 from c where i < 500)
+	insert into t select i, randomblob(1000) from c;
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := sql.Open(driverName, filepath.Join(dir, "dst.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dst.Close()
+
+	ctx := context.Background()
+	sc, err := src.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sc.Close()
+
+	dc, err := dst.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dc.Close()
+
+	if _, err := SetBusyTimeout(dc, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBackup(dc, sc, "main", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Finish()
+
+	// A write transaction on the destination makes the steps busy.
+	blocker, err := dst.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer blocker.Close()
+
+	if _, err := blocker.ExecContext(ctx, "begin immediate"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Step(10); err == nil || err.(*Error).Code()&0xff != sqlite3.SQLITE_BUSY {
+		t.Fatalf("got %v, want SQLITE_BUSY", err)
+	}
+
+	if _, err := blocker.ExecContext(ctx, "rollback"); err != nil {
+		t.Fatal(err)
+	}
+
+	var steps int
+	for {
+		done, err := b.Step(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		steps++
+		if n, m := b.Remaining(), b.PageCount(); m == 0 || n > m || done != (n == 0) {
+			t.Fatalf("remaining %v of %v pages, done %v", n, m, done)
+		}
+
+		if done {
+			break
+		}
+	}
+	if steps < 10 {
+		t.Fatalf("backup done in %v steps", steps)
+	}
+
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Step(10); err != errBackupFinished {
+		t.Fatalf("got %v, want %v", err, errBackupFinished)
+	}
+
+	d0, err := DatabaseDigest(ctx, sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d1, err := DatabaseDigest(ctx, dc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(d0, d1) {
+		t.Fatal("backup differs from the source")
+	}
+
+	fn := filepath.Join(dir, "file.db")
+	if err := BackupToFile(ctx, sc, fn); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open(driverName, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	if d2, err := DatabaseDigest(ctx, db); err != nil || !bytes.Equal(d0, d2) {
+		t.Fatalf("file backup differs from the source: %v", err)
+	}
+
+	// Closing the connections finishes an unfinished backup.
+	b, err = NewBackup(dc, sc, "main", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dc.Close()
+	dst.Close()
+	if b.b.p != 0 {
+		t.Fatal("backup not finished by Close")
+	}
+
+	if err := b.Finish(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// errBlobClosed is returned by the methods of a closed Blob.
+var errBlobClosed = errors.New("sqlite: blob is closed")
+
+// int sqlite3_blob_open(
+//
+//	sqlite3*,
+//	const char *zDb,
+//	const char *zTable,
+//	const char *zColumn,
+//	sqlite3_int64 iRow,
+//	int flags,
+//	sqlite3_blob **ppBlob
+//
+// );
+func (c *conn) blobOpen(schema, table, column string, rowid int64, writable bool) (uintptr, error) {
+	zDb, err := libc.CString(schema)
+	if err != nil {
+		return 0, err
+	}
+
+	defer c.free(zDb)
+
+	zTable, err := libc.CString(table)
+	if err != nil {
+		return 0, err
+	}
+
+	defer c.free(zTable)
+
+	zColumn, err := libc.CString(column)
+	if err != nil {
+		return 0, err
+	}
+
+	defer c.free(zColumn)
+
+	p, err := c.malloc(int(unsafe.Sizeof(uintptr(0))))
+	if err != nil {
+		return 0, err
+	}
+
+	defer c.free(p)
+
+	var flags int32
+	if writable {
+		flags = 1
+	}
+	if rc := sqlite3.Xsqlite3_blob_open(c.tls, c.db, zDb, zTable, zColumn, rowid, flags, p); rc != sqlite3.SQLITE_OK {
+		return 0, c.errstr(rc)
+	}
+
+	return *(*uintptr)(unsafe.Pointer(p)), nil
+}
+
+// Blob is a handle for incremental I/O of a BLOB, returned by OpenBlob. It
+// reads and writes the BLOB in chunks, without ever holding the whole value
+// in memory.
+type Blob struct {
+	c    *conn
+	p    uintptr // *sqlite3_blob
+	size int64
+}
+
+// OpenBlob opens the BLOB in column of the row rowid of table in the database
+// schema, ie. "main", "temp" or the name of an attached database, of the
+// connection c, for reading and, if writable is true, writing. The table must
+// be a rowid table.
+//
+// The Blob uses c, which must not be used by anything else until Close, and
+// must be closed before c is. Changing the row by other means, including
+// through another Blob, invalidates the Blob; its methods then return an
+// *Error with code SQLITE_ABORT until it is closed or reopened.
+func OpenBlob(c *sql.Conn, schema, table, column string, rowid int64, writable bool) (r *Blob, err error) {
+	err = withConn(c, func(c *conn) error {
+		p, err := c.blobOpen(schema, table, column, rowid, writable)
+		if err != nil {
+			return err
+		}
+
+		r = &Blob{c: c, p: p}
+		r.size = r.bytes()
+		return nil
+	})
+	return r, err
+}
+
+// int sqlite3_blob_bytes(sqlite3_blob *);
+func (b *Blob) bytes() int64 { return int64(sqlite3.Xsqlite3_blob_bytes(b.c.tls, b.p)) }
+
+// Size returns the size of the BLOB in bytes. Writes cannot change it.
+func (b *Blob) Size() int64 { return b.size }
+
+// ReadAt implements io.ReaderAt.
+//
+// int sqlite3_blob_read(sqlite3_blob *, void *Z, int N, int iOffset);
+func (b *Blob) ReadAt(p []byte, off int64) (n int, err error) {
+	if b.p == 0 {
+		return 0, errBlobClosed
+	}
+
+	if off < 0 {
+		return 0, fmt.Errorf("sqlite: invalid blob offset %d", off)
+	}
+
+	if off >= b.size {
+		return 0, io.EOF
+	}
+
+	if m := b.size - off; int64(len(p)) > m {
+		p = p[:m]
+		err = io.EOF
+	}
+
+	if err2 := b.chunks(p, off, func(buf uintptr, m, off int) int32 {
+		rc := sqlite3.Xsqlite3_blob_read(b.c.tls, b.p, buf, int32(m), int32(off))
+		if rc == sqlite3.SQLITE_OK {
+			n += copy(p[n:n+m], (*libc.RawMem)(unsafe.Pointer(buf))[:m:m])
+		}
+		return rc
+	}); err2 != nil {
+		return n, err2
+	}
+
+	// err is io.EOF if p extends past the end of the BLOB.
+	return n, err
+}
+
+// WriteAt implements io.WriterAt. The write must fit in the BLOB, which cannot
+// be resized this way; to change its size, update the column, eg. to a
+// zeroblob of the new size, and reopen the Blob.
+//
+// int sqlite3_blob_write(sqlite3_blob *, const void *z, int n, int iOffset);
+func (b *Blob) WriteAt(p []byte, off int64) (n int, err error) {
+	if b.p == 0 {
+		return 0, errBlobClosed
+	}
+
+	if off < 0 || off+int64(len(p)) > b.size {
+		return 0, fmt.Errorf("sqlite: cannot write %d bytes at offset %d of a blob of size %d", len(p), off, b.size)
+	}
+
+	err = b.chunks(p, off, func(buf uintptr, m, off int) int32 {
+		copy((*libc.RawMem)(unsafe.Pointer(buf))[:m:m], p[n:n+m])
+		rc := sqlite3.Xsqlite3_blob_write(b.c.tls, b.p, buf, int32(m), int32(off))
+		if rc == sqlite3.SQLITE_OK {
+			n += m
+		}
+		return rc
+	})
+	return n, err
+}
+
+// chunks calls f for the consecutive chunks of p, at off in the BLOB, with a
+// C buffer of the chunk size, until f returns an error code.
+func (b *Blob) chunks(p []byte, off int64, f func(buf uintptr, n, off int) int32) error {
+	size := len(p)
+	if size > streamBufferSize {
+		size = streamBufferSize
+	}
+
+	buf, err := b.c.malloc(size)
+	if err != nil {
+		return err
+	}
+
+	defer b.c.free(buf)
+
+	for i := 0; i < len(p); i += size {
+		n := len(p) - i
+		if n > size {
+			n = size
+		}
+
+		if rc := f(buf, n, int(off)+i); rc != sqlite3.SQLITE_OK {
+			return b.c.errstr(rc)
+		}
+	}
+	return nil
+}
+
+// Reopen moves the Blob to the row rowid of the same table and column, which
+// is faster than opening a new one. If it fails, the Blob can only be
+// reopened again or closed.
+//
+// int sqlite3_blob_reopen(sqlite3_blob *, sqlite3_int64);
+func (b *Blob) Reopen(rowid int64) error {
+	if b.p == 0 {
+		return errBlobClosed
+	}
+
+	if rc := sqlite3.Xsqlite3_blob_reopen(b.c.tls, b.p, rowid); rc != sqlite3.SQLITE_OK {
+		b.size = 0
+		return b.c.errstr(rc)
+	}
+
+	b.size = b.bytes()
+	return nil
+}
+
+// Close closes the Blob. It does nothing if called again.
+//
+// int sqlite3_blob_close(sqlite3_blob *);
+func (b *Blob) Close() error {
+	if b.p == 0 {
+		return nil
+	}
+
+	rc := sqlite3.Xsqlite3_blob_close(b.c.tls, b.p)
+	b.p = 0
+	if rc != sqlite3.SQLITE_OK {
+		return b.c.errstr(rc)
+	}
+
+	return nil
+}
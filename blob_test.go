@@ -0,0 +1,117 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"testing"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func TestOpenBlob(t *testing.T) {
+	db, err := sql.Open(driverName, "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	const size = 200000
+	if _, err := c.ExecContext(ctx, "create table t(b blob); insert into t values(zeroblob(?)), (x'0102')", size); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenBlob(c, "main", "t", "b", 42, false); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	b, err := OpenBlob(c, "main", "t", "b", 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Close()
+
+	if g := b.Size(); g != size {
+		t.Fatalf("got size %v, want %v", g, size)
+	}
+
+	want := bytes.Repeat([]byte("0123456789"), size/10)
+	if n, err := b.WriteAt(want[:100], 0); n != 100 || err != nil {
+		t.Fatalf("got %v, %v", n, err)
+	}
+
+	if n, err := b.WriteAt(want[100:], 100); n != size-100 || err != nil {
+		t.Fatalf("got %v, %v", n, err)
+	}
+
+	if _, err := b.WriteAt(want[:2], size-1); err == nil {
+		t.Fatal("write past the end succeeded")
+	}
+
+	got, err := io.ReadAll(io.NewSectionReader(b, 0, b.Size()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Fatal("read back differs from the written data")
+	}
+
+	buf := make([]byte, 10)
+	if n, err := b.ReadAt(buf, size-4); n != 4 || err != io.EOF || string(buf[:4]) != "6789" {
+		t.Fatalf("got %v, %v, %q", n, err, buf[:n])
+	}
+
+	if err := b.Reopen(2); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := b.ReadAt(buf, 0); n != 2 || err != io.EOF || !bytes.Equal(buf[:2], []byte{1, 2}) {
+		t.Fatalf("got %v, %v, %x", n, err, buf[:n])
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.ReadAt(buf, 0); err != errBlobClosed {
+		t.Fatalf("got %v, want %v", err, errBlobClosed)
+	}
+
+	var s []byte
+	if err := c.QueryRowContext(ctx, "select b from t where rowid = 1").Scan(&s); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(s, want) {
+		t.Fatal("stored data differs from the written data")
+	}
+
+	// Changing the row invalidates the Blob.
+	if b, err = OpenBlob(c, "main", "t", "b", 2, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.ExecContext(ctx, "update t set b = x'03' where rowid = 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.ReadAt(buf, 0); err == nil || err.(*Error).Code() != sqlite3.SQLITE_ABORT {
+		t.Fatalf("got %v, want SQLITE_ABORT", err)
+	}
+}
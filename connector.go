@@ -0,0 +1,700 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// checkpointOnCloseTimeout bounds the time CheckpointOnClose waits for
+// readers and writers of other processes.
+const checkpointOnCloseTimeout = time.Second
+
+// ConnectorOption configures the connections created by a connector returned
+// from NewConnector.
+type ConnectorOption func(*connector) error
+
+type connector struct {
+	driver *Driver
+	dsn    string
+
+	beginMode string
+
+	checkpointOnClose bool
+	checkpointMode    int32
+
+	mmapSize    int64
+	setMmapSize bool
+
+	noUnlockNotify bool
+
+	mustExist bool
+
+	encoding string
+
+	queryOnly bool
+
+	maxPageCount int64
+
+	memDBMaxSize int64
+
+	lookasideSize  int
+	lookasideCount int
+	setLookaside   bool
+
+	registry *Registry
+
+	autoTxMultiStatement bool
+
+	looseTypeBinding bool
+
+	noSync bool
+
+	stableColumnTypes bool
+
+	recursiveTriggers bool
+	deferForeignKeys  bool
+
+	workerThreads    int
+	setWorkerThreads bool
+
+	emptyStringAsNull bool
+
+	audit func(AuditEvent)
+
+	progressCancel int
+
+	trackStatements bool
+
+	sync.Mutex
+	conns map[*conn]struct{} // open connections
+}
+
+// NewConnector returns a driver.Connector opening the database named by dsn,
+// suitable for sql.OpenDB. The dsn has the same format and supports the same
+// query parameters as Driver.Open. The options are applied to every new
+// connection after the query parameters, so an option takes precedence over
+// the corresponding query parameter.
+func NewConnector(dsn string, opts ...ConnectorOption) (driver.Connector, error) {
+	cn := &connector{driver: d, dsn: dsn}
+	for _, opt := range opts {
+		if err := opt(cn); err != nil {
+			return nil, err
+		}
+	}
+
+	return cn, nil
+}
+
+// Connect implements driver.Connector.
+func (cn *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return cn.driver.open(cn)
+}
+
+// Driver implements driver.Connector.
+func (cn *connector) Driver() driver.Driver {
+	return cn.driver
+}
+
+// openFlags returns the sqlite3_open_v2 flags of the connections.
+func (cn *connector) openFlags() int32 {
+	if cn.mustExist {
+		return defaultOpenFlags &^ sqlite3.SQLITE_OPEN_CREATE
+	}
+
+	return defaultOpenFlags
+}
+
+// configure applies the connector options to a freshly opened connection.
+func (cn *connector) configure(c *conn) error {
+	if cn.beginMode != "" {
+		c.beginMode = cn.beginMode
+	}
+
+	c.noUnlockNotify = cn.noUnlockNotify
+	c.autoTxMultiStatement = cn.autoTxMultiStatement
+	c.looseTypeBinding = cn.looseTypeBinding
+	c.stableColumnTypes = cn.stableColumnTypes
+	c.deferForeignKeys = cn.deferForeignKeys
+	c.emptyStringAsNull = cn.emptyStringAsNull
+	c.audit = cn.audit
+	c.trackStatements = cn.trackStatements
+	if cn.progressCancel != 0 {
+		c.progress.cancelOps = cn.progressCancel
+		c.updateProgressHandler()
+	}
+
+	if cn.setLookaside {
+		if err := c.setLookaside(cn.lookasideSize, cn.lookasideCount); err != nil {
+			return err
+		}
+	}
+
+	if cn.encoding != "" {
+		if _, err := c.exec(context.Background(), fmt.Sprintf("pragma encoding = %s", QuoteString(cn.encoding)), nil); err != nil {
+			return err
+		}
+	}
+
+	if cn.maxPageCount != 0 {
+		if _, err := c.exec(context.Background(), fmt.Sprintf("pragma max_page_count = %d", cn.maxPageCount), nil); err != nil {
+			return err
+		}
+	}
+
+	if cn.setWorkerThreads {
+		if _, err := c.exec(context.Background(), fmt.Sprintf("pragma threads = %d", cn.workerThreads), nil); err != nil {
+			return err
+		}
+	}
+
+	if cn.memDBMaxSize != 0 {
+		if err := c.setMemDBMaxSize(cn.memDBMaxSize); err != nil {
+			return err
+		}
+	}
+
+	if cn.queryOnly {
+		c.queryOnly = true
+		if _, err := c.exec(context.Background(), "pragma query_only = on", nil); err != nil {
+			return err
+		}
+	}
+
+	if cn.recursiveTriggers {
+		if _, err := c.exec(context.Background(), "pragma recursive_triggers = on", nil); err != nil {
+			return err
+		}
+	}
+
+	if cn.noSync {
+		if _, err := c.exec(context.Background(), "pragma synchronous = off", nil); err != nil {
+			return err
+		}
+	}
+
+	if cn.setMmapSize {
+		if _, err := c.exec(context.Background(), fmt.Sprintf("pragma mmap_size = %d", cn.mmapSize), nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// acquire records that c was opened by cn.
+func (cn *connector) acquire(c *conn) {
+	cn.Lock()
+	if cn.conns == nil {
+		cn.conns = map[*conn]struct{}{}
+	}
+	cn.conns[c] = struct{}{}
+	cn.Unlock()
+}
+
+// release records that c, opened by cn, is about to be closed. When c is the
+// last open connection of cn, the CheckpointOnClose option is honored.
+func (cn *connector) release(c *conn) {
+	cn.Lock()
+	delete(cn.conns, c)
+	last := len(cn.conns) == 0
+	cn.Unlock()
+
+	if !last || !cn.checkpointOnClose || c.fileName("main") == "" {
+		return
+	}
+
+	// Do not wait for the default busy timeout if another process holds a
+	// lock that prevents the checkpoint.
+	sqlite3.Xsqlite3_busy_timeout(c.tls, c.db, int32(checkpointOnCloseTimeout/time.Millisecond))
+	if _, _, err := c.walCheckpoint("", cn.checkpointMode); err != nil {
+		log.Printf("sqlite: checkpoint on close of %s: %v", c.fileName("main"), err)
+	}
+}
+
+// InterruptAll interrupts the statements running on every open connection
+// of cn, which must have been returned by NewConnector, making them fail with
+// SQLITE_INTERRUPT as soon as possible, eg. to unblock long-running queries
+// on shutdown before closing the sql.DB. Statements started after
+// InterruptAll returns are not affected.
+func InterruptAll(cn driver.Connector) error {
+	c, ok := cn.(*connector)
+	if !ok {
+		return fmt.Errorf("sqlite: unexpected connector type %T", cn)
+	}
+
+	// Connections are closed while holding their lock, before taking the
+	// lock of the connector, so they are interrupted after releasing it.
+	c.Lock()
+	conns := make([]*conn, 0, len(c.conns))
+	for v := range c.conns {
+		conns = append(conns, v)
+	}
+	c.Unlock()
+
+	for _, v := range conns {
+		v.Lock()
+		if v.db != 0 && v.tls != nil {
+			sqlite3.Xsqlite3_interrupt(v.tls, v.db)
+		}
+		v.Unlock()
+	}
+	return nil
+}
+
+// poolStatsCounters maps the names of the PoolStats counters to their
+// sqlite3_db_status verbs.
+var poolStatsCounters = map[string]int32{
+	"cache_hit":           sqlite3.SQLITE_DBSTATUS_CACHE_HIT,
+	"cache_miss":          sqlite3.SQLITE_DBSTATUS_CACHE_MISS,
+	"cache_spill":         sqlite3.SQLITE_DBSTATUS_CACHE_SPILL,
+	"cache_used":          sqlite3.SQLITE_DBSTATUS_CACHE_USED,
+	"cache_used_shared":   sqlite3.SQLITE_DBSTATUS_CACHE_USED_SHARED,
+	"cache_write":         sqlite3.SQLITE_DBSTATUS_CACHE_WRITE,
+	"deferred_fks":        sqlite3.SQLITE_DBSTATUS_DEFERRED_FKS,
+	"lookaside_hit":       sqlite3.SQLITE_DBSTATUS_LOOKASIDE_HIT,
+	"lookaside_miss_full": sqlite3.SQLITE_DBSTATUS_LOOKASIDE_MISS_FULL,
+	"lookaside_miss_size": sqlite3.SQLITE_DBSTATUS_LOOKASIDE_MISS_SIZE,
+	"lookaside_used":      sqlite3.SQLITE_DBSTATUS_LOOKASIDE_USED,
+	"schema_used":         sqlite3.SQLITE_DBSTATUS_SCHEMA_USED,
+	"stmt_used":           sqlite3.SQLITE_DBSTATUS_STMT_USED,
+}
+
+// PoolStats returns a snapshot of the sqlite3_db_status counters of the open
+// connections of cn, which must have been returned by NewConnector, summed
+// over the connections, eg. to export them as metrics. The keys are the names
+// of the SQLITE_DBSTATUS_ verbs in lower case without the prefix, eg.
+// "cache_hit" or "schema_used", and "connections" counts the connections.
+// Memory is in bytes. The counters of a connection are lost when database/sql
+// closes it, so the sums may decrease.
+//
+// See https://www.sqlite.org/c3ref/c_dbstatus_options.html for details.
+func PoolStats(cn driver.Connector) (map[string]int64, error) {
+	c, ok := cn.(*connector)
+	if !ok {
+		return nil, fmt.Errorf("sqlite: unexpected connector type %T", cn)
+	}
+
+	c.Lock()
+	conns := make([]*conn, 0, len(c.conns))
+	for v := range c.conns {
+		conns = append(conns, v)
+	}
+	c.Unlock()
+
+	// The connections may be in use by other goroutines, so the calls use
+	// their own TLS. Connections are opened with SQLITE_OPEN_FULLMUTEX.
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	p := libc.Xmalloc(tls, 8)
+	if p == 0 {
+		return nil, fmt.Errorf("sqlite: cannot allocate memory")
+	}
+
+	defer libc.Xfree(tls, p)
+
+	r := map[string]int64{"connections": 0}
+	for k := range poolStatsCounters {
+		r[k] = 0
+	}
+	for _, v := range conns {
+		v.Lock()
+		if v.db == 0 {
+			v.Unlock()
+			continue
+		}
+
+		r["connections"]++
+		for k, op := range poolStatsCounters {
+			if rc := sqlite3.Xsqlite3_db_status(tls, v.db, op, p, p+4, 0); rc != sqlite3.SQLITE_OK {
+				v.Unlock()
+				return nil, fmt.Errorf("sqlite: sqlite3_db_status(%s): %s", k, libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+			}
+
+			n := *(*int32)(unsafe.Pointer(p))
+			switch op {
+			case sqlite3.SQLITE_DBSTATUS_LOOKASIDE_HIT, sqlite3.SQLITE_DBSTATUS_LOOKASIDE_MISS_FULL, sqlite3.SQLITE_DBSTATUS_LOOKASIDE_MISS_SIZE:
+				// These are reported as the high-water mark.
+				n = *(*int32)(unsafe.Pointer(p + 4))
+			}
+			r[k] += int64(n)
+		}
+		v.Unlock()
+	}
+	return r, nil
+}
+
+// CheckpointOnClose makes the last connection of the connector checkpoint
+// the write-ahead log before the connection is closed. It applies to file
+// databases in WAL mode only. The mode may be "passive", "full", "restart" or
+// "truncate" (case insensitive); an empty mode means "truncate", which also
+// truncates the -wal file to zero bytes.
+//
+// SQLite checkpoints and removes the -wal file itself when the last
+// connection of all processes closes the database. This option helps when
+// another process keeps the database open, so the -wal file would otherwise
+// stay large. The checkpoint waits at most one second for locks held by
+// others; a failed checkpoint is logged and does not prevent closing the
+// connection.
+func CheckpointOnClose(mode string) ConnectorOption {
+	return func(cn *connector) error {
+		if mode == "" {
+			mode = "truncate"
+		}
+
+		m, ok := checkpointModes[strings.ToLower(mode)]
+		if !ok {
+			return fmt.Errorf("sqlite: unknown checkpoint mode %q", mode)
+		}
+
+		cn.checkpointOnClose = true
+		cn.checkpointMode = m
+		return nil
+	}
+}
+
+// TxLock sets the locking behavior used by BeginTx for read-write
+// transactions. The mode may be "deferred", "immediate" or "exclusive" (case
+// insensitive) and has the same effect as the _txlock query parameter.
+//
+// Writers to a database in WAL mode typically want "immediate", which acquires
+// the write lock up front instead of upgrading a read transaction later, an
+// upgrade that fails with SQLITE_BUSY when another connection wrote in the
+// meantime.
+func TxLock(mode string) ConnectorOption {
+	return func(cn *connector) error {
+		switch lower := strings.ToLower(mode); lower {
+		case "deferred", "immediate", "exclusive":
+			cn.beginMode = lower
+			return nil
+		default:
+			return fmt.Errorf("sqlite: unknown transaction lock mode %q", mode)
+		}
+	}
+}
+
+// Mmap sets the maximum number of bytes of the database file accessed using
+// memory-mapped I/O, like PRAGMA mmap_size does. Zero disables memory-mapped
+// I/O, which is the safe choice on platforms where mmap is not reliable, eg.
+// linux/386. SQLite caps the value at the compile-time SQLITE_MAX_MMAP_SIZE;
+// use MmapSize to read back the effective value.
+func Mmap(size int64) ConnectorOption {
+	return func(cn *connector) error {
+		if size < 0 {
+			return fmt.Errorf("sqlite: invalid mmap size %d", size)
+		}
+
+		cn.mmapSize = size
+		cn.setMmapSize = true
+		return nil
+	}
+}
+
+// UnlockNotify controls how a statement blocked by a table lock held by
+// another connection to the same shared cache is handled. When enabled, the
+// default, the statement waits for the lock using sqlite3_unlock_notify and
+// is retried. When disabled, the statement fails immediately with
+// SQLITE_LOCKED_SHAREDCACHE, leaving the retry policy to the application, see
+// WaitForUnlock.
+//
+// Connections not using shared cache are never blocked this way, so disabling
+// the wait only matters for those that do. The busy timeout does not apply to
+// shared-cache table locks.
+func UnlockNotify(enabled bool) ConnectorOption {
+	return func(cn *connector) error {
+		cn.noUnlockNotify = !enabled
+		return nil
+	}
+}
+
+// MustExist makes opening a database file that does not exist fail with
+// SQLITE_CANTOPEN instead of creating an empty database, so that a mistyped
+// path does not go unnoticed. In-memory databases are not affected.
+func MustExist(mustExist bool) ConnectorOption {
+	return func(cn *connector) error {
+		cn.mustExist = mustExist
+		return nil
+	}
+}
+
+// maxLookasideSize is the largest lookaside slot size supported by SQLite.
+const maxLookasideSize = 65528
+
+// Lookaside configures the lookaside memory allocator of the connections,
+// which serves small, short-lived allocations of SQLite from a per-connection
+// pool of count slots of size bytes each, like
+// sqlite3_db_config(SQLITE_DBCONFIG_LOOKASIDE) does. The default is 40 slots
+// of 1200 bytes. More slots may reduce the allocation overhead of workloads
+// preparing many statements, at the cost of size*count bytes of memory per
+// connection. A zero size or count disables lookaside.
+//
+// The size must be a multiple of 8 not greater than 65528. The pool is
+// replaced before the connection runs any statement of the application.
+func Lookaside(size, count int) ConnectorOption {
+	return func(cn *connector) error {
+		if size < 0 || size > maxLookasideSize || size%8 != 0 {
+			return fmt.Errorf("sqlite: invalid lookaside slot size %d", size)
+		}
+
+		if count < 0 || count > math.MaxInt32/(size+1) {
+			return fmt.Errorf("sqlite: invalid lookaside slot count %d", count)
+		}
+
+		cn.lookasideSize = size
+		cn.lookasideCount = count
+		cn.setLookaside = true
+		return nil
+	}
+}
+
+// Encoding sets the text encoding of databases created by the connections,
+// like PRAGMA encoding does. The encoding may be "UTF-8", "UTF-16le",
+// "UTF-16be" or "UTF-16", the native byte order (case insensitive).
+//
+// The encoding of a database is fixed when it is created, ie. by the first
+// write to an empty database. For an existing database the option is
+// silently ignored, use DatabaseEncoding to check the encoding in use.
+func Encoding(encoding string) ConnectorOption {
+	return func(cn *connector) error {
+		switch strings.ToLower(encoding) {
+		case "utf-8", "utf-16le", "utf-16be", "utf-16":
+			cn.encoding = encoding
+			return nil
+		default:
+			return fmt.Errorf("sqlite: unknown encoding %q", encoding)
+		}
+	}
+}
+
+// QueryOnly makes the connections read-only by setting PRAGMA query_only,
+// so that any statement changing a database, including the temp database,
+// fails with SQLITE_READONLY. Unlike opening the database with mode=ro, it
+// does not change how the database file is opened. The pragma is set again
+// whenever database/sql reuses a connection, in case a statement turned it
+// off.
+func QueryOnly(queryOnly bool) ConnectorOption {
+	return func(cn *connector) error {
+		cn.queryOnly = queryOnly
+		return nil
+	}
+}
+
+// AutoTxMultiStatement makes an Exec running several statements separated by
+// semicolons, outside of a transaction, run them in a transaction that is
+// committed if all of them succeed and rolled back otherwise, so that the
+// batch is atomic. Without it, which is the default, every statement commits
+// on its own and the statements preceding a failed one stay committed. The
+// transaction begins like the transactions of Tx do, see TxLock.
+//
+// Batches controlling transactions themselves, eg. "BEGIN; ...; COMMIT", gain
+// nothing from the option and fail, because their BEGIN runs in the implicit
+// transaction.
+func AutoTxMultiStatement(enabled bool) ConnectorOption {
+	return func(cn *connector) error {
+		cn.autoTxMultiStatement = enabled
+		return nil
+	}
+}
+
+// MemDBMaxSize limits the size of the in-memory main databases of the
+// connections to n bytes, so that a write growing a database beyond the limit
+// fails with SQLITE_FULL, see ErrFull, instead of exhausting the memory. For a
+// database of the memdb VFS, eg. "file:/name?vfs=memdb", which is otherwise
+// limited to 1GB, it sets SQLITE_FCNTL_SIZE_LIMIT. For other in-memory
+// databases, like ":memory:", it sets PRAGMA max_page_count to n divided by
+// the page size, like MaxPageCount does. Opening a connection to a database
+// that is not in memory fails.
+func MemDBMaxSize(n int64) ConnectorOption {
+	return func(cn *connector) error {
+		if n <= 0 {
+			return fmt.Errorf("sqlite: invalid memdb max size %d", n)
+		}
+
+		cn.memDBMaxSize = n
+		return nil
+	}
+}
+
+// LooseTypeBinding makes the connections accept []string and []int64 query
+// arguments, which database/sql rejects otherwise, and bind them as TEXT
+// holding a JSON array, eg. for a column checked by json_type(v) = 'array'.
+// A nil slice binds NULL. Use ScanJSON to read such a column back into a
+// slice.
+func LooseTypeBinding(enabled bool) ConnectorOption {
+	return func(cn *connector) error {
+		cn.looseTypeBinding = enabled
+		return nil
+	}
+}
+
+// NoSync sets PRAGMA synchronous = OFF on the connections, so that SQLite
+// never calls fsync, neither for the database nor for its journal, trading
+// durability for the speed of writes. It is meant for throwaway databases, eg.
+// in tests or CI, and has at run time much the same effect as building with the
+// libc.nofsync tag.
+//
+// WARNING: a power loss or an operating system crash, unlike a crash of the
+// application, can then corrupt the database, not only lose the last
+// transactions. Never use it for data that matters.
+func NoSync(enabled bool) ConnectorOption {
+	return func(cn *connector) error {
+		cn.noSync = enabled
+		return nil
+	}
+}
+
+// StableColumnTypes makes the Go type of the values of a table column, as
+// scanned into an interface{}, depend on the affinity of the declared type of
+// the column instead of the storage class of every value, where it can be
+// done without loss:
+//
+//   - TEXT affinity, eg. VARCHAR: INTEGER, REAL and BLOB values are returned
+//     as string.
+//   - REAL and NUMERIC affinity, eg. DOUBLE or DECIMAL: INTEGER values are
+//     returned as float64, which loses precision beyond 2^53. BOOLEAN, DATE,
+//     DATETIME and TIMESTAMP columns, whose integers are flags or Unix times,
+//     are excluded.
+//
+// TEXT and BLOB values stored in a numeric column are not numbers and are
+// returned unchanged, and so are all the values of the INTEGER affinity
+// columns, where SQLite keeps REAL values only if they have a fractional
+// part, and of the columns without a declared type. NULL is always nil.
+//
+// See https://www.sqlite.org/datatype3.html for the affinity of a declared
+// type.
+func StableColumnTypes(enabled bool) ConnectorOption {
+	return func(cn *connector) error {
+		cn.stableColumnTypes = enabled
+		return nil
+	}
+}
+
+// RecursiveTriggers sets PRAGMA recursive_triggers on the connections, which
+// lets a trigger fire other triggers, including itself, and makes the rows
+// deleted by REPLACE conflict resolution fire the DELETE triggers.
+func RecursiveTriggers(enabled bool) ConnectorOption {
+	return func(cn *connector) error {
+		cn.recursiveTriggers = enabled
+		return nil
+	}
+}
+
+// DeferForeignKeys makes the transactions begun by BeginTx check all foreign
+// key constraints at commit, as if they were declared DEFERRABLE INITIALLY
+// DEFERRED, so that the rows may be temporarily inconsistent within a
+// transaction, eg. to insert rows referencing each other. SQLite turns PRAGMA
+// defer_foreign_keys off at the end of every transaction, so the option sets
+// it again after each BEGIN. Foreign keys are only enforced if enabled, eg.
+// by the "_pragma=foreign_keys(1)" query parameter. See also
+// SetDeferForeignKeys.
+func DeferForeignKeys(enabled bool) ConnectorOption {
+	return func(cn *connector) error {
+		cn.deferForeignKeys = enabled
+		return nil
+	}
+}
+
+// WorkerThreads sets PRAGMA threads on the connections, the number of
+// auxiliary threads a prepared statement may use to sort large amounts of
+// data, eg. for an ORDER BY or CREATE INDEX that does not fit the cache. Zero
+// disables them, which is the default. SQLite caps n at the compile-time
+// SQLITE_MAX_WORKER_THREADS, 8, and the library is built threadsafe, as the
+// threads require. See CurrentWorkerThreads.
+func WorkerThreads(n int) ConnectorOption {
+	return func(cn *connector) error {
+		if n < 0 {
+			return fmt.Errorf("sqlite: invalid number of worker threads %d", n)
+		}
+
+		cn.workerThreads = n
+		cn.setWorkerThreads = true
+		return nil
+	}
+}
+
+// EmptyStringAsNull makes the connections bind empty string arguments as NULL,
+// for schemas preferring NULL over empty strings. A NOT NULL constraint then
+// rejects them as it rejects NULL. Empty strings passed as AsText([]byte{})
+// are bound as is.
+func EmptyStringAsNull(enabled bool) ConnectorOption {
+	return func(cn *connector) error {
+		cn.emptyStringAsNull = enabled
+		return nil
+	}
+}
+
+// Audit makes the connections call fn for every statement executed by Exec or
+// Query, eg. to keep an audit trail of the changes of a database. For Exec, fn
+// is called when the execution ends, for Query, when the rows are closed, or
+// right away if the query fails. Failed executions are reported too. fn is
+// called on the goroutine executing the statement, which waits for it, and
+// concurrently by different connections. Statements run internally by the
+// driver, like BEGIN and COMMIT of transactions, are not reported.
+func Audit(fn func(AuditEvent)) ConnectorOption {
+	return func(cn *connector) error {
+		cn.audit = fn
+		return nil
+	}
+}
+
+// ProgressCancel makes the connections honor the cancellation of the contexts
+// passed to ExecContext and QueryContext by checking them every n virtual
+// machine instructions from a progress handler, instead of starting a
+// goroutine per call, which waits for the context and interrupts the
+// connection, the default. This saves the cost of the goroutine for the
+// common short statements, at the cost of checking the context also when it
+// is not canceled. Smaller values of n react faster to a cancellation, and a
+// canceled statement fails like it does by default, with SQLITE_INTERRUPT.
+// See also SetProgressHandler.
+func ProgressCancel(n int) ConnectorOption {
+	return func(cn *connector) error {
+		if n < 1 {
+			return fmt.Errorf("sqlite: invalid number of instructions %d", n)
+		}
+
+		cn.progressCancel = n
+		return nil
+	}
+}
+
+// TrackStatements makes the statements executed by the connections visible
+// to ActiveStatements, which does not see those of other connections.
+// Tracking costs every Exec and Query a little time and contention on a lock
+// shared by all tracked connections of the process, so it is off by default.
+func TrackStatements(enabled bool) ConnectorOption {
+	return func(cn *connector) error {
+		cn.trackStatements = enabled
+		return nil
+	}
+}
+
+// MaxPageCount limits the size of the main databases of the connections to n
+// pages, like PRAGMA max_page_count does, so that a write growing a database
+// beyond the limit fails with SQLITE_FULL, see ErrFull. The size in bytes is
+// n times the page size, 4096 by default. The limit is a property of a
+// connection, not of the database file, and is never set below the current
+// size of the database.
+func MaxPageCount(n int64) ConnectorOption {
+	return func(cn *connector) error {
+		if n <= 0 {
+			return fmt.Errorf("sqlite: invalid max page count %d", n)
+		}
+
+		cn.maxPageCount = n
+		return nil
+	}
+}
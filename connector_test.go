@@ -0,0 +1,1169 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func txnState(c *conn, schema string) (int32, error) {
+	p, err := libc.CString(schema)
+	if err != nil {
+		return 0, err
+	}
+
+	defer c.free(p)
+	return sqlite3.Xsqlite3_txn_state(c.tls, c.db, p), nil
+}
+
+func TestTxLock(t *testing.T) {
+	tests := []struct {
+		mode string
+		want int32
+	}{
+		{"deferred", sqlite3.SQLITE_TXN_NONE},
+		{"IMMEDIATE", sqlite3.SQLITE_TXN_WRITE},
+		{"exclusive", sqlite3.SQLITE_TXN_WRITE},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.mode, func(t *testing.T) {
+			// The _txlock query parameter is overridden by the option.
+			fn := filepath.Join(t.TempDir(), "test.db") + "?_txlock=deferred&_pragma=journal_mode(wal)"
+			cn, err := NewConnector(fn, TxLock(tt.mode))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db := sql.OpenDB(cn)
+			defer db.Close()
+
+			c, err := db.Conn(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer c.Close()
+
+			tx, err := c.BeginTx(context.Background(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer tx.Rollback()
+
+			if err := c.Raw(func(driverConn interface{}) error {
+				got, err := txnState(driverConn.(*conn), "main")
+				if err != nil {
+					return err
+				}
+
+				if got != tt.want {
+					return fmt.Errorf("got txn state %d, want %d", got, tt.want)
+				}
+
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestTxLockInvalid(t *testing.T) {
+	if _, err := NewConnector(":memory:", TxLock("eventually")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestCheckpointOnClose(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		opts   []ConnectorOption
+		truncd bool
+	}{
+		{"off", nil, false},
+		{"truncate", []ConnectorOption{CheckpointOnClose("")}, true},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			fn := filepath.Join(t.TempDir(), "test.db")
+
+			// Another handle keeps the database open, so SQLite does not
+			// remove the -wal file when db is closed.
+			other, err := sql.Open(driverName, fn+"?_pragma=journal_mode(wal)")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer other.Close()
+
+			if _, err := other.Exec("create table t(b)"); err != nil {
+				t.Fatal(err)
+			}
+
+			cn, err := NewConnector(fn, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db := sql.OpenDB(cn)
+			for i := 0; i < 100; i++ {
+				if _, err := db.Exec("insert into t values(randomblob(1000))"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			fi, err := os.Stat(fn + "-wal")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if g, e := fi.Size() == 0, tt.truncd; g != e {
+				t.Fatalf("-wal size %v, truncated %v, want %v", fi.Size(), g, e)
+			}
+		})
+	}
+}
+
+func TestCheckpointOnCloseInvalid(t *testing.T) {
+	if _, err := NewConnector(":memory:", CheckpointOnClose("sometimes")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestMmap(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.db")
+	for _, size := range []int64{0, 1 << 20} {
+		cn, err := NewConnector(fn, Mmap(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db := sql.OpenDB(cn)
+		n, err := MmapSize(context.Background(), db)
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != size {
+			t.Errorf("got mmap size %v, want %v", n, size)
+		}
+	}
+
+	if _, err := NewConnector(fn, Mmap(-1)); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestUnlockNotifyDisabled(t *testing.T) {
+	const dsn = "file:unlocknotify?mode=memory&cache=shared"
+	writer, err := sql.Open(driverName, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer writer.Close()
+
+	if _, err := writer.Exec("create table t(i)"); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := writer.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("insert into t values(1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	cn, err := NewConnector(dsn, UnlockNotify(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := sql.OpenDB(cn)
+	defer reader.Close()
+
+	var n int
+	err = reader.QueryRow("select count(*) from t").Scan(&n)
+	if e, ok := err.(*Error); !ok || e.Code() != sqliteLockedSharedcache {
+		t.Fatalf("got %v, want SQLITE_LOCKED_SHAREDCACHE", err)
+	}
+}
+
+func TestWaitForUnlock(t *testing.T) {
+	const dsn = "file:waitforunlock?mode=memory&cache=shared"
+	writer, err := sql.Open(driverName, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer writer.Close()
+
+	if _, err := writer.Exec("create table t(i)"); err != nil {
+		t.Fatal(err)
+	}
+
+	cn, err := NewConnector(dsn, UnlockNotify(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := sql.OpenDB(cn)
+	defer reader.Close()
+
+	ctx := context.Background()
+	c, err := reader.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	// Nothing to wait for.
+	if err := WaitForUnlock(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := writer.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("insert into t values(1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := c.QueryRowContext(ctx, "select count(*) from t").Scan(&n); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	if err := WaitForUnlock(timeout, c); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if err := c.QueryRowContext(ctx, "select count(*) from t").Scan(&n); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		tx.Commit()
+	}()
+
+	if err := WaitForUnlock(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.QueryRowContext(ctx, "select count(*) from t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Fatalf("got %v, want 1", n)
+	}
+}
+
+func TestMustExist(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.db")
+	cn, err := NewConnector(fn, MustExist(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(cn)
+	err = db.Ping()
+	db.Close()
+	if e, ok := err.(*Error); !ok || e.Code() != sqlite3.SQLITE_CANTOPEN {
+		t.Fatalf("got %v, want SQLITE_CANTOPEN", err)
+	}
+
+	if _, err := os.Stat(fn); !os.IsNotExist(err) {
+		t.Fatalf("database file was created: %v", err)
+	}
+
+	if cn, err = NewConnector(fn, MustExist(false)); err != nil {
+		t.Fatal(err)
+	}
+
+	db = sql.OpenDB(cn)
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Close()
+	if cn, err = NewConnector(fn, MustExist(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	db = sql.OpenDB(cn)
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// lookasideHits returns the number of allocations served by the lookaside
+// allocator of c and optionally resets it.
+func lookasideHits(c *conn, reset bool) (int32, error) {
+	p, err := c.malloc(8)
+	if err != nil {
+		return 0, err
+	}
+
+	defer c.free(p)
+	if rc := sqlite3.Xsqlite3_db_status(c.tls, c.db, sqlite3.SQLITE_DBSTATUS_LOOKASIDE_HIT, p, p+4, libc.Bool32(reset)); rc != sqlite3.SQLITE_OK {
+		return 0, c.errstr(rc)
+	}
+
+	return *(*int32)(unsafe.Pointer(p + 4)), nil
+}
+
+func TestLookaside(t *testing.T) {
+	for _, tt := range []struct {
+		size, count int
+		hits        bool
+	}{
+		{256, 100, true},
+		{0, 0, false},
+	} {
+		cn, err := NewConnector(":memory:", Lookaside(tt.size, tt.count))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db := sql.OpenDB(cn)
+		ctx := context.Background()
+		c, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var hits int32
+		hitsFn := func(reset bool) {
+			if err := c.Raw(func(driverConn interface{}) (err error) {
+				hits, err = lookasideHits(driverConn.(*conn), reset)
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Ignore the allocations made while opening the connection.
+		hitsFn(true)
+		if _, err := c.ExecContext(ctx, "create table t(i, s); insert into t values(1, 'a'); select * from t where i = 1"); err != nil {
+			t.Fatal(err)
+		}
+
+		hitsFn(false)
+
+		c.Close()
+		db.Close()
+		t.Logf("size %v, count %v: %v hits", tt.size, tt.count, hits)
+		if (hits != 0) != tt.hits {
+			t.Errorf("size %v, count %v: got %v lookaside hits", tt.size, tt.count, hits)
+		}
+	}
+
+	for _, v := range [][2]int{{-8, 10}, {100, 10}, {65536, 10}, {64, -1}} {
+		if _, err := NewConnector(":memory:", Lookaside(v[0], v[1])); err == nil {
+			t.Errorf("%v: expected error", v)
+		}
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.db")
+	open := func(opts ...ConnectorOption) *sql.DB {
+		cn, err := NewConnector(fn, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return sql.OpenDB(cn)
+	}
+
+	db := open(Encoding("UTF-16le"))
+	if _, err := db.Exec("create table t(s); insert into t values('héllo')"); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Close()
+
+	// The encoding of an existing database does not change.
+	db = open(Encoding("utf-8"))
+	defer db.Close()
+
+	ctx := context.Background()
+	enc, err := DatabaseEncoding(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := enc, "UTF-16le"; g != e {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	var s string
+	if err := db.QueryRow("select s from t").Scan(&s); err != nil || s != "héllo" {
+		t.Fatalf("got %q, %v", s, err)
+	}
+
+	if _, err := NewConnector(fn, Encoding("latin1")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestInterruptAll(t *testing.T) {
+	cn, err := NewConnector(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(cn)
+	defer db.Close()
+
+	const queries = 3
+	errs := make(chan error, queries)
+	for i := 0; i < queries; i++ {
+		go func() {
+			var n int
+			errs <- db.QueryRow("with recursive c(x) as (select 1 union all select x+1 from c) select count(*) from c").Scan(&n)
+		}()
+	}
+
+	// Keep interrupting until all queries, which may not have started yet,
+	// have returned.
+	deadline := time.After(10 * time.Second)
+	for i := 0; i < queries; {
+		select {
+		case err := <-errs:
+			if err == nil || !strings.Contains(err.Error(), "interrupted") {
+				t.Fatalf("got %v, want an interrupted error", err)
+			}
+			i++
+		case <-time.After(10 * time.Millisecond):
+			if err := InterruptAll(cn); err != nil {
+				t.Fatal(err)
+			}
+		case <-deadline:
+			t.Fatal("queries not interrupted")
+		}
+	}
+
+	var n int
+	if err := db.QueryRow("select 42").Scan(&n); err != nil || n != 42 {
+		t.Fatalf("got %v, %v", n, err)
+	}
+}
+
+func TestQueryOnly(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open(driverName, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("create table t(i); insert into t values(1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Close()
+
+	cn, err := NewConnector(fn, QueryOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db = sql.OpenDB(cn)
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	var n int
+	if err := db.QueryRow("select count(*) from t").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("got %v, %v", n, err)
+	}
+
+	for _, query := range []string{
+		"insert into t values(2)",
+		"create temp table u(i)",
+	} {
+		if _, err := db.Exec(query); err == nil || !strings.Contains(err.Error(), "readonly") {
+			t.Fatalf("%s: got %v, want a readonly error", query, err)
+		}
+	}
+
+	// The pragma is set again when the connection is reused.
+	if _, err := db.Exec("pragma query_only = off"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("insert into t values(2)"); err == nil || !strings.Contains(err.Error(), "readonly") {
+		t.Fatalf("after reuse: got %v, want a readonly error", err)
+	}
+}
+
+func TestMaxPageCount(t *testing.T) {
+	cn, err := NewConnector(filepath.Join(t.TempDir(), "test.db"), MaxPageCount(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(cn)
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	n, err := CurrentMaxPageCount(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 10 {
+		t.Fatalf("got %v, want 10", n)
+	}
+
+	if _, err := c.ExecContext(ctx, "create table t(b)"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.ExecContext(ctx, "insert into t values(randomblob(100000))")
+	if !errors.Is(err, ErrFull) {
+		t.Fatalf("got %v, want ErrFull", err)
+	}
+
+	if e, ok := err.(*Error); !ok || e.Code() != sqlite3.SQLITE_FULL {
+		t.Fatalf("got %T %v", err, err)
+	}
+
+	if n, err = SetMaxPageCount(ctx, c, 100); err != nil || n != 100 {
+		t.Fatalf("got %v, %v", n, err)
+	}
+
+	if _, err := c.ExecContext(ctx, "insert into t values(randomblob(100000))"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The limit is not set below the current size.
+	if n, err = SetMaxPageCount(ctx, c, 1); err != nil || n <= 1 {
+		t.Fatalf("got %v, %v", n, err)
+	}
+
+	if errors.Is(&Error{code: sqlite3.SQLITE_BUSY}, ErrFull) {
+		t.Fatal("SQLITE_BUSY matches ErrFull")
+	}
+
+	if _, err := NewConnector("test.db", MaxPageCount(0)); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestAutoTxMultiStatement(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		cn, err := NewConnector(":memory:", AutoTxMultiStatement(enabled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db := sql.OpenDB(cn)
+		db.SetMaxOpenConns(1)
+		if _, err := db.Exec("create table t(i unique)"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := db.Exec("insert into t values(1); insert into t values(1)"); err == nil {
+			t.Fatal("unexpected success")
+		}
+
+		count := func() (n int) {
+			if err := db.QueryRow("select count(*) from t").Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+
+			return n
+		}
+
+		want := 1
+		if enabled {
+			want = 0
+		}
+		if g := count(); g != want {
+			t.Fatalf("enabled %v: got %v rows, want %v", enabled, g, want)
+		}
+
+		// A successful batch is committed.
+		if _, err := db.Exec("delete from t; insert into t values(2); insert into t values(3);"); err != nil {
+			t.Fatal(err)
+		}
+
+		if g := count(); g != 2 {
+			t.Fatalf("enabled %v: got %v rows, want 2", enabled, g)
+		}
+
+		// A batch in a transaction is left to the transaction.
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tx.Exec("insert into t values(4); insert into t values(5)"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+
+		if g := count(); g != 2 {
+			t.Fatalf("enabled %v: got %v rows, want 2", enabled, g)
+		}
+
+		db.Close()
+	}
+}
+
+func TestLooseTypeBinding(t *testing.T) {
+	cn, err := NewConnector(":memory:", LooseTypeBinding(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(cn)
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("create table t(i int, v text check(v is null or (json_valid(v) and json_type(v) = 'array')))"); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []int64{1, -2, 1 << 40}
+	names := []string{"a", `"b"`}
+	if _, err := db.Exec("insert into t values(1, ?), (2, ?), (3, ?)", ids, names, []int64(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotIDs []int64
+	if err := db.QueryRow("select v from t where i = 1").Scan(ScanJSON(&gotIDs)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(gotIDs, ids) {
+		t.Errorf("got %v, want %v", gotIDs, ids)
+	}
+
+	var gotNames []string
+	if err := db.QueryRow("select v from t where i = 2").Scan(ScanJSON(&gotNames)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(gotNames, names) {
+		t.Errorf("got %q, want %q", gotNames, names)
+	}
+
+	gotIDs = []int64{42}
+	if err := db.QueryRow("select v from t where i = 3").Scan(ScanJSON(&gotIDs)); err != nil {
+		t.Fatal(err)
+	}
+
+	if gotIDs != nil {
+		t.Errorf("got %v, want nil", gotIDs)
+	}
+
+	// The option is off by default.
+	db2, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db2.Close()
+
+	if _, err := db2.Exec("select ?", ids); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestNoSync(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.db")
+	for _, tt := range []struct {
+		enabled bool
+		want    int
+	}{
+		{false, 2}, // FULL
+		{true, 0},  // OFF
+	} {
+		cn, err := NewConnector(fn, NoSync(tt.enabled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db := sql.OpenDB(cn)
+		if _, err := db.Exec("create table if not exists t(i); insert into t values(1)"); err != nil {
+			t.Fatal(err)
+		}
+
+		var got int
+		if err := db.QueryRow("pragma synchronous").Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			t.Errorf("enabled %v: got synchronous %v, want %v", tt.enabled, got, tt.want)
+		}
+
+		db.Close()
+	}
+}
+
+func TestStableColumnTypes(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		cn, err := NewConnector(":memory:", StableColumnTypes(enabled))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db := sql.OpenDB(cn)
+		db.SetMaxOpenConns(1)
+		if _, err := db.Exec(`
+create table t(n decimal(10, 2), s varchar(10), i integer, b);
+insert into t values
+	(1, 1, 1, 1),
+	(1.5, 1.5, 1.5, 1.5),
+	('x', x'6869', 'x', 'x'),
+	(null, null, null, null);
+`); err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := db.Query("select * from t order by rowid")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got [][]interface{}
+		for rows.Next() {
+			row := make([]interface{}, 4)
+			if err := rows.Scan(&row[0], &row[1], &row[2], &row[3]); err != nil {
+				t.Fatal(err)
+			}
+
+			got = append(got, row)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		want := [][]interface{}{
+			{int64(1), "1", int64(1), int64(1)},
+			{1.5, "1.5", 1.5, 1.5},
+			{"x", []byte("hi"), "x", "x"},
+			{nil, nil, nil, nil},
+		}
+		if enabled {
+			want[0][0] = 1.0
+			want[2][1] = "hi"
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("enabled %v: got %#v, want %#v", enabled, got, want)
+		}
+
+		db.Close()
+	}
+}
+
+func TestMemDBMaxSize(t *testing.T) {
+	for _, dsn := range []string{
+		"file:/TestMemDBMaxSize?vfs=memdb",
+		":memory:",
+	} {
+		cn, err := NewConnector(dsn, MemDBMaxSize(1<<20))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db := sql.OpenDB(cn)
+		if _, err := db.Exec("create table t(b); insert into t values(randomblob(100000))"); err != nil {
+			t.Fatalf("%s: %v", dsn, err)
+		}
+
+		_, err = db.Exec("with recursive c(x) as (select 1 union all select x+1 from c where x < 20) insert into t select randomblob(100000) from c")
+		if !errors.Is(err, ErrFull) {
+			t.Fatalf("%s: got %v, want ErrFull", dsn, err)
+		}
+
+		db.Close()
+	}
+
+	cn, err := NewConnector(filepath.Join(t.TempDir(), "test.db"), MemDBMaxSize(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(cn)
+	defer db.Close()
+
+	if err := db.Ping(); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	if _, err := NewConnector(":memory:", MemDBMaxSize(0)); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	cn, err := NewConnector(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(cn)
+	defer db.Close()
+
+	if _, err := db.Exec("create table t(b); insert into t values(randomblob(100000))"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	// Keep two connections open.
+	var n int
+	if err := db.QueryRow("select count(*) from t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.QueryRowContext(ctx, "select length(b) from t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := PoolStats(cn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats["connections"] != 2 {
+		t.Errorf("got %v connections, want 2", stats["connections"])
+	}
+
+	for _, k := range []string{"cache_hit", "cache_miss", "cache_used", "schema_used"} {
+		if stats[k] <= 0 {
+			t.Errorf("%s: got %v", k, stats[k])
+		}
+	}
+
+	if _, err := PoolStats(nil); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestDeferForeignKeys(t *testing.T) {
+	const schema = `
+create table parent(id integer primary key);
+create table child(id integer primary key, parent references parent(id));
+create table log(n);
+create trigger tr after insert on log when new.n < 3 begin insert into log values(new.n+1); end;
+`
+	for _, deferred := range []bool{false, true} {
+		t.Run(fmt.Sprint(deferred), func(t *testing.T) {
+			cn, err := NewConnector(
+				"file::memory:?_pragma=foreign_keys(1)",
+				DeferForeignKeys(deferred),
+				RecursiveTriggers(true),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db := sql.OpenDB(cn)
+			defer db.Close()
+
+			db.SetMaxOpenConns(1)
+			if _, err := db.Exec(schema); err != nil {
+				t.Fatal(err)
+			}
+
+			// Two transactions, as the pragma resets at each commit.
+			for i := 1; i <= 2; i++ {
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				_, err = tx.Exec("insert into child values(?, ?)", i, i)
+				if !deferred {
+					if err == nil {
+						t.Fatal("unexpected success")
+					}
+
+					tx.Rollback()
+					return
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := tx.Exec("insert into parent values(?)", i); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := tx.Commit(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// A dangling reference still fails at commit.
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := tx.Exec("insert into child values(3, 3)"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tx.Commit(); err == nil {
+				t.Fatal("unexpected success")
+			}
+
+			if _, err := db.Exec("insert into log values(1)"); err != nil {
+				t.Fatal(err)
+			}
+
+			var n int
+			if err := db.QueryRow("select count(*) from log").Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+
+			if n != 3 {
+				t.Fatalf("got %v log rows, want 3", n)
+			}
+		})
+	}
+}
+
+func TestWorkerThreads(t *testing.T) {
+	cn, err := NewConnector(filepath.Join(t.TempDir(), "test.db"), WorkerThreads(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(cn)
+	defer db.Close()
+
+	ctx := context.Background()
+	n, err := CurrentWorkerThreads(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 4 {
+		t.Fatalf("got %v threads, want 4", n)
+	}
+
+	// A sort larger than the cache, which SQLite spills to sorted runs
+	// merged by the worker threads.
+	if _, err := db.Exec(`
+pragma cache_size = 10;
+create table t(i, s);
+with recursive c(x) as (select 1 union all select x+1 from c where x < 100000)
+insert into t select abs(random()) % 1000000, hex(randomblob(20)) from c;
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query("select i from t order by i, s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	prev, count := -1, 0
+	for rows.Next() {
+		var i int
+		if err := rows.Scan(&i); err != nil {
+			t.Fatal(err)
+		}
+
+		if i < prev {
+			t.Fatalf("unsorted: %v after %v", i, prev)
+		}
+
+		prev = i
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 100000 {
+		t.Fatalf("got %v rows", count)
+	}
+
+	if _, err := NewConnector(":memory:", WorkerThreads(-1)); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestEmptyStringAsNull(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprint(enabled), func(t *testing.T) {
+			cn, err := NewConnector(":memory:", EmptyStringAsNull(enabled))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db := sql.OpenDB(cn)
+			defer db.Close()
+
+			db.SetMaxOpenConns(1)
+			if _, err := db.Exec("create table t(s, r not null)"); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := db.Exec("insert into t values(?, 'x'), (?, 'y')", "", AsText([]byte{})); err != nil {
+				t.Fatal(err)
+			}
+
+			var got string
+			if err := db.QueryRow("select group_concat(quote(s), ' ') from t").Scan(&got); err != nil {
+				t.Fatal(err)
+			}
+
+			want := "'' ''"
+			if enabled {
+				want = "NULL ''"
+			}
+			if got != want {
+				t.Fatalf("got %s, want %s", got, want)
+			}
+
+			_, err = db.Exec("insert into t values('', ?)", "")
+			if g, e := err != nil, enabled; g != e {
+				t.Fatalf("NOT NULL column: got %v", err)
+			}
+		})
+	}
+}
+
+func TestAudit(t *testing.T) {
+	var events []AuditEvent
+	cn, err := NewConnector(":memory:", Audit(func(e AuditEvent) { events = append(events, e) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(cn)
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("create table t(i)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("insert into t values(?), (?), (?)", 1, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query("update t set i = i + 1 where i > ? returning i", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for rows.Next() {
+	}
+	rows.Close()
+
+	stmt, err := db.Prepare("select count(*) from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := stmt.QueryRow().Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	stmt.Close()
+	if _, err := db.Exec("insert into nonexistent values(1)"); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	type event struct {
+		sql          string
+		args         int
+		query        bool
+		rowsAffected int64
+		err          bool
+	}
+	var got []event
+	for _, v := range events {
+		if v.Duration <= 0 {
+			t.Errorf("%q: got duration %v", v.SQL, v.Duration)
+		}
+		got = append(got, event{v.SQL, v.Args, v.Query, v.RowsAffected, v.Err != nil})
+	}
+	want := []event{
+		{"create table t(i)", 0, false, 0, false},
+		{"insert into t values(?), (?), (?)", 3, false, 3, false},
+		{"update t set i = i + 1 where i > ? returning i", 1, true, 2, false},
+		{"select count(*) from t", 0, true, 0, false},
+		{"insert into nonexistent values(1)", 0, false, 0, true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
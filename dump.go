@@ -0,0 +1,284 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Dump writes the schema and the contents of the main database to w as SQL
+// text, like the .dump command of the sqlite3 CLI does. Executing the text on
+// an empty database recreates the database. BLOBs are written as X'...'
+// literals and floating point values are written so that they read back
+// exactly. Pass a *sql.Tx as q to get a consistent snapshot.
+//
+// Like the CLI, virtual tables are recreated by inserting their definition
+// into sqlite_schema with PRAGMA writable_schema enabled, while their shadow
+// tables, which store their contents, are dumped as regular tables. This
+// requires that the database used to replay the dump is not in defensive
+// mode, and the virtual tables can be used only by connections opened after
+// the replay.
+func Dump(ctx context.Context, q Querier, w io.Writer) error {
+	return dump(ctx, q, w, true)
+}
+
+// DumpSchema is like Dump but writes only the CREATE statements.
+func DumpSchema(ctx context.Context, q Querier, w io.Writer) error {
+	return dump(ctx, q, w, false)
+}
+
+type dumpObject struct {
+	typ, name, sql string
+}
+
+func dump(ctx context.Context, q Querier, w io.Writer, data bool) error {
+	objects, err := dumpObjects(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n")
+
+	// Tables go first, followed by the indexes, triggers and views that may
+	// refer to them.
+	var writableSchema bool
+	for _, o := range objects {
+		if o.typ != "table" {
+			continue
+		}
+
+		switch {
+		case o.name == "sqlite_sequence":
+			if data {
+				bw.WriteString("DELETE FROM sqlite_sequence;\n")
+			}
+		case strings.HasPrefix(o.name, "sqlite_"):
+			continue
+		case strings.HasPrefix(strings.ToUpper(o.sql), "CREATE VIRTUAL"):
+			if !writableSchema {
+				bw.WriteString("PRAGMA writable_schema=ON;\n")
+				writableSchema = true
+			}
+			fmt.Fprintf(bw, "INSERT INTO sqlite_schema(type,name,tbl_name,rootpage,sql) VALUES('table',%s,%[1]s,0,%s);\n", QuoteString(o.name), QuoteString(o.sql))
+			continue
+		default:
+			fmt.Fprintf(bw, "%s;\n", o.sql)
+		}
+
+		if data {
+			if err := dumpTable(ctx, q, bw, o.name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, o := range objects {
+		if o.typ != "table" && o.sql != "" {
+			fmt.Fprintf(bw, "%s;\n", o.sql)
+		}
+	}
+	if writableSchema {
+		bw.WriteString("PRAGMA writable_schema=OFF;\n")
+	}
+	bw.WriteString("COMMIT;\n")
+	return bw.Flush()
+}
+
+func dumpObjects(ctx context.Context, q Querier) (r []dumpObject, err error) {
+	rows, err := q.QueryContext(ctx, "select type, name, coalesce(sql, '') from main.sqlite_schema order by rowid")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var o dumpObject
+		if err := rows.Scan(&o.typ, &o.name, &o.sql); err != nil {
+			return nil, err
+		}
+
+		r = append(r, o)
+	}
+	return r, rows.Err()
+}
+
+// dumpTable writes the rows of table as INSERT statements. Values are
+// formatted by the quote() SQL function. Generated columns are skipped.
+func dumpTable(ctx context.Context, q Querier, w *bufio.Writer, table string) error {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("select name, hidden from pragma_table_xinfo(%s, 'main')", QuoteString(table)))
+	if err != nil {
+		return err
+	}
+
+	var cols, quoted []string
+	var generated bool
+	for rows.Next() {
+		var name string
+		var hidden int
+		if err := rows.Scan(&name, &hidden); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if hidden != 0 {
+			generated = true
+			continue
+		}
+
+		cols = append(cols, QuoteIdentifier(name))
+		quoted = append(quoted, fmt.Sprintf("quote(%s)", QuoteIdentifier(name)))
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+
+	rows.Close()
+	insert := fmt.Sprintf("INSERT INTO %s VALUES(", QuoteIdentifier(table))
+	if generated {
+		insert = fmt.Sprintf("INSERT INTO %s(%s) VALUES(", QuoteIdentifier(table), strings.Join(cols, ","))
+	}
+
+	if rows, err = q.QueryContext(ctx, fmt.Sprintf("select %s from main.%s", strings.Join(quoted, ", "), QuoteIdentifier(table))); err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	values := make([]string, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+
+		w.WriteString(insert)
+		w.WriteString(strings.Join(values, ","))
+		w.WriteString(");\n")
+	}
+	return rows.Err()
+}
+
+// SchemaSnapshot returns the schema of the main database as CREATE
+// statements, one per line, in a canonical form suitable for comparing
+// schemas, eg. to check in CI that a database migrated by the application
+// matches a golden file. The tables, indexes, views and triggers are sorted
+// by type and name, so the order of their creation does not matter, and the
+// statements are stripped of comments and have their whitespace normalized.
+// Everything else, including the order of columns, is significant. Internal
+// objects, eg. sqlite_sequence, are omitted.
+//
+// The snapshot is read by a single query, so it is consistent.
+func SchemaSnapshot(ctx context.Context, q Querier) (string, error) {
+	rows, err := q.QueryContext(ctx, `select type, name, sql from main.sqlite_schema where sql is not null and name not like 'sqlite\_%' escape '\'`)
+	if err != nil {
+		return "", err
+	}
+
+	defer rows.Close()
+
+	var objects []dumpObject
+	for rows.Next() {
+		var o dumpObject
+		if err := rows.Scan(&o.typ, &o.name, &o.sql); err != nil {
+			return "", err
+		}
+
+		o.sql = canonicalSQL(o.sql)
+		objects = append(objects, o)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	rank := map[string]int{"table": 0, "index": 1, "view": 2, "trigger": 3}
+	sort.Slice(objects, func(i, j int) bool {
+		a, b := objects[i], objects[j]
+		if rank[a.typ] != rank[b.typ] {
+			return rank[a.typ] < rank[b.typ]
+		}
+
+		return a.name < b.name
+	})
+
+	var b strings.Builder
+	for _, o := range objects {
+		b.WriteString(o.sql)
+		b.WriteString(";\n")
+	}
+	return b.String(), nil
+}
+
+// canonicalSQL returns s with comments removed and whitespace outside of
+// literals and quoted identifiers collapsed to single spaces. There is no
+// space around '(' or before ')' and ',', and exactly one after ','.
+func canonicalSQL(s string) string {
+	var b strings.Builder
+	var last byte
+	space := false
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '-' && strings.HasPrefix(s[i:], "--"):
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		case c == '/' && strings.HasPrefix(s[i:], "/*"):
+			if j := strings.Index(s[i+2:], "*/"); j >= 0 {
+				i += j + 4
+			} else {
+				i = len(s)
+			}
+			space = true
+			continue
+		case strings.IndexByte(" \t\n\r\f\v", c) >= 0:
+			space = true
+			i++
+			continue
+		}
+
+		if space && last != 0 && last != '(' && !strings.ContainsRune("(),", rune(c)) {
+			b.WriteByte(' ')
+		}
+		space = c == ','
+
+		j := i + 1
+		switch c {
+		case '\'', '"', '`', '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			for ; j < len(s); j++ {
+				if s[j] != end {
+					continue
+				}
+
+				// A doubled quote character stands for itself.
+				if end == ']' || j+1 == len(s) || s[j+1] != end {
+					j++
+					break
+				}
+
+				j++
+			}
+		}
+		b.WriteString(s[i:j])
+		last = s[j-1]
+		i = j
+	}
+	return b.String()
+}
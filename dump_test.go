@@ -0,0 +1,166 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	src, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer src.Close()
+
+	src.SetMaxOpenConns(1)
+	if _, err := src.Exec(`
+	create table "odd ""name"""(i integer primary key autoincrement, s text, b blob, f real, g as (i * 2));
+	insert into "odd ""name"""(s, b, f) values('it''s', x'00ff10', 0.1), (null, x'', 1e300), ('line
+break', null, -2.5);
+	create index odd_s on "odd ""name"""(s);
+	create view v as select s from "odd ""name""";
+	create trigger tr after insert on "odd ""name""" begin select 1; end;
+	create virtual table ft using fts5(x);
+	insert into ft values('hello world');
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	var buf bytes.Buffer
+	if err := Dump(ctx, src, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("\n%s", buf.Bytes())
+	for _, s := range []string{
+		"INSERT INTO \"odd \"\"name\"\"\"(\"i\",\"s\",\"b\",\"f\") VALUES(1,'it''s',X'00FF10',0.1);\n",
+		"DELETE FROM sqlite_sequence;\n",
+		"VALUES('table','ft','ft',0,'CREATE VIRTUAL TABLE ft using fts5(x)');\n",
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("dump does not contain %q", s)
+		}
+	}
+
+	// The virtual tables are visible only to connections opened after the
+	// dump was replayed.
+	fn := filepath.Join(t.TempDir(), "test.db")
+	dst, err := sql.Open(driverName, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dst.Exec(buf.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	dst.Close()
+	if dst, err = sql.Open(driverName, fn); err != nil {
+		t.Fatal(err)
+	}
+
+	defer dst.Close()
+
+	d0, err := DatabaseDigest(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d1, err := DatabaseDigest(ctx, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(d0, d1) {
+		t.Fatal("replayed dump differs")
+	}
+
+	var s string
+	if err := dst.QueryRow("select x from ft where ft match 'hello'").Scan(&s); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := DumpSchema(ctx, src, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), "INSERT INTO \"") || !strings.Contains(buf.String(), "CREATE VIEW v") {
+		t.Fatalf("unexpected schema dump\n%s", buf.Bytes())
+	}
+}
+
+func TestSchemaSnapshot(t *testing.T) {
+	snapshot := func(schema string) string {
+		db, err := sql.Open(driverName, "file::memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer db.Close()
+
+		if _, err := db.Exec(schema); err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := SchemaSnapshot(context.Background(), db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return s
+	}
+
+	base := snapshot(`
+create table t(a integer primary key autoincrement, b text default 'x  y');
+create table u(c);
+create index t_b on t(b);
+`)
+	if g, e := base, "CREATE TABLE t(a integer primary key autoincrement, b text default 'x  y');\nCREATE TABLE u(c);\nCREATE INDEX t_b on t(b);\n"; g != e {
+		t.Fatalf("got\n%s\nwant\n%s", g, e)
+	}
+
+	for _, tt := range []struct {
+		schema string
+		same   bool
+	}{
+		{`
+create table u(c);
+create table t(
+	a integer primary key autoincrement, -- the id
+	b text default 'x  y' /* the name */
+);
+create index t_b on t ( b );
+`, true},
+		{`
+create table t(b text default 'x  y', a integer primary key autoincrement);
+create table u(c);
+create index t_b on t(b);
+`, false},
+		{`
+create table t(a integer primary key autoincrement, b text default 'x  y');
+create table u(c);
+create index t_b on t(b);
+create index u_c on u(c);
+`, false},
+		{`
+create table t(a integer primary key autoincrement, b text default 'x y');
+create table u(c);
+create index t_b on t(b);
+`, false},
+	} {
+		if got := snapshot(tt.schema); (got == base) != tt.same {
+			t.Errorf("%q: got snapshot\n%s\nsame as\n%s: %v, want %v", tt.schema, got, base, got == base, tt.same)
+		}
+	}
+}
@@ -10,8 +10,10 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -120,6 +122,190 @@ func init() {
 			return hex.EncodeToString(w.Sum(nil)), nil
 		},
 	)
+
+	sqlite3.MustRegisterDeterministicScalarFunction(
+		"test_value_type",
+		1,
+		func(ctx *sqlite3.FunctionContext, args []driver.Value) (driver.Value, error) {
+			v := ctx.Value(0)
+			return fmt.Sprintf("%v %v", v.Type(), v.NumericType()), nil
+		},
+	)
+
+	sqlite3.MustRegisterDeterministicScalarFunction(
+		"test_return",
+		1,
+		func(ctx *sqlite3.FunctionContext, args []driver.Value) (driver.Value, error) {
+			switch args[0].(string) {
+			case "nil":
+				return nil, nil
+			case "nil byte slice":
+				return []byte(nil), nil
+			case "empty byte slice":
+				return []byte{}, nil
+			case "nullable nil":
+				return sqlite3.NullableBlob(nil), nil
+			case "nullable empty":
+				return sqlite3.NullableBlob([]byte{}), nil
+			case "as blob":
+				return sqlite3.AsBlob("a"), nil
+			case "as text":
+				return sqlite3.AsText([]byte("a")), nil
+			case "null string":
+				return sql.NullString{}, nil
+			case "valid null string":
+				return sql.NullString{String: "a", Valid: true}, nil
+			case "null int64":
+				return sql.NullInt64{}, nil
+			case "valid null int64":
+				return sql.NullInt64{Int64: 42, Valid: true}, nil
+			case "nil null string pointer":
+				return (*sql.NullString)(nil), nil
+			case "nil uuid pointer":
+				return (*sqlite3.UUID)(nil), nil
+			}
+			return struct{}{}, nil
+		},
+	)
+
+	sqlite3.MustRegisterDeterministicScalarFunction(
+		"test_json",
+		2,
+		func(ctx *sqlite3.FunctionContext, args []driver.Value) (driver.Value, error) {
+			if args[1].(int64) != 0 {
+				ctx.SetResultSubtype('J')
+			}
+			return args[0], nil
+		},
+	)
+
+	sqlite3.MustRegisterDeterministicScalarFunction(
+		"coalesce_custom",
+		-1,
+		func(ctx *sqlite3.FunctionContext, args []driver.Value) (driver.Value, error) {
+			for _, v := range args {
+				if v != nil {
+					return v, nil
+				}
+			}
+			return nil, nil
+		},
+	)
+
+	sqlite3.MustRegisterDeterministicScalarFunction(
+		"test_arity",
+		1,
+		func(ctx *sqlite3.FunctionContext, args []driver.Value) (driver.Value, error) {
+			return "one", nil
+		},
+	)
+
+	sqlite3.MustRegisterDeterministicScalarFunction(
+		"test_arity",
+		2,
+		func(ctx *sqlite3.FunctionContext, args []driver.Value) (driver.Value, error) {
+			return "two", nil
+		},
+	)
+
+	sqlite3.MustRegisterAggregateFunction(
+		"test_median",
+		1,
+		func() sqlite3.Aggregator { return &medianAggregator{} },
+	)
+
+	sqlite3.MustRegisterAggregateFunction(
+		"test_stats",
+		1,
+		func() sqlite3.Aggregator { return &statsAggregator{} },
+	)
+
+	sqlite3.MustRegisterWindowFunction(
+		"test_moving_sum",
+		1,
+		func() sqlite3.WindowFunction { return &movingSum{} },
+	)
+}
+
+// movingSum is a window function summing its arguments. It records the
+// arguments passed to Inverse in inversed.
+type movingSum struct {
+	sum int64
+}
+
+var inversed []int64
+
+func (w *movingSum) Step(ctx *sqlite3.FunctionContext, args []driver.Value) error {
+	w.sum += args[0].(int64)
+	return nil
+}
+
+func (w *movingSum) Inverse(ctx *sqlite3.FunctionContext, args []driver.Value) error {
+	inversed = append(inversed, args[0].(int64))
+	w.sum -= args[0].(int64)
+	return nil
+}
+
+func (w *movingSum) Value(ctx *sqlite3.FunctionContext) (driver.Value, error) { return w.sum, nil }
+
+func (w *movingSum) Final(ctx *sqlite3.FunctionContext) (driver.Value, error) { return w.sum, nil }
+
+// medianAggregator computes the median of its numeric arguments, or NULL if
+// there are none. It fails for a negative argument.
+type medianAggregator struct {
+	values []float64
+}
+
+func (a *medianAggregator) Step(ctx *sqlite3.FunctionContext, args []driver.Value) error {
+	switch x := args[0].(type) {
+	case int64:
+		if x < 0 {
+			return errors.New("negative value")
+		}
+
+		a.values = append(a.values, float64(x))
+	case float64:
+		a.values = append(a.values, x)
+	}
+	return nil
+}
+
+func (a *medianAggregator) Final(ctx *sqlite3.FunctionContext) (driver.Value, error) {
+	if len(a.values) == 0 {
+		return nil, nil
+	}
+
+	sort.Float64s(a.values)
+	n := len(a.values)
+	if n%2 == 0 {
+		return (a.values[n/2-1] + a.values[n/2]) / 2, nil
+	}
+
+	return a.values[n/2], nil
+}
+
+// statsAggregator returns the sum and the count of its arguments as a JSON
+// object.
+type statsAggregator struct {
+	Sum   int64 `json:"sum"`
+	Count int64 `json:"count"`
+}
+
+func (a *statsAggregator) Step(ctx *sqlite3.FunctionContext, args []driver.Value) error {
+	n, _ := args[0].(int64)
+	a.Sum += n
+	a.Count++
+	return nil
+}
+
+func (a *statsAggregator) Final(ctx *sqlite3.FunctionContext) (driver.Value, error) {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.SetResultSubtype('J')
+	return string(b), nil
 }
 
 func TestRegisteredFunctions(t *testing.T) {
@@ -289,4 +475,258 @@ func TestRegisteredFunctions(t *testing.T) {
 			}
 		})
 	})
+
+	t.Run("result subtype", func(tt *testing.T) {
+		withDB(func(db *sql.DB) {
+			row := db.QueryRow(`select json_array(test_json('{"a":1}', 0)), json_array(test_json('{"a":1}', 1))`)
+
+			var text, json string
+			if err := row.Scan(&text, &json); err != nil {
+				tt.Fatal(err)
+			}
+			if g, e := text, `["{\"a\":1}"]`; g != e {
+				tt.Fatal(g, e)
+			}
+			if g, e := json, `[{"a":1}]`; g != e {
+				tt.Fatal(g, e)
+			}
+		})
+	})
+
+	t.Run("return values", func(tt *testing.T) {
+		withDB(func(db *sql.DB) {
+			for _, v := range []struct {
+				name, want string
+			}{
+				{"nil", "NULL"},
+				{"nil byte slice", "X''"},
+				{"empty byte slice", "X''"},
+				{"nullable nil", "NULL"},
+				{"nullable empty", "X''"},
+				{"as blob", "X'61'"},
+				{"as text", "'a'"},
+				{"null string", "NULL"},
+				{"valid null string", "'a'"},
+				{"null int64", "NULL"},
+				{"valid null int64", "42"},
+				{"nil null string pointer", "NULL"},
+				{"nil uuid pointer", "NULL"},
+			} {
+				var got string
+				if err := db.QueryRow("select quote(test_return(?))", v.name).Scan(&got); err != nil {
+					tt.Fatal(v.name, err)
+				}
+				if got != v.want {
+					tt.Errorf("%s: got %s, want %s", v.name, got, v.want)
+				}
+			}
+
+			if err := db.QueryRow("select test_return('invalid')").Scan(new(interface{})); err == nil {
+				tt.Fatal("expected error, got none")
+			}
+		})
+	})
+
+	t.Run("value type", func(tt *testing.T) {
+		withDB(func(db *sql.DB) {
+			if _, err := db.Exec("create table t(s text, i integer); insert into t values ('42', '42'), ('4.5', 'x')"); err != nil {
+				tt.Fatal(err)
+			}
+
+			rows, err := db.Query("select test_value_type(s), test_value_type(i) from t")
+			if err != nil {
+				tt.Fatal(err)
+			}
+
+			defer rows.Close()
+
+			var a []string
+			for rows.Next() {
+				var s, i string
+				if err := rows.Scan(&s, &i); err != nil {
+					tt.Fatal(err)
+				}
+				a = append(a, s, i)
+			}
+			if err := rows.Err(); err != nil {
+				tt.Fatal(err)
+			}
+			if g, e := strings.Join(a, ", "), "TEXT INTEGER, INTEGER INTEGER, TEXT FLOAT, TEXT TEXT"; g != e {
+				tt.Fatal(g, e)
+			}
+		})
+	})
+
+	t.Run("variadic", func(tt *testing.T) {
+		withDB(func(db *sql.DB) {
+			var a, b, c interface{}
+			if err := db.QueryRow("select coalesce_custom(), coalesce_custom(null, null, 3), coalesce_custom(null, 'x', 1, 2, 3, 4, 5)").Scan(&a, &b, &c); err != nil {
+				tt.Fatal(err)
+			}
+
+			if a != nil || b != int64(3) || c != "x" {
+				tt.Fatalf("got %v, %v, %v", a, b, c)
+			}
+		})
+	})
+
+	t.Run("arities", func(tt *testing.T) {
+		withDB(func(db *sql.DB) {
+			var a, b string
+			if err := db.QueryRow("select test_arity(1), test_arity(1, 2)").Scan(&a, &b); err != nil {
+				tt.Fatal(err)
+			}
+
+			if a != "one" || b != "two" {
+				tt.Fatalf("got %q, %q", a, b)
+			}
+
+			if err := db.QueryRow("select test_arity(1, 2, 3)").Scan(&a); err == nil {
+				tt.Fatal("expected error, got none")
+			}
+
+			f := func(*sqlite3.FunctionContext, []driver.Value) (driver.Value, error) { return nil, nil }
+			if err := sqlite3.RegisterDeterministicScalarFunction("test_arity", 2, f); err == nil {
+				tt.Fatal("expected error, got none")
+			}
+
+			if err := sqlite3.RegisterDeterministicScalarFunction("coalesce_custom", -1, f); err == nil {
+				tt.Fatal("expected error, got none")
+			}
+		})
+	})
+
+	t.Run("aggregate", func(tt *testing.T) {
+		withDB(func(db *sql.DB) {
+			if _, err := db.Exec(`
+			create table t(g, v);
+			insert into t values(1, 3), (1, 1), (1, 2), (2, 10), (2, 20), (3, null);
+			`); err != nil {
+				tt.Fatal(err)
+			}
+
+			rows, err := db.Query("select g, test_median(v) from t group by g order by g")
+			if err != nil {
+				tt.Fatal(err)
+			}
+
+			defer rows.Close()
+
+			var a []string
+			for rows.Next() {
+				var g int
+				var m sql.NullFloat64
+				if err := rows.Scan(&g, &m); err != nil {
+					tt.Fatal(err)
+				}
+				a = append(a, fmt.Sprintf("%d:%v", g, m))
+			}
+			if err := rows.Err(); err != nil {
+				tt.Fatal(err)
+			}
+			if g, e := strings.Join(a, " "), "1:{2 true} 2:{15 true} 3:{0 false}"; g != e {
+				tt.Fatalf("got %q, want %q", g, e)
+			}
+
+			// No rows.
+			var m interface{}
+			if err := db.QueryRow("select test_median(v) from t where 0").Scan(&m); err != nil || m != nil {
+				tt.Fatalf("got %v, %v", m, err)
+			}
+
+			if _, err := db.Exec("insert into t values(4, -1)"); err != nil {
+				tt.Fatal(err)
+			}
+
+			err = db.QueryRow("select test_median(v) from t").Scan(&m)
+			if err == nil || !strings.Contains(err.Error(), "negative value") {
+				tt.Fatalf("got %v, want an error", err)
+			}
+
+			if err := sqlite3.RegisterAggregateFunction("test_median", 1, func() sqlite3.Aggregator { return nil }); err == nil {
+				tt.Fatal("expected error, got none")
+			}
+		})
+	})
+
+	t.Run("window", func(tt *testing.T) {
+		withDB(func(db *sql.DB) {
+			if _, err := db.Exec(`
+			create table t(g, x);
+			insert into t values(1, 1), (1, 2), (1, 3), (1, 4), (1, 5), (1, 6), (2, 10), (2, 20);
+			`); err != nil {
+				tt.Fatal(err)
+			}
+
+			inversed = nil
+			rows, err := db.Query(`
+			select test_moving_sum(x) over (partition by g order by x rows between 2 preceding and current row)
+			from t order by g, x`)
+			if err != nil {
+				tt.Fatal(err)
+			}
+
+			defer rows.Close()
+
+			var a []string
+			for rows.Next() {
+				var sum int64
+				if err := rows.Scan(&sum); err != nil {
+					tt.Fatal(err)
+				}
+				a = append(a, fmt.Sprint(sum))
+			}
+			if err := rows.Err(); err != nil {
+				tt.Fatal(err)
+			}
+			if g, e := strings.Join(a, " "), "1 3 6 9 12 15 10 30"; g != e {
+				tt.Fatalf("got %q, want %q", g, e)
+			}
+
+			// Rows 1, 2 and 3 leave the frame of the first partition, none
+			// leaves the second one.
+			if g, e := fmt.Sprint(inversed), "[1 2 3]"; g != e {
+				tt.Fatalf("got inverse calls for %s, want %s", g, e)
+			}
+
+			var sum int64
+			if err := db.QueryRow("select test_moving_sum(x) from t where g = 1").Scan(&sum); err != nil || sum != 21 {
+				tt.Fatalf("got %v, %v", sum, err)
+			}
+		})
+	})
+
+	t.Run("aggregate json", func(tt *testing.T) {
+		withDB(func(db *sql.DB) {
+			if _, err := db.Exec("create table t(g, v); insert into t values(1, 3), (1, 4), (2, 5)"); err != nil {
+				tt.Fatal(err)
+			}
+
+			rows, err := db.Query(`
+			select test_stats(v), json_extract(test_stats(v), '$.sum'), json_extract(test_stats(v), '$.count'), json_array(test_stats(v))
+			from t group by g order by g`)
+			if err != nil {
+				tt.Fatal(err)
+			}
+
+			defer rows.Close()
+
+			var a []string
+			for rows.Next() {
+				var stats, array string
+				var sum, count int64
+				if err := rows.Scan(&stats, &sum, &count, &array); err != nil {
+					tt.Fatal(err)
+				}
+				a = append(a, fmt.Sprintf("%s %d %d %s", stats, sum, count, array))
+			}
+			if err := rows.Err(); err != nil {
+				tt.Fatal(err)
+			}
+			if g, e := strings.Join(a, "\n"), `{"sum":7,"count":2} 7 2 [{"sum":7,"count":2}]
+{"sum":5,"count":1} 5 1 [{"sum":5,"count":1}]`; g != e {
+				tt.Fatalf("got\n%s\nwant\n%s", g, e)
+			}
+		})
+	})
 }
@@ -0,0 +1,47 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"sync"
+)
+
+// handles maps the opaque values passed through SQLite to callbacks, like the
+// pAux argument of sqlite3_create_module_v2, to the Go values they stand for.
+// Go pointers cannot be stored in memory managed by SQLite.
+var handles = struct {
+	sync.Mutex
+	m    map[uintptr]interface{}
+	next uintptr
+}{
+	m: map[uintptr]interface{}{},
+}
+
+// newHandle returns a new non zero handle for v.
+func newHandle(v interface{}) uintptr {
+	handles.Lock()
+	defer handles.Unlock()
+
+	handles.next++
+	h := handles.next
+	handles.m[h] = v
+	return h
+}
+
+// handleValue returns the value registered for h.
+func handleValue(h uintptr) interface{} {
+	handles.Lock()
+	defer handles.Unlock()
+
+	return handles.m[h]
+}
+
+// deleteHandle releases h.
+func deleteHandle(h uintptr) {
+	handles.Lock()
+	defer handles.Unlock()
+
+	delete(handles.m, h)
+}
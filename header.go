@@ -0,0 +1,75 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// headerSize is the size of the database file header,
+// https://www.sqlite.org/fileformat2.html#the_database_header
+const headerSize = 100
+
+const headerMagic = "SQLite format 3\x00"
+
+// DatabaseHeader is the metadata stored in the header of a database file.
+type DatabaseHeader struct {
+	PageSize      int    // page size in bytes, PRAGMA page_size
+	WriteVersion  int    // 1 for legacy (rollback journal), 2 for WAL
+	ReadVersion   int    // 1 for legacy (rollback journal), 2 for WAL
+	SchemaFormat  int    // schema format number, 1 to 4
+	TextEncoding  string // "UTF-8", "UTF-16le" or "UTF-16be", PRAGMA encoding
+	UserVersion   int32  // PRAGMA user_version
+	ApplicationID int32  // PRAGMA application_id
+	// SQLiteVersion is the SQLITE_VERSION_NUMBER of the library that most
+	// recently modified the database, eg. 3041002.
+	SQLiteVersion int
+}
+
+// ReadDatabaseHeader parses the 100 byte header at the start of a database
+// file read from r. It does not open the database, so it can inspect a file
+// before it is opened, eg. to validate or migrate it, and it works with any
+// read-only source, like a file of an embed.FS served by the vfs package.
+// The header of a database in WAL mode may be outdated until the WAL is
+// checkpointed.
+func ReadDatabaseHeader(r io.Reader) (h DatabaseHeader, err error) {
+	var b [headerSize]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return h, fmt.Errorf("sqlite: database header: file is too short")
+		}
+
+		return h, err
+	}
+
+	if string(b[:len(headerMagic)]) != headerMagic {
+		return h, fmt.Errorf("sqlite: database header: not a database file")
+	}
+
+	h.PageSize = int(binary.BigEndian.Uint16(b[16:]))
+	if h.PageSize == 1 {
+		h.PageSize = 65536
+	}
+	h.WriteVersion = int(b[18])
+	h.ReadVersion = int(b[19])
+	h.SchemaFormat = int(binary.BigEndian.Uint32(b[44:]))
+	switch enc := binary.BigEndian.Uint32(b[56:]); enc {
+	case 1:
+		h.TextEncoding = "UTF-8"
+	case 2:
+		h.TextEncoding = "UTF-16le"
+	case 3:
+		h.TextEncoding = "UTF-16be"
+	default:
+		return h, fmt.Errorf("sqlite: database header: invalid text encoding %d", enc)
+	}
+	h.UserVersion = int32(binary.BigEndian.Uint32(b[60:]))
+	h.ApplicationID = int32(binary.BigEndian.Uint32(b[68:]))
+	h.SQLiteVersion = int(binary.BigEndian.Uint32(b[96:]))
+	return h, nil
+}
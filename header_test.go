@@ -0,0 +1,99 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"modernc.org/sqlite/vfs"
+)
+
+func TestReadDatabaseHeader(t *testing.T) {
+	f, err := fs.Open("embed.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := ReadDatabaseHeader(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := h, (DatabaseHeader{PageSize: 4096, WriteVersion: 1, ReadVersion: 1, SchemaFormat: 4, TextEncoding: "UTF-8", SQLiteVersion: h.SQLiteVersion}); g != e {
+		t.Fatalf("got %+v, want %+v", g, e)
+	}
+
+	// The header agrees with the pragmas of the database opened read-only
+	// through the embed VFS.
+	fn, vf, err := vfs.New(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer vf.Close()
+
+	db, err := sql.Open(driverName, "file:embed.db?vfs="+fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	var pageSize int
+	var encoding string
+	if err := db.QueryRow("select page_size, encoding from pragma_page_size, pragma_encoding").Scan(&pageSize, &encoding); err != nil {
+		t.Fatal(err)
+	}
+
+	if pageSize != h.PageSize || encoding != h.TextEncoding {
+		t.Fatalf("got %v, %v, want %v, %v", h.PageSize, h.TextEncoding, pageSize, encoding)
+	}
+
+	dbfn := filepath.Join(t.TempDir(), "test.db")
+	if db, err = sql.Open(driverName, dbfn); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(`
+	pragma encoding = 'UTF-16be';
+	pragma page_size = 1024;
+	pragma journal_mode = wal;
+	pragma user_version = -7;
+	pragma application_id = 1234567;
+	create table t(i);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Close()
+	if f, err = os.Open(dbfn); err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if h, err = ReadDatabaseHeader(f); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := h, (DatabaseHeader{PageSize: 1024, WriteVersion: 2, ReadVersion: 2, SchemaFormat: 4, TextEncoding: "UTF-16be", UserVersion: -7, ApplicationID: 1234567, SQLiteVersion: h.SQLiteVersion}); g != e {
+		t.Fatalf("got %+v, want %+v", g, e)
+	}
+
+	if h.SQLiteVersion < 3041000 {
+		t.Fatalf("unexpected SQLite version number %v", h.SQLiteVersion)
+	}
+
+	for _, s := range []string{"", "SQLite format 3", strings.Repeat("x", 100)} {
+		if _, err := ReadDatabaseHeader(strings.NewReader(s)); err == nil {
+			t.Fatalf("%q: unexpected success", s)
+		}
+	}
+}
@@ -0,0 +1,1391 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Querier is the subset of the database/sql API used by the helpers of this
+// package. It is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// QuoteIdentifier returns s quoted as an SQL identifier, eg. a table or
+// column name, by enclosing it in double quotes and doubling the double quotes
+// it contains. The result is safe to use in a query even when s is a keyword
+// or comes from an untrusted source.
+//
+//	QuoteIdentifier(`my "table"`) == `"my ""table"""`
+func QuoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// QuoteString returns s quoted as an SQL string literal by enclosing it in
+// single quotes and doubling the single quotes it contains. Prefer binding s
+// as an argument where SQL allows it.
+//
+//	QuoteString("it's") == "'it''s'"
+func QuoteString(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
+}
+
+// UpsertReturningID inserts a row with the column values of values into
+// table, unless it conflicts with an existing row on the conflictCols, which
+// must be covered by a unique index or be the primary key. It returns the
+// rowid of the inserted or the existing row, atomically, using a single
+// "INSERT ... ON CONFLICT ... DO UPDATE ... RETURNING rowid" statement.
+//
+// The existing row is left unchanged, but note that the no-op update fires
+// UPDATE triggers and counts as a change.
+func UpsertReturningID(ctx context.Context, q Querier, table string, conflictCols []string, values map[string]interface{}) (id int64, err error) {
+	if len(conflictCols) == 0 {
+		return 0, fmt.Errorf("sqlite: no conflict columns")
+	}
+
+	if len(values) == 0 {
+		return 0, fmt.Errorf("sqlite: no values")
+	}
+
+	cols := make([]string, 0, len(values))
+	for k := range values {
+		cols = append(cols, k)
+	}
+	sort.Strings(cols)
+
+	var names, params []string
+	args := make([]interface{}, 0, len(cols))
+	for _, k := range cols {
+		names = append(names, QuoteIdentifier(k))
+		params = append(params, "?")
+		args = append(args, values[k])
+	}
+
+	var conflict []string
+	for _, k := range conflictCols {
+		conflict = append(conflict, QuoteIdentifier(k))
+	}
+
+	query := fmt.Sprintf(
+		"insert into %s(%s) values(%s) on conflict(%s) do update set %s = %[5]s returning rowid",
+		QuoteIdentifier(table),
+		strings.Join(names, ", "),
+		strings.Join(params, ", "),
+		strings.Join(conflict, ", "),
+		conflict[0],
+	)
+	if err := q.QueryRowContext(ctx, query, args...).Scan(&id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// Conflict resolution strategies of BulkUpsert.
+const (
+	// UpsertUpdate updates the conflicting row with the values of the
+	// columns not in the conflict target.
+	UpsertUpdate = "update"
+	// UpsertNothing leaves the conflicting row unchanged.
+	UpsertNothing = "nothing"
+)
+
+// BulkUpserter accumulates rows to upsert, see BulkUpsert.
+type BulkUpserter struct {
+	query string
+	ncols int
+	rows  [][]interface{}
+}
+
+// BulkUpsert returns a BulkUpserter for the columns of table using an
+// "INSERT ... ON CONFLICT(conflictColumns) DO UPDATE" statement for the
+// UpsertUpdate strategy, or "... DO NOTHING" for UpsertNothing. The conflict
+// columns must be covered by a unique index or be the primary key. Loading the
+// same rows again makes no duplicates, which makes the loads idempotent.
+//
+//	u, err := sqlite.BulkUpsert("t", []string{"id", "name"}, []string{"id"}, sqlite.UpsertUpdate)
+//	...
+//	u.Add(1, "foo")
+//	u.Add(2, "bar")
+//	n, err := u.Flush(ctx, db)
+func BulkUpsert(table string, columns, conflictColumns []string, strategy string) (*BulkUpserter, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("sqlite: no columns")
+	}
+
+	if len(conflictColumns) == 0 {
+		return nil, fmt.Errorf("sqlite: no conflict columns")
+	}
+
+	conflicting := map[string]bool{}
+	var conflict []string
+	for _, v := range conflictColumns {
+		conflicting[v] = true
+		conflict = append(conflict, QuoteIdentifier(v))
+	}
+
+	var names, set []string
+	for _, v := range columns {
+		names = append(names, QuoteIdentifier(v))
+		if !conflicting[v] {
+			set = append(set, fmt.Sprintf("%s = excluded.%[1]s", QuoteIdentifier(v)))
+		}
+	}
+
+	var action string
+	switch strategy {
+	case UpsertUpdate:
+		if len(set) == 0 {
+			return nil, fmt.Errorf("sqlite: no columns to update")
+		}
+
+		action = "update set " + strings.Join(set, ", ")
+	case UpsertNothing:
+		action = "nothing"
+	default:
+		return nil, fmt.Errorf("sqlite: invalid upsert strategy %q", strategy)
+	}
+
+	return &BulkUpserter{
+		query: fmt.Sprintf(
+			"insert into %s(%s) values(%s) on conflict(%s) do %s",
+			QuoteIdentifier(table),
+			strings.Join(names, ", "),
+			strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "),
+			strings.Join(conflict, ", "),
+			action,
+		),
+		ncols: len(columns),
+	}, nil
+}
+
+// Query returns the statement executed for every row.
+func (u *BulkUpserter) Query() string { return u.query }
+
+// Len returns the number of rows added since the last successful Flush.
+func (u *BulkUpserter) Len() int { return len(u.rows) }
+
+// Add adds a row with the values of the columns, in the order passed to
+// BulkUpsert.
+func (u *BulkUpserter) Add(values ...interface{}) error {
+	if len(values) != u.ncols {
+		return fmt.Errorf("sqlite: got %d values, want %d", len(values), u.ncols)
+	}
+
+	u.rows = append(u.rows, append([]interface{}(nil), values...))
+	return nil
+}
+
+// Flush upserts the added rows using a single prepared statement and returns
+// the number of rows inserted or updated. The rows are written in a
+// transaction started by Flush if p is a *sql.DB; otherwise, p is typically a
+// *sql.Tx, as autocommitting every row is slow. The added rows are discarded
+// only if Flush succeeds.
+func (u *BulkUpserter) Flush(ctx context.Context, p interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}) (n int64, err error) {
+	if len(u.rows) == 0 {
+		return 0, nil
+	}
+
+	if db, ok := p.(*sql.DB); ok {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+
+		defer tx.Rollback()
+
+		if n, err = u.exec(ctx, tx); err != nil {
+			return 0, err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return 0, err
+		}
+	} else if n, err = u.exec(ctx, p); err != nil {
+		return 0, err
+	}
+
+	u.rows = u.rows[:0]
+	return n, nil
+}
+
+func (u *BulkUpserter) exec(ctx context.Context, p interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}) (n int64, err error) {
+	stmt, err := p.PrepareContext(ctx, u.query)
+	if err != nil {
+		return 0, err
+	}
+
+	defer stmt.Close()
+
+	for _, v := range u.rows {
+		r, err := stmt.ExecContext(ctx, v...)
+		if err != nil {
+			return 0, err
+		}
+
+		m, err := r.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+
+		n += m
+	}
+	return n, nil
+}
+
+// InsertReturningIDs runs query, an INSERT statement with a RETURNING clause
+// returning a single integer column, typically "RETURNING rowid", and returns
+// the values of all inserted rows in the order SQLite produced them. Unlike
+// LastInsertId, which reports only the rowid of the last row, it covers
+// statements inserting several rows at once.
+func InsertReturningIDs(ctx context.Context, q Querier, query string, args ...interface{}) ([]int64, error) {
+	return queryInt64s(ctx, q, query, args...)
+}
+
+// AffectedRowids runs query, an UPDATE or DELETE statement with a RETURNING
+// clause returning a single integer column, typically "RETURNING rowid", and
+// returns its values for the updated or deleted rows. Result.RowsAffected
+// reports only their number.
+//
+//	ids, err := sqlite.AffectedRowids(ctx, db, "update t set x = x+1 where y = ? returning rowid", y)
+//
+// Tables declared WITHOUT ROWID, and views, have no rowid; return their
+// primary key instead, if it is a single integer column.
+func AffectedRowids(ctx context.Context, q Querier, query string, args ...interface{}) ([]int64, error) {
+	return queryInt64s(ctx, q, query, args...)
+}
+
+// queryInt64s returns the single integer column of the rows produced by
+// query. It fails if query produces several columns, or none, or values other
+// than integers.
+func queryInt64s(ctx context.Context, q Querier, query string, args ...interface{}) (r []int64, err error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(columns) != 1 {
+		return nil, fmt.Errorf("sqlite: statement returns %d columns, want a single integer column, eg. RETURNING rowid", len(columns))
+	}
+
+	for rows.Next() {
+		var v interface{}
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+
+		n, ok := v.(int64)
+		if !ok {
+			return nil, fmt.Errorf("sqlite: statement returned %T, want an integer", v)
+		}
+
+		r = append(r, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return r, rows.Close()
+}
+
+// writeTxMaxBackoff bounds the wait between the attempts of WithWriteTx.
+const writeTxMaxBackoff = 100 * time.Millisecond
+
+// WithWriteTx runs f in a read-write transaction of db started by BEGIN
+// IMMEDIATE, whatever the TxLock of db, and commits it if f returns nil. If
+// beginning the transaction, f or committing fails with SQLITE_BUSY, the
+// transaction is rolled back and the whole sequence is retried after a short,
+// growing, randomized delay, until it succeeds or ctx is done. Other errors
+// roll back the transaction and are returned as is.
+//
+// Since f may run several times, it must not have side effects outside of
+// the transaction.
+func WithWriteTx(ctx context.Context, db *sql.DB, f func(tx *sql.Tx) error) error {
+	backoff := time.Millisecond
+	for {
+		err := writeTx(ctx, db, f)
+		if !isBusy(err) {
+			return err
+		}
+
+		t := time.NewTimer(backoff/2 + time.Duration(rand.Int63n(int64(backoff))))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		if backoff *= 2; backoff > writeTxMaxBackoff {
+			backoff = writeTxMaxBackoff
+		}
+	}
+}
+
+func writeTx(ctx context.Context, db *sql.DB, f func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(context.WithValue(ctx, beginModeKey{}, "immediate"), nil)
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CountingTx is a *sql.Tx recording the number of rows affected by each
+// statement it executes using Exec or ExecContext, eg. to audit the effect of
+// a transaction. Result.RowsAffected of an Exec running several statements
+// separated by semicolons reports the last statement only, and so does
+// CountingTx.
+type CountingTx struct {
+	*sql.Tx
+	affected []int64
+}
+
+// NewCountingTx returns a CountingTx executing statements in tx.
+func NewCountingTx(tx *sql.Tx) *CountingTx {
+	return &CountingTx{Tx: tx}
+}
+
+// Exec executes query like sql.Tx.Exec and records its RowsAffected.
+func (t *CountingTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext executes query like sql.Tx.ExecContext and records its
+// RowsAffected.
+func (t *CountingTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	r, err := t.Tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return r, err
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		return r, err
+	}
+
+	t.affected = append(t.affected, n)
+	return r, nil
+}
+
+// RowsAffected returns the number of rows affected by each successful Exec
+// or ExecContext, in order.
+func (t *CountingTx) RowsAffected() []int64 {
+	return append([]int64(nil), t.affected...)
+}
+
+// TotalRowsAffected returns the sum of RowsAffected.
+func (t *CountingTx) TotalRowsAffected() (n int64) {
+	for _, v := range t.affected {
+		n += v
+	}
+	return n
+}
+
+// isBusy reports whether err is an SQLITE_BUSY error, including its extended
+// result codes.
+func isBusy(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code()&0xff == sqlite3.SQLITE_BUSY
+}
+
+// NextAutoincrement returns the rowid the next row inserted into table, a
+// table declared with INTEGER PRIMARY KEY AUTOINCREMENT, gets when its rowid
+// is not given explicitly: one more than the largest rowid the table ever
+// held, as recorded in sqlite_sequence, or 1 for a new table. Rowids of
+// deleted rows are not reused.
+//
+// The result is read by a single statement, so it is consistent, but another
+// connection may insert rows before the caller does. Pass a *sql.Tx started
+// by BEGIN IMMEDIATE as q, see WithWriteTx, to reserve the value until the
+// transaction ends.
+func NextAutoincrement(ctx context.Context, q Querier, table string) (id int64, err error) {
+	var n int
+	if err := q.QueryRowContext(ctx, "select count(*) from main.sqlite_schema where type = 'table' and name = 'sqlite_sequence'").Scan(&n); err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf("select coalesce(max(rowid), 0) + 1 from main.%s", QuoteIdentifier(table))
+	if n != 0 {
+		query = fmt.Sprintf(
+			"select max(coalesce((select seq from main.sqlite_sequence where name = %s), 0), coalesce((select max(rowid) from main.%s), 0)) + 1",
+			QuoteString(table), QuoteIdentifier(table),
+		)
+	}
+	err = q.QueryRowContext(ctx, query).Scan(&id)
+	return id, err
+}
+
+// QueryColumn runs query, which must return a single column, and scans the
+// values of all its rows into the slice dst points to, eg. a *[]int64 or a
+// *[]string, replacing its contents. The elements are scanned like the
+// arguments of sql.Rows.Scan.
+func QueryColumn(ctx context.Context, q Querier, dst interface{}, query string, args ...interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("sqlite: QueryColumn: destination must be a non-nil pointer to a slice, got %T", dst)
+	}
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	if len(cols) != 1 {
+		return fmt.Errorf("sqlite: QueryColumn: query returns %d columns, expected 1", len(cols))
+	}
+
+	slice := v.Elem()
+	r := reflect.MakeSlice(slice.Type(), 0, 0)
+	for rows.Next() {
+		e := reflect.New(slice.Type().Elem())
+		if err := rows.Scan(e.Interface()); err != nil {
+			return err
+		}
+
+		r = reflect.Append(r, e.Elem())
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	slice.Set(r)
+	return nil
+}
+
+// ScanStruct scans the current row of rows into the fields of the struct dst
+// points to. A column maps to the field tagged with its name, eg. `db:"id"`,
+// or to the field named like the column, and if there is none to a field
+// whose tag or name matches it ignoring case. Fields tagged `db:"-"` and
+// unexported fields are ignored. The fields of embedded structs are mapped as
+// if they belonged to the outer struct, the shallower field winning, and
+// nil embedded struct pointers are allocated. A column without a field is an
+// error, while fields without a column are left as they are.
+//
+// The fields are scanned like the arguments of sql.Rows.Scan, so a column
+// that may be NULL needs a field like sql.NullString or *string.
+func ScanStruct(rows *sql.Rows, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("sqlite: ScanStruct: destination must be a non-nil pointer to a struct, got %T", dst)
+	}
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	paths, err := structFieldPaths(v.Elem().Type(), cols)
+	if err != nil {
+		return err
+	}
+
+	return scanStruct(rows, v.Elem(), paths)
+}
+
+// QueryStruct runs query and scans its rows, using ScanStruct, into dst. If
+// dst points to a struct, it gets the first row and QueryStruct returns
+// sql.ErrNoRows if there is none. If dst points to a slice of structs or of
+// pointers to structs, it gets all the rows, replacing its contents.
+func QueryStruct(ctx context.Context, q Querier, dst interface{}, query string, args ...interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("sqlite: QueryStruct: destination must be a non-nil pointer, got %T", dst)
+	}
+
+	var elem reflect.Type
+	switch v := v.Elem(); v.Kind() {
+	case reflect.Struct:
+		elem = v.Type()
+	case reflect.Slice:
+		elem = v.Type().Elem()
+		if elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+	}
+	if elem == nil || elem.Kind() != reflect.Struct {
+		return fmt.Errorf("sqlite: QueryStruct: destination must point to a struct or a slice of structs, got %T", dst)
+	}
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	paths, err := structFieldPaths(elem, cols)
+	if err != nil {
+		return err
+	}
+
+	if v.Elem().Kind() == reflect.Struct {
+		if !rows.Next() {
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			return sql.ErrNoRows
+		}
+
+		if err := scanStruct(rows, v.Elem(), paths); err != nil {
+			return err
+		}
+
+		return rows.Close()
+	}
+
+	slice := v.Elem()
+	r := reflect.MakeSlice(slice.Type(), 0, 0)
+	for rows.Next() {
+		e := reflect.New(elem)
+		if err := scanStruct(rows, e.Elem(), paths); err != nil {
+			return err
+		}
+
+		if slice.Type().Elem().Kind() == reflect.Ptr {
+			r = reflect.Append(r, e)
+			continue
+		}
+
+		r = reflect.Append(r, e.Elem())
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	slice.Set(r)
+	return nil
+}
+
+// structFieldPaths returns the index paths, as used by
+// reflect.Value.FieldByIndex, of the fields of the struct type t the columns
+// cols map to, see ScanStruct.
+func structFieldPaths(t reflect.Type, cols []string) ([][]int, error) {
+	exact := map[string][]int{}
+	folded := map[string][]int{}
+	// Breadth first, so that the shallower fields are found first.
+	type entry struct {
+		t    reflect.Type
+		path []int
+	}
+	for queue := []entry{{t, nil}}; len(queue) != 0; queue = queue[1:] {
+		e := queue[0]
+		for i := 0; i < e.t.NumField(); i++ {
+			f := e.t.Field(i)
+			tag := f.Tag.Get("db")
+			if tag == "-" {
+				continue
+			}
+
+			path := append(append([]int(nil), e.path...), i)
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if f.Anonymous && tag == "" && ft.Kind() == reflect.Struct {
+				// A nil pointer to an unexported type cannot be
+				// allocated.
+				if f.PkgPath == "" || f.Type.Kind() != reflect.Ptr {
+					queue = append(queue, entry{ft, path})
+				}
+				continue
+			}
+
+			if f.PkgPath != "" {
+				continue
+			}
+
+			name := tag
+			if name == "" {
+				name = f.Name
+			}
+			if _, ok := exact[name]; !ok {
+				exact[name] = path
+			}
+			if k := strings.ToLower(name); folded[k] == nil {
+				folded[k] = path
+			}
+		}
+	}
+
+	r := make([][]int, len(cols))
+	for i, col := range cols {
+		if r[i] = exact[col]; r[i] == nil {
+			r[i] = folded[strings.ToLower(col)]
+		}
+		if r[i] == nil {
+			return nil, fmt.Errorf("sqlite: no field of %s for column %q", t, col)
+		}
+	}
+	return r, nil
+}
+
+// scanStruct scans the current row of rows into the fields of the struct v at
+// paths.
+func scanStruct(rows *sql.Rows, v reflect.Value, paths [][]int) error {
+	ptrs := make([]interface{}, len(paths))
+	for i, path := range paths {
+		f := v
+		for j, x := range path {
+			if j != 0 && f.Kind() == reflect.Ptr {
+				if f.IsNil() {
+					f.Set(reflect.New(f.Type().Elem()))
+				}
+				f = f.Elem()
+			}
+			f = f.Field(x)
+		}
+		ptrs[i] = f.Addr().Interface()
+	}
+	return rows.Scan(ptrs...)
+}
+
+// RowsToJSON reads all rows and writes them to w as a JSON array of objects,
+// one per row, keyed by the column names in the column order. INTEGER and
+// REAL values are written as numbers, TEXT as strings, BLOBs as base64
+// encoded strings, NULL as null and the values of the DATE, DATETIME and
+// TIMESTAMP columns, which scan as time.Time, as RFC 3339 strings. Infinite
+// REAL values cannot be represented and are an error. RowsToJSON closes rows.
+func RowsToJSON(rows *sql.Rows, w io.Writer) error {
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	keys := make([][]byte, len(cols))
+	for i, col := range cols {
+		if keys[i], err = json.Marshal(col); err != nil {
+			return err
+		}
+	}
+
+	values := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('[')
+	for n := 0; rows.Next(); n++ {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+
+		if n != 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteByte('{')
+		for i, v := range values {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("sqlite: RowsToJSON: column %q: %w", cols[i], err)
+			}
+
+			if i != 0 {
+				bw.WriteByte(',')
+			}
+			bw.Write(keys[i])
+			bw.WriteByte(':')
+			bw.Write(b)
+		}
+		bw.WriteByte('}')
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	bw.WriteByte(']')
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	return rows.Close()
+}
+
+// MmapSize returns the effective PRAGMA mmap_size of the connection q runs
+// on, after SQLite applied the compile-time SQLITE_MAX_MMAP_SIZE cap.
+func MmapSize(ctx context.Context, q Querier) (n int64, err error) {
+	err = q.QueryRowContext(ctx, "pragma mmap_size").Scan(&n)
+	return n, err
+}
+
+// CurrentMaxPageCount returns the PRAGMA max_page_count of the main database
+// of the connection q runs on, see MaxPageCount.
+func CurrentMaxPageCount(ctx context.Context, q Querier) (n int64, err error) {
+	err = q.QueryRowContext(ctx, "pragma main.max_page_count").Scan(&n)
+	return n, err
+}
+
+// CurrentWorkerThreads returns the effective PRAGMA threads of the connection
+// q runs on, see WorkerThreads.
+func CurrentWorkerThreads(ctx context.Context, q Querier) (n int, err error) {
+	err = q.QueryRowContext(ctx, "pragma threads").Scan(&n)
+	return n, err
+}
+
+// SetMaxPageCount sets the PRAGMA max_page_count of the main database of the
+// connection c to n pages and returns the effective limit, which SQLite does
+// not set below the current size of the database.
+func SetMaxPageCount(ctx context.Context, c *sql.Conn, n int64) (max int64, err error) {
+	err = c.QueryRowContext(ctx, fmt.Sprintf("pragma main.max_page_count = %d", n)).Scan(&max)
+	return max, err
+}
+
+// JournalMode returns the effective journal mode of the main database of the
+// connection q runs on, in lower case, eg. "wal" or "delete". Setting PRAGMA
+// journal_mode fails silently and keeps the previous mode when the new one
+// cannot be used, eg. WAL for an in-memory database, which uses "memory".
+func JournalMode(ctx context.Context, q Querier) (mode string, err error) {
+	if err = q.QueryRowContext(ctx, "pragma main.journal_mode").Scan(&mode); err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(mode), nil
+}
+
+// IsWAL reports whether the main database of the connection q runs on is in
+// WAL mode, see JournalMode.
+func IsWAL(ctx context.Context, q Querier) (bool, error) {
+	mode, err := JournalMode(ctx, q)
+	return mode == "wal", err
+}
+
+// DatabaseEncoding returns the text encoding of the main database of the
+// connection q runs on: "UTF-8", "UTF-16le" or "UTF-16be".
+func DatabaseEncoding(ctx context.Context, q Querier) (encoding string, err error) {
+	err = q.QueryRowContext(ctx, "pragma main.encoding").Scan(&encoding)
+	return encoding, err
+}
+
+// Instruction is an instruction of the bytecode program SQLite compiles a
+// statement to, as returned by Bytecode. See https://sqlite.org/opcode.html.
+type Instruction struct {
+	Addr    int64
+	Opcode  string
+	P1      int64
+	P2      int64
+	P3      int64
+	P4      string
+	P5      int64
+	Comment string
+}
+
+// Bytecode returns the bytecode program of query, the first statement of it,
+// as listed by EXPLAIN, on the connection q runs on. The bytecode virtual
+// table is not compiled in.
+func Bytecode(ctx context.Context, q Querier, query string) ([]Instruction, error) {
+	rows, err := q.QueryContext(ctx, "explain "+query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var r []Instruction
+	for rows.Next() {
+		var v Instruction
+		var p4, comment sql.NullString
+		if err := rows.Scan(&v.Addr, &v.Opcode, &v.P1, &v.P2, &v.P3, &p4, &v.P5, &comment); err != nil {
+			return nil, err
+		}
+
+		v.P4, v.Comment = p4.String, comment.String
+		r = append(r, v)
+	}
+	return r, rows.Err()
+}
+
+// AutoVacuumMode returns the PRAGMA auto_vacuum of the main database of the
+// connection q runs on: "none", "full" or "incremental".
+func AutoVacuumMode(ctx context.Context, q Querier) (mode string, err error) {
+	var n int
+	if err = q.QueryRowContext(ctx, "pragma main.auto_vacuum").Scan(&n); err != nil {
+		return "", err
+	}
+
+	switch n {
+	case 0:
+		return "none", nil
+	case 1:
+		return "full", nil
+	case 2:
+		return "incremental", nil
+	default:
+		return "", fmt.Errorf("sqlite: unexpected auto_vacuum %d", n)
+	}
+}
+
+// SetAutoVacuum sets the PRAGMA auto_vacuum of the main database of the
+// connection c to mode, one of "none", "full" or "incremental". SQLite
+// changes it from or to "none" only before the first table is created, so
+// SetAutoVacuum then runs VACUUM, which rewrites the whole database.
+func SetAutoVacuum(ctx context.Context, c *sql.Conn, mode string) error {
+	switch mode {
+	case "none", "full", "incremental":
+		// ok
+	default:
+		return fmt.Errorf("sqlite: invalid auto_vacuum mode %q", mode)
+	}
+
+	if _, err := c.ExecContext(ctx, "pragma main.auto_vacuum = "+mode); err != nil {
+		return err
+	}
+
+	got, err := AutoVacuumMode(ctx, c)
+	if err != nil || got == mode {
+		return err
+	}
+
+	_, err = c.ExecContext(ctx, "vacuum main")
+	return err
+}
+
+// FreelistCount returns the number of unused pages of the main database of the
+// connection q runs on.
+func FreelistCount(ctx context.Context, q Querier) (n int64, err error) {
+	err = q.QueryRowContext(ctx, "pragma main.freelist_count").Scan(&n)
+	return n, err
+}
+
+// IncrementalVacuum removes up to pages pages, or all of them if pages <= 0,
+// from the freelist of the main database of the connection q runs on and
+// truncates the file accordingly. It reclaims space without the downtime of a
+// full VACUUM, but does nothing unless the auto_vacuum mode is
+// "incremental", see SetAutoVacuum.
+func IncrementalVacuum(ctx context.Context, q Querier, pages int) error {
+	if pages < 0 {
+		pages = 0
+	}
+
+	// The pragma frees a page per step, which Exec does only once.
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("pragma main.incremental_vacuum(%d)", pages))
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+// AttachMemory attaches a new, empty in-memory database named schema to the
+// connection c, eg. as scratch space for temporary indexes or materialized
+// results that queries can join with the other databases of c. The database
+// lives until it is detached or c is closed. Attached databases are private
+// to a connection, which is why c is not a *sql.DB.
+func AttachMemory(ctx context.Context, c *sql.Conn, schema string) error {
+	_, err := c.ExecContext(ctx, fmt.Sprintf("attach database ':memory:' as %s", QuoteIdentifier(schema)))
+	return err
+}
+
+// SchemaPages reports the size of a database as returned by SchemaPageCounts.
+type SchemaPages struct {
+	Schema        string // "main", "temp" or the name of an attached database
+	PageSize      int64  // page size in bytes
+	PageCount     int64  // number of pages, including free pages
+	FreelistCount int64  // number of unused pages
+}
+
+// SchemaPageCounts returns the page_count and freelist_count of every
+// database open on the connection c, eg. to estimate the size of a backup or
+// decide whether VACUUM is worth it.
+func SchemaPageCounts(ctx context.Context, c *sql.Conn) ([]SchemaPages, error) {
+	names, err := SchemaNames(c)
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]SchemaPages, len(names))
+	for i, name := range names {
+		r[i].Schema = name
+		q := QuoteIdentifier(name)
+		for _, v := range []struct {
+			pragma string
+			dst    *int64
+		}{
+			{"page_size", &r[i].PageSize},
+			{"page_count", &r[i].PageCount},
+			{"freelist_count", &r[i].FreelistCount},
+		} {
+			if err := c.QueryRowContext(ctx, fmt.Sprintf("pragma %s.%s", q, v.pragma)).Scan(v.dst); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return r, nil
+}
+
+// ChangePageSize changes the page size of the main database of the connection
+// c to size bytes, which must be a power of two between 512 and 65536. An
+// existing database keeps its page size until it is rebuilt, so
+// ChangePageSize sets PRAGMA page_size, runs VACUUM and then verifies the new
+// page size is in effect. The page size of a database in WAL mode cannot be
+// changed, switch it to another journal mode first.
+func ChangePageSize(ctx context.Context, c *sql.Conn, size int) error {
+	if size < 512 || size > 65536 || size&(size-1) != 0 {
+		return fmt.Errorf("sqlite: invalid page size %d", size)
+	}
+
+	if _, err := c.ExecContext(ctx, fmt.Sprintf("pragma main.page_size = %d; vacuum main", size)); err != nil {
+		return err
+	}
+
+	var got int
+	if err := c.QueryRowContext(ctx, "pragma main.page_size").Scan(&got); err != nil {
+		return err
+	}
+
+	if got != size {
+		return fmt.Errorf("sqlite: page size is %d after changing it to %d", got, size)
+	}
+
+	return nil
+}
+
+// DatabaseDigest returns a SHA-256 hash of the logical contents of the main
+// database: the schema and the rows of every table, in a canonical order.
+// Unlike a hash of the database file, it does not depend on the page layout,
+// the write-ahead log or VACUUM, so it can be used to detect whether a
+// database changed between two snapshots.
+//
+// Rowids of tables without an INTEGER PRIMARY KEY, which VACUUM may change,
+// and the internal sqlite_ tables are not part of the digest. Virtual tables
+// contribute their schema only, their contents are covered by their shadow
+// tables, if any. Pass a *sql.Tx as q to get a consistent snapshot.
+func DatabaseDigest(ctx context.Context, q Querier) ([]byte, error) {
+	h := sha256.New()
+	var tables []string
+	if err := func() error {
+		rows, err := q.QueryContext(ctx, "select type, name, sql from main.sqlite_schema where name not like 'sqlite\\_%' escape '\\' order by type, name")
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			var typ, name string
+			var ddl *string
+			if err := rows.Scan(&typ, &name, &ddl); err != nil {
+				return err
+			}
+
+			digestValue(h, typ)
+			digestValue(h, name)
+			if ddl != nil {
+				digestValue(h, *ddl)
+			}
+			if typ == "table" && (ddl == nil || !strings.HasPrefix(strings.ToUpper(*ddl), "CREATE VIRTUAL")) {
+				tables = append(tables, name)
+			}
+		}
+		return rows.Err()
+	}(); err != nil {
+		return nil, err
+	}
+
+	for _, table := range tables {
+		if err := digestTable(ctx, h, q, table); err != nil {
+			return nil, err
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+func digestTable(ctx context.Context, h hash.Hash, q Querier, table string) error {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("select * from main.%s", QuoteIdentifier(table)))
+	if err != nil {
+		return err
+	}
+
+	cols, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	// Ordering by all columns makes the order of the rows canonical.
+	order := make([]string, len(cols))
+	for i := range order {
+		order[i] = strconv.Itoa(i + 1)
+	}
+	if rows, err = q.QueryContext(ctx, fmt.Sprintf("select * from main.%s order by %s", QuoteIdentifier(table), strings.Join(order, ", "))); err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	digestValue(h, table)
+	values := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+
+		for _, v := range values {
+			digestValue(h, v)
+		}
+	}
+	return rows.Err()
+}
+
+// digestValue writes v to h prefixed by its type and length, so that
+// different sequences of values never produce the same input.
+func digestValue(h hash.Hash, v interface{}) {
+	var typ byte
+	var b []byte
+	switch x := v.(type) {
+	case nil:
+		typ = 'n'
+	case int64:
+		typ = 'i'
+		b = strconv.AppendInt(nil, x, 10)
+	case float64:
+		typ = 'f'
+		b = strconv.AppendFloat(nil, x, 'g', -1, 64)
+	case string:
+		typ = 't'
+		b = []byte(x)
+	case []byte:
+		typ = 'b'
+		b = x
+	case time.Time:
+		typ = 'd'
+		b = []byte(x.Format(time.RFC3339Nano))
+	default:
+		typ = '?'
+		b = []byte(fmt.Sprint(x))
+	}
+
+	var hdr [9]byte
+	hdr[0] = typ
+	binary.BigEndian.PutUint64(hdr[1:], uint64(len(b)))
+	h.Write(hdr[:])
+	h.Write(b)
+}
+
+// CopyTable copies the rows of table from the main database of src to the
+// main database of dst, creating the table in dst, using its CREATE TABLE
+// statement in src, if it does not exist there. Indexes and triggers are not
+// copied. The rows are inserted in transactions of batchSize rows each, so on
+// error the batches already committed stay in dst.
+//
+// The values are copied with their storage class, eg. a TEXT value of a
+// DATETIME column stays the same text and is not parsed as a time.Time.
+// Generated columns are not copied, dst computes them again, and neither are
+// the rowids of a table without an INTEGER PRIMARY KEY. The source and
+// destination must be different databases.
+func CopyTable(ctx context.Context, dst, src *sql.DB, table string, batchSize int) error {
+	if batchSize <= 0 {
+		return fmt.Errorf("sqlite: invalid batch size %d", batchSize)
+	}
+
+	var ddl string
+	if err := src.QueryRowContext(ctx, "select sql from main.sqlite_schema where type = 'table' and name = ?", table).Scan(&ddl); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("sqlite: no such table: %s", table)
+		}
+
+		return err
+	}
+
+	var exists bool
+	if err := dst.QueryRowContext(ctx, "select count(*) != 0 from main.sqlite_schema where type = 'table' and name = ?", table).Scan(&exists); err != nil {
+		return err
+	}
+
+	if !exists {
+		if _, err := dst.ExecContext(ctx, ddl); err != nil {
+			return err
+		}
+	}
+
+	var cols []string
+	if err := QueryColumn(ctx, src, &cols, "select name from pragma_table_xinfo(?) where hidden = 0 order by cid", table); err != nil {
+		return err
+	}
+
+	// The unary + makes the columns expressions, which have no declared type,
+	// so that the values are not converted according to it.
+	quoted := make([]string, len(cols))
+	exprs := make([]string, len(cols))
+	for i, v := range cols {
+		quoted[i] = QuoteIdentifier(v)
+		exprs[i] = "+" + quoted[i]
+	}
+	rows, err := src.QueryContext(ctx, fmt.Sprintf("select %s from main.%s", strings.Join(exprs, ", "), QuoteIdentifier(table)))
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	insert := fmt.Sprintf("insert into main.%s(%s) values(%s)", QuoteIdentifier(table), strings.Join(quoted, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", "))
+	values := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for more := true; more; {
+		if err := func() error {
+			tx, err := dst.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+
+			defer tx.Rollback()
+
+			stmt, err := tx.PrepareContext(ctx, insert)
+			if err != nil {
+				return err
+			}
+
+			defer stmt.Close()
+
+			for n := 0; n < batchSize; n++ {
+				if more = rows.Next(); !more {
+					if err := rows.Err(); err != nil {
+						return err
+					}
+
+					break
+				}
+
+				if err := rows.Scan(ptrs...); err != nil {
+					return err
+				}
+
+				for i, v := range values {
+					// An empty BLOB is scanned as a nil []byte, which
+					// binds NULL, but NULL is scanned as nil.
+					if b, ok := v.([]byte); ok && b == nil {
+						values[i] = []byte{}
+					}
+				}
+				if _, err := stmt.ExecContext(ctx, values...); err != nil {
+					return err
+				}
+			}
+			return tx.Commit()
+		}(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Index describes an index of a table as returned by Indexes.
+type Index struct {
+	Name    string
+	Unique  bool
+	Partial bool   // The index has a WHERE clause.
+	Origin  string // "c" for CREATE INDEX, "u" for UNIQUE and "pk" for PRIMARY KEY constraints.
+}
+
+// IndexColumn describes a key column of an index as returned by IndexColumns.
+type IndexColumn struct {
+	Name      string // Empty for an expression or the rowid.
+	Cid       int    // Column number in the table, -1 for the rowid and -2 for an expression.
+	Desc      bool   // The column is sorted in descending order.
+	Collation string // Collating sequence as spelled in the schema, eg. "BINARY".
+}
+
+// Indexes returns the indexes of table in the main database of the connection
+// q runs on, as reported by PRAGMA index_list, including the automatic
+// indexes of UNIQUE and PRIMARY KEY constraints.
+func Indexes(ctx context.Context, q Querier, table string) ([]Index, error) {
+	rows, err := q.QueryContext(ctx, "select name, \"unique\", partial, origin from pragma_index_list(?, 'main') order by seq", table)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var r []Index
+	for rows.Next() {
+		var v Index
+		if err := rows.Scan(&v.Name, &v.Unique, &v.Partial, &v.Origin); err != nil {
+			return nil, err
+		}
+
+		r = append(r, v)
+	}
+	return r, rows.Err()
+}
+
+// IndexColumns returns the key columns of index in the main database of the
+// connection q runs on, in index order, as reported by PRAGMA index_xinfo.
+func IndexColumns(ctx context.Context, q Querier, index string) ([]IndexColumn, error) {
+	rows, err := q.QueryContext(ctx, "select name, cid, desc, coll from pragma_index_xinfo(?, 'main') where key order by seqno", index)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var r []IndexColumn
+	for rows.Next() {
+		var v IndexColumn
+		var name sql.NullString
+		if err := rows.Scan(&name, &v.Cid, &v.Desc, &v.Collation); err != nil {
+			return nil, err
+		}
+
+		v.Name = name.String
+		r = append(r, v)
+	}
+	return r, rows.Err()
+}
+
+// Analyze runs ANALYZE on the connection c for each of tables, or for all
+// the databases of c if there is none, gathering the statistics the query
+// planner reads from sqlite_stat1. A positive limit sets PRAGMA
+// analysis_limit for the run, making ANALYZE examine approximately that many
+// rows of each index instead of all of them, while zero leaves the
+// connection's setting unchanged. The previous limit is restored.
+func Analyze(ctx context.Context, c *sql.Conn, limit int, tables ...string) (err error) {
+	if limit < 0 {
+		return fmt.Errorf("sqlite: invalid analysis limit %d", limit)
+	}
+
+	if limit > 0 {
+		var prev int
+		if err := c.QueryRowContext(ctx, "pragma analysis_limit").Scan(&prev); err != nil {
+			return err
+		}
+
+		if _, err := c.ExecContext(ctx, fmt.Sprintf("pragma analysis_limit = %d", limit)); err != nil {
+			return err
+		}
+
+		defer func() {
+			if _, err2 := c.ExecContext(context.Background(), fmt.Sprintf("pragma analysis_limit = %d", prev)); err == nil {
+				err = err2
+			}
+		}()
+	}
+
+	if len(tables) == 0 {
+		_, err = c.ExecContext(ctx, "analyze")
+		return err
+	}
+
+	for _, v := range tables {
+		if _, err = c.ExecContext(ctx, "analyze "+QuoteIdentifier(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stat1 is a row of the sqlite_stat1 table, see Stat1Entries.
+type Stat1 struct {
+	Table string
+	Index string // Empty for the row counting the rows of a table without indexes.
+	Stat  string // Eg. "10000 2 1": the rows of the index, then the average rows per distinct key prefix.
+}
+
+// Stat1Entries returns the rows of the sqlite_stat1 table of the main database
+// of the connection q runs on, written by ANALYZE, ordered by table and index,
+// eg. to diff them in CI. It returns no rows if ANALYZE never ran.
+//
+// See https://www.sqlite.org/fileformat2.html#stat1tab for details.
+func Stat1Entries(ctx context.Context, q Querier) ([]Stat1, error) {
+	var n int
+	if err := q.QueryRowContext(ctx, "select count(*) from main.sqlite_schema where type = 'table' and name = 'sqlite_stat1'").Scan(&n); err != nil || n == 0 {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, "select tbl, coalesce(idx, ''), stat from main.sqlite_stat1 order by tbl, idx")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var r []Stat1
+	for rows.Next() {
+		var v Stat1
+		if err := rows.Scan(&v.Table, &v.Index, &v.Stat); err != nil {
+			return nil, err
+		}
+
+		r = append(r, v)
+	}
+	return r, rows.Err()
+}
+
+// SetDeferForeignKeys sets PRAGMA defer_foreign_keys of the connection q runs
+// on, which should be a *sql.Tx, so that the foreign key constraints are
+// checked at commit instead of after every statement, until the transaction
+// ends, when SQLite turns it off. See also DeferForeignKeys.
+func SetDeferForeignKeys(ctx context.Context, q Querier, on bool) error {
+	_, err := q.ExecContext(ctx, fmt.Sprintf("pragma defer_foreign_keys = %v", on))
+	return err
+}
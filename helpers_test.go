@@ -0,0 +1,1218 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQuote(t *testing.T) {
+	for _, tt := range []struct {
+		s, ident, str string
+	}{
+		{"", `""`, `''`},
+		{"t", `"t"`, `'t'`},
+		{"select", `"select"`, `'select'`},
+		{`my "table"`, `"my ""table"""`, `'my "table"'`},
+		{"it's", `"it's"`, `'it''s'`},
+		{`'"`, `"'"""`, `'''"'`},
+	} {
+		if g, e := QuoteIdentifier(tt.s), tt.ident; g != e {
+			t.Errorf("QuoteIdentifier(%q): got %s, want %s", tt.s, g, e)
+		}
+		if g, e := QuoteString(tt.s), tt.str; g != e {
+			t.Errorf("QuoteString(%q): got %s, want %s", tt.s, g, e)
+		}
+	}
+
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	for _, name := range []string{"select", "order", `x"); drop table y; --`, "it's"} {
+		if _, err := db.Exec(fmt.Sprintf("create table %s(%[1]s); insert into %[1]s values(%s)", QuoteIdentifier(name), QuoteString(name))); err != nil {
+			t.Fatal(err)
+		}
+
+		var s string
+		if err := db.QueryRow(fmt.Sprintf("select %s from %[1]s", QuoteIdentifier(name))).Scan(&s); err != nil {
+			t.Fatal(err)
+		}
+
+		if s != name {
+			t.Fatalf("got %q, want %q", s, name)
+		}
+	}
+}
+
+func TestUpsertReturningID(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`
+	create table "my table"(id integer primary key, "the name" text, kind text, unique("the name", kind));
+	insert into "my table"("the name", kind) values('x', 'y');
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	conflict := []string{"the name", "kind"}
+	id, err := UpsertReturningID(ctx, db, "my table", conflict, map[string]interface{}{"the name": "a", "kind": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := id, int64(2); g != e {
+		t.Fatalf("insert: got id %v, want %v", g, e)
+	}
+
+	id, err = UpsertReturningID(ctx, db, "my table", conflict, map[string]interface{}{"the name": "x", "kind": "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := id, int64(1); g != e {
+		t.Fatalf("conflict: got id %v, want %v", g, e)
+	}
+
+	var n int
+	if err := db.QueryRow(`select count(*) from "my table"`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := n, 2; g != e {
+		t.Fatalf("got %v rows, want %v", g, e)
+	}
+
+	if _, err := UpsertReturningID(ctx, db, "my table", nil, map[string]interface{}{"kind": "b"}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestWithWriteTx(t *testing.T) {
+	const writers, cycles = 8, 50
+
+	// No busy timeout, so that the writers fail with SQLITE_BUSY and are
+	// retried by WithWriteTx.
+	db, err := sql.Open(driverName, filepath.Join(t.TempDir(), "test.db")+"?_pragma=busy_timeout(0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	if _, err := db.Exec("create table t(n); insert into t values(0)"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < cycles; j++ {
+				if err := WithWriteTx(ctx, db, func(tx *sql.Tx) error {
+					var n int
+					if err := tx.QueryRow("select n from t").Scan(&n); err != nil {
+						return err
+					}
+
+					_, err := tx.Exec("update t set n = ?", n+1)
+					return err
+				}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := db.QueryRow("select n from t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := n, writers*cycles; g != e {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	want := errors.New("abort")
+	if err := WithWriteTx(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("update t set n = 0"); err != nil {
+			return err
+		}
+
+		return want
+	}); err != want {
+		t.Fatalf("got %v, want %v", err, want)
+	}
+
+	if err := db.QueryRow("select n from t").Scan(&n); err != nil || n != writers*cycles {
+		t.Fatalf("not rolled back: got %v, %v", n, err)
+	}
+}
+
+func TestNextAutoincrement(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	ctx := context.Background()
+	next := func() int64 {
+		id, err := NextAutoincrement(ctx, db, "my t")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return id
+	}
+
+	if _, err := db.Exec(`create table "my t"(i integer primary key autoincrement, s)`); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := next(), int64(1); g != e {
+		t.Fatalf("new table: got %v, want %v", g, e)
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		want := next()
+		r, err := db.Exec(`insert into "my t"(s) values('x')`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if id, err := r.LastInsertId(); err != nil || id != want {
+			t.Fatalf("got %v, %v, want %v", id, err, want)
+		}
+	}
+
+	// AUTOINCREMENT does not reuse the rowid of deleted rows.
+	if _, err := db.Exec(`delete from "my t"`); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := next(), int64(4); g != e {
+		t.Fatalf("after delete: got %v, want %v", g, e)
+	}
+
+	if _, err := NextAutoincrement(ctx, db, "nosuchtable"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestCountingTx(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("create table t(i); insert into t values(1), (2), (3), (4)"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = WithWriteTx(ctx, db, func(sqlTx *sql.Tx) error {
+		tx := NewCountingTx(sqlTx)
+		for _, query := range []string{
+			"update t set i = i * 10 where i > 2",
+			"delete from t where i = 1",
+			"insert into t values(5), (6), (7)",
+			"update t set i = 0 where i < 0",
+		} {
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Exec("insert into nosuchtable values(1)"); err == nil {
+			return errors.New("expected error")
+		}
+
+		if g, e := tx.RowsAffected(), []int64{2, 1, 3, 0}; !reflect.DeepEqual(g, e) {
+			return fmt.Errorf("got %v, want %v", g, e)
+		}
+
+		if g, e := tx.TotalRowsAffected(), int64(6); g != e {
+			return fmt.Errorf("got %v, want %v", g, e)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryColumn(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("create table t(i integer primary key, s); insert into t(s) values('a'), ('b'), ('c')"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	ids := []int64{42}
+	if err := QueryColumn(ctx, db, &ids, "select i from t where i > ? order by i", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := ids, []int64{2, 3}; !reflect.DeepEqual(g, e) {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	var names []string
+	if err := QueryColumn(ctx, db, &names, "select s from t order by s desc"); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := names, []string{"c", "b", "a"}; !reflect.DeepEqual(g, e) {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	if err := QueryColumn(ctx, db, &names, "select i, s from t"); err == nil || !strings.Contains(err.Error(), "2 columns") {
+		t.Fatalf("got %v, want a column count error", err)
+	}
+
+	if err := QueryColumn(ctx, db, names, "select s from t"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestRowsToJSON(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`
+	create table t(i integer, f real, s text, b blob, d datetime, "odd ""name""");
+	insert into t values(9007199254740993, 1.5, 'a"b', x'00ff', '2023-01-02 03:04:05', null);
+	insert into t values(null, null, null, null, null, 42);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query("select * from t order by rowid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := RowsToJSON(rows, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.String(), `[{"i":9007199254740993,"f":1.5,"s":"a\"b","b":"AP8=","d":"2023-01-02T03:04:05Z","odd \"name\"":null},{"i":null,"f":null,"s":null,"b":null,"d":null,"odd \"name\"":42}]`; g != e {
+		t.Fatalf("\ngot  %s\nwant %s", g, e)
+	}
+
+	if rows, err = db.Query("select 1 where 0"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := RowsToJSON(rows, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := buf.String(), "[]"; g != e {
+		t.Fatalf("got %s, want %s", g, e)
+	}
+
+	if rows, err = db.Query("select 1e999"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RowsToJSON(rows, &buf); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestJournalMode(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name string
+		mode string
+		wal  bool
+	}{
+		{filepath.Join(t.TempDir(), "test.db"), "wal", true},
+		{":memory:", "memory", false},
+	} {
+		db, err := sql.Open(driverName, tt.name+"?_pragma=journal_mode(wal)")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mode, err := JournalMode(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wal, err := IsWAL(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db.Close()
+		if mode != tt.mode || wal != tt.wal {
+			t.Errorf("%s: got %q, %v, want %q, %v", tt.name, mode, wal, tt.mode, tt.wal)
+		}
+	}
+}
+
+func TestAttachMemory(t *testing.T) {
+	db, err := sql.Open(driverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if err := AttachMemory(ctx, c, "scratch space"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.ExecContext(ctx, `
+	create table main.t(i integer primary key, s);
+	insert into main.t(s) values('a'), ('b'), ('c');
+	create table "scratch space".ids(i integer primary key);
+	insert into "scratch space".ids values(1), (3);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	if err := QueryColumn(ctx, c, &got, `select s from main.t join "scratch space".ids using(i) order by i`); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := got, []string{"a", "c"}; !reflect.DeepEqual(g, e) {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	if err := AttachMemory(ctx, c, "scratch space"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestSchemaPageCounts(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open(driverName, filepath.Join(dir, "main.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if _, err := c.ExecContext(ctx, `
+	create table t(b);
+	attach database ? as other;
+	create table other.t(b);
+	insert into other.t with recursive n(i) as (select 1 union all select i+1 from n where i < 10) select randomblob(10000) from n;
+	delete from other.t;
+	`, filepath.Join(dir, "other.db")); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := SchemaNames(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := names, []string{"main", "temp", "other"}; !reflect.DeepEqual(g, e) {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	pages, err := SchemaPageCounts(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("%+v", pages)
+	if g, e := len(pages), 3; g != e {
+		t.Fatalf("got %v schemas, want %v", g, e)
+	}
+
+	if p := pages[0]; p.Schema != "main" || p.PageSize <= 0 || p.PageCount != 2 || p.FreelistCount != 0 {
+		t.Errorf("unexpected main pages %+v", p)
+	}
+
+	if p := pages[2]; p.Schema != "other" || p.FreelistCount == 0 || p.FreelistCount >= p.PageCount {
+		t.Errorf("unexpected other pages %+v", p)
+	}
+}
+
+func TestChangePageSize(t *testing.T) {
+	db, err := sql.Open(driverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if _, err := c.ExecContext(ctx, `
+	pragma page_size = 1024;
+	create table t(i, s);
+	insert into t values(1, 'a'), (2, 'b');
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{0, 256, 1000, 4097, 131072} {
+		if err := ChangePageSize(ctx, c, size); err == nil {
+			t.Fatalf("%v: unexpected success", size)
+		}
+	}
+
+	if err := ChangePageSize(ctx, c, 4096); err != nil {
+		t.Fatal(err)
+	}
+
+	var size, n int
+	if err := c.QueryRowContext(ctx, "select page_size, (select count(*) from t) from pragma_page_size").Scan(&size, &n); err != nil {
+		t.Fatal(err)
+	}
+
+	if size != 4096 || n != 2 {
+		t.Fatalf("got page size %v, %v rows, want 4096, 2", size, n)
+	}
+
+	if _, err := c.ExecContext(ctx, "pragma journal_mode = wal"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ChangePageSize(ctx, c, 8192); err == nil {
+		t.Fatal("unexpected success in WAL mode")
+	}
+}
+
+func TestDatabaseDigest(t *testing.T) {
+	db, err := sql.Open(driverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	if _, err := db.Exec(`
+	create table t(s text, b blob, f real);
+	create index t_s on t(s);
+	insert into t values('a', x'00', 1.5), ('b', null, 2), (null, x'', -1);
+	create table u(i integer primary key, v);
+	insert into u(v) values(1), (2), (3);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	digest := func() []byte {
+		b, err := DatabaseDigest(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return b
+	}
+
+	d0 := digest()
+	if _, err := db.Exec("delete from t where s = 'a'; insert into t values('a', x'00', 1.5); vacuum"); err != nil {
+		t.Fatal(err)
+	}
+
+	if d1 := digest(); !bytes.Equal(d0, d1) {
+		t.Fatalf("digest changed by a no-op: %x, %x", d0, d1)
+	}
+
+	if _, err := db.Exec("update u set v = 4 where i = 3"); err != nil {
+		t.Fatal(err)
+	}
+
+	if d2 := digest(); bytes.Equal(d0, d2) {
+		t.Fatal("digest not changed by a write")
+	}
+}
+
+func TestCopyTable(t *testing.T) {
+	src, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer src.Close()
+
+	dst, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dst.Close()
+
+	src.SetMaxOpenConns(1)
+	dst.SetMaxOpenConns(1)
+	if _, err := src.Exec(`
+	create table t(id integer primary key, i int, f real, s text, b blob, d datetime, v, g as (i * 2));
+	insert into t(i, f, s, b, d, v) values
+		(1, 1.5, 'a', x'00ff', '2023-01-02', 1),
+		(null, null, null, null, null, 1.5),
+		(-3, 2, '', x'', '2023-01-02 03:04:05', 'text'),
+		(4, 0.25, 'b', x'01', 1672531200, x'02'),
+		(5, -1, 'c', null, 'not a date', null);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	dump := func(db *sql.DB) (r []string) {
+		if err := QueryColumn(ctx, db, &r, `
+		select id || '|' || typeof(i) || quote(i) || '|' || typeof(f) || quote(f) || '|' || typeof(s) || quote(s) || '|' || typeof(b) || quote(b) || '|' || typeof(d) || quote(d) || '|' || typeof(v) || quote(v) || '|' || quote(g)
+		from t order by id
+		`); err != nil {
+			t.Fatal(err)
+		}
+
+		return r
+	}
+
+	if err := CopyTable(ctx, dst, src, "t", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	want := dump(src)
+	if got := dump(dst); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if err := CopyTable(ctx, dst, src, "t", 0); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	if err := CopyTable(ctx, dst, src, "nosuchtable", 2); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	// Copying again conflicts on the primary key.
+	if err := CopyTable(ctx, dst, src, "t", 10); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`
+	create table t(a, b text, c, unique(b collate nocase, a desc));
+	create index t_c on t(c) where c is not null;
+	create index t_expr on t(a + c);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	indexes, err := Indexes(ctx, db, "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i].Name < indexes[j].Name })
+	want := []Index{
+		{Name: "sqlite_autoindex_t_1", Unique: true, Origin: "u"},
+		{Name: "t_c", Partial: true, Origin: "c"},
+		{Name: "t_expr", Origin: "c"},
+	}
+	if !reflect.DeepEqual(indexes, want) {
+		t.Fatalf("got %+v, want %+v", indexes, want)
+	}
+
+	for _, tt := range []struct {
+		index string
+		want  []IndexColumn
+	}{
+		{"sqlite_autoindex_t_1", []IndexColumn{
+			{Name: "b", Cid: 1, Collation: "nocase"},
+			{Name: "a", Cid: 0, Desc: true, Collation: "BINARY"},
+		}},
+		{"t_expr", []IndexColumn{{Cid: -2, Collation: "BINARY"}}},
+		{"nosuchindex", nil},
+	} {
+		got, err := IndexColumns(ctx, db, tt.index)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.index, got, tt.want)
+		}
+	}
+}
+
+type scanStructBase struct {
+	ID      int64 `db:"id"`
+	Created time.Time
+}
+
+// ScanStructExtra is exported, a nil embedded pointer to an unexported struct
+// cannot be allocated.
+type ScanStructExtra struct {
+	Note sql.NullString
+	Name string // Shadowed by scanStructRow.Name.
+}
+
+type scanStructRow struct {
+	scanStructBase
+	*ScanStructExtra
+	Extra2 *ScanStructExtra `db:"-"`
+	Name   string           `db:"full_name"`
+	Score  *float64
+	hidden int
+}
+
+func TestScanStruct(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`
+	create table t(id integer primary key, full_name text, SCORE real, note text, created datetime);
+	insert into t values
+		(1, 'a', 1.5, 'x', '2023-01-02 03:04:05'),
+		(2, 'b', null, null, '2023-02-03 04:05:06');
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	var got []scanStructRow
+	if err := QueryStruct(ctx, db, &got, "select * from t order by id"); err != nil {
+		t.Fatal(err)
+	}
+
+	score := 1.5
+	want := []scanStructRow{
+		{
+			scanStructBase:  scanStructBase{1, time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)},
+			ScanStructExtra: &ScanStructExtra{Note: sql.NullString{String: "x", Valid: true}},
+			Name:            "a",
+			Score:           &score,
+		},
+		{
+			scanStructBase:  scanStructBase{2, time.Date(2023, 2, 3, 4, 5, 6, 0, time.UTC)},
+			ScanStructExtra: &ScanStructExtra{},
+			Name:            "b",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	var ptrs []*scanStructRow
+	if err := QueryStruct(ctx, db, &ptrs, "select id, full_name from t order by id"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ptrs) != 2 || ptrs[1].ID != 2 || ptrs[1].Name != "b" {
+		t.Fatalf("unexpected %+v", ptrs)
+	}
+
+	var one scanStructRow
+	if err := QueryStruct(ctx, db, &one, "select id, full_name as FULL_NAME from t where id = 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if one.ID != 2 || one.Name != "b" {
+		t.Fatalf("unexpected %+v", one)
+	}
+
+	if err := QueryStruct(ctx, db, &one, "select id from t where id = 3"); err != sql.ErrNoRows {
+		t.Fatalf("got %v, want sql.ErrNoRows", err)
+	}
+
+	// Unmapped columns, NULL into a string and bad destinations fail.
+	for _, query := range []string{
+		"select id, 42 as answer from t",
+		"select hidden from t",
+		"select null as full_name",
+	} {
+		if err := QueryStruct(ctx, db, &got, query); err == nil {
+			t.Errorf("%s: unexpected success", query)
+		}
+	}
+
+	if err := QueryStruct(ctx, db, got, "select id from t"); err == nil {
+		t.Error("unexpected success")
+	}
+
+	rows, err := db.Query("select id, full_name from t where id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+
+	var row scanStructRow
+	if err := ScanStruct(rows, &row); err != nil {
+		t.Fatal(err)
+	}
+
+	if row.ID != 1 || row.Name != "a" {
+		t.Fatalf("unexpected %+v", row)
+	}
+
+	if err := ScanStruct(rows, row); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	stats, err := Stat1Entries(ctx, c)
+	if err != nil || stats != nil {
+		t.Fatalf("got %v, %v", stats, err)
+	}
+
+	if _, err := c.ExecContext(ctx, `
+	create table t(a, b);
+	create index t_ab on t(a, b);
+	create table u(c);
+	with recursive c(x) as (select 1 union all select x+1 from c where x < 100) insert into t select x % 10, x from c;
+	insert into u values(1), (2);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Analyze(ctx, c, 50, "t"); err != nil {
+		t.Fatal(err)
+	}
+
+	var limit int
+	if err := c.QueryRowContext(ctx, "pragma analysis_limit").Scan(&limit); err != nil || limit != 0 {
+		t.Fatalf("analysis_limit not restored: %v, %v", limit, err)
+	}
+
+	if stats, err = Stat1Entries(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stats) != 1 || stats[0].Table != "t" || stats[0].Index != "t_ab" || !strings.HasPrefix(stats[0].Stat, "100 ") {
+		t.Fatalf("unexpected %+v", stats)
+	}
+
+	if err := Analyze(ctx, c, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats, err = Stat1Entries(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Stat1{{"t", "t_ab", stats[0].Stat}, {"u", "", "2"}}
+	if !reflect.DeepEqual(stats, want) {
+		t.Fatalf("got %+v, want %+v", stats, want)
+	}
+
+	if err := Analyze(ctx, c, 0, "nosuchtable"); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestInsertReturningIDs(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("create table t(i integer primary key, s); insert into t values(10, 'x')"); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := InsertReturningIDs(context.Background(), db, "insert into t(s) values(?), (?), (?) returning rowid", "a", "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := ids, []int64{11, 12, 13}; !reflect.DeepEqual(g, e) {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	var n int
+	if err := db.QueryRow("select count(*) from t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 4 {
+		t.Fatalf("got %v rows, want 4", n)
+	}
+}
+
+func TestAffectedRowids(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`
+	create table t(s);
+	insert into t values('a'), ('b'), ('a'), ('c');
+	create table w(k primary key, v) without rowid;
+	insert into w values('a', 1);
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	ids, err := AffectedRowids(ctx, db, "update t set s = 'x' where s = ? returning rowid;", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := ids, []int64{1, 3}; !reflect.DeepEqual(g, e) {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	// The rowids round-trip as arguments.
+	var s string
+	if err := db.QueryRow("select s from t where rowid = ?", ids[1]).Scan(&s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s != "x" {
+		t.Fatalf("got %q, want x", s)
+	}
+
+	if ids, err = AffectedRowids(ctx, db, "delete from t where s <> 'x' returning rowid -- all but x"); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := ids, []int64{2, 4}; !reflect.DeepEqual(g, e) {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	if ids, err = AffectedRowids(ctx, db, "delete from t where s = 'nothing' returning rowid"); err != nil || len(ids) != 0 {
+		t.Fatalf("got %v, %v", ids, err)
+	}
+
+	if ids, err = AffectedRowids(ctx, db, "update w set v = 2 returning v"); err != nil || !reflect.DeepEqual(ids, []int64{2}) {
+		t.Fatalf("got %v, %v", ids, err)
+	}
+
+	// A statement returning no, several or non integer columns fails
+	// instead of silently returning no ids, eg. when a trailing comment
+	// would have swallowed an appended RETURNING clause.
+	for _, v := range []string{
+		"update t set s = s -- bump",
+		"update t set s = s returning rowid, s",
+		"update t set s = s returning s",
+		"update w set v = 3 returning rowid",
+	} {
+		if ids, err := AffectedRowids(ctx, db, v); err == nil {
+			t.Errorf("%s: got %v, want an error", v, ids)
+		}
+	}
+}
+
+func TestSetDeferForeignKeys(t *testing.T) {
+	db, err := sql.Open(driverName, "file::memory:?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("create table p(id integer primary key); create table c(p references p(id))"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer tx.Rollback()
+
+	if err := SetDeferForeignKeys(ctx, tx, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tx.Exec("insert into c values(1); insert into p values(1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("insert into c values(2)"); err == nil {
+		t.Fatal("foreign keys still deferred after commit")
+	}
+}
+
+func TestBulkUpsert(t *testing.T) {
+	db, err := sql.Open(driverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	if _, err := db.Exec(`create table t(id integer primary key, "na""me" text, n int)`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, strategy := range []string{UpsertUpdate, UpsertNothing} {
+		u, err := BulkUpsert("t", []string{"id", `na"me`, "n"}, []string{"id"}, strategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Running the same load twice must not duplicate the rows.
+		for run := 0; run < 2; run++ {
+			for i := 1; i <= 100; i++ {
+				if err := u.Add(i, fmt.Sprintf("%s%d", strategy, i), run); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			n, err := u.Flush(ctx, db)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := int64(100)
+			if strategy == UpsertNothing {
+				want = 0
+			}
+			if n != want {
+				t.Errorf("%s, run %d: got %v rows affected, want %v", strategy, run, n, want)
+			}
+
+			if u.Len() != 0 {
+				t.Errorf("%s, run %d: got %v pending rows after Flush", strategy, run, u.Len())
+			}
+		}
+
+		var count, sum int
+		var name string
+		if err := db.QueryRow(`select count(*), sum(n), max("na""me") from t`).Scan(&count, &sum, &name); err != nil {
+			t.Fatal(err)
+		}
+
+		if count != 100 || sum != 100 || name != "update99" {
+			t.Errorf("%s: got %v rows, sum %v, max name %q", strategy, count, sum, name)
+		}
+	}
+
+	if _, err := BulkUpsert("t", []string{"id"}, []string{"id"}, UpsertUpdate); err == nil {
+		t.Error("unexpected success with no columns to update")
+	}
+
+	if _, err := BulkUpsert("t", []string{"id", "n"}, []string{"id"}, "replace"); err == nil {
+		t.Error("unexpected success with an invalid strategy")
+	}
+}
+
+func TestIncrementalVacuum(t *testing.T) {
+	db, err := sql.Open(driverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if _, err := c.ExecContext(ctx, "create table t(b); insert into t values(randomblob(100000))"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Too late for the pragma alone, the database already has a table.
+	if err := SetAutoVacuum(ctx, c, "incremental"); err != nil {
+		t.Fatal(err)
+	}
+
+	mode, err := AutoVacuumMode(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mode != "incremental" {
+		t.Fatalf("got %q", mode)
+	}
+
+	if _, err := c.ExecContext(ctx, "delete from t"); err != nil {
+		t.Fatal(err)
+	}
+
+	free, err := FreelistCount(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if free < 20 {
+		t.Fatalf("got %v free pages", free)
+	}
+
+	if err := IncrementalVacuum(ctx, c, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := FreelistCount(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != free-5 {
+		t.Fatalf("got %v free pages, want %v", n, free-5)
+	}
+
+	if err := IncrementalVacuum(ctx, c, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err = FreelistCount(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 0 {
+		t.Fatalf("got %v free pages, want 0", n)
+	}
+
+	if err := SetAutoVacuum(ctx, c, "partial"); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
+
+func TestBytecode(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	program, err := Bytecode(context.Background(), db, "select 42")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ops []string
+	for _, v := range program {
+		ops = append(ops, v.Opcode)
+	}
+	if g := strings.Join(ops, " "); !strings.HasPrefix(g, "Init ") || !strings.Contains(g, "ResultRow") || !strings.Contains(g, "Halt") {
+		t.Fatalf("unexpected program %s", g)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"database/sql"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Limit categories, the ids of the run-time limits passed to Limit and
+// SetLimit. Their meaning is described by the fields of Limits.
+const (
+	LimitLength            = sqlite3.SQLITE_LIMIT_LENGTH
+	LimitSQLLength         = sqlite3.SQLITE_LIMIT_SQL_LENGTH
+	LimitColumn            = sqlite3.SQLITE_LIMIT_COLUMN
+	LimitExprDepth         = sqlite3.SQLITE_LIMIT_EXPR_DEPTH
+	LimitCompoundSelect    = sqlite3.SQLITE_LIMIT_COMPOUND_SELECT
+	LimitVdbeOp            = sqlite3.SQLITE_LIMIT_VDBE_OP
+	LimitFunctionArg       = sqlite3.SQLITE_LIMIT_FUNCTION_ARG
+	LimitAttached          = sqlite3.SQLITE_LIMIT_ATTACHED
+	LimitLikePatternLength = sqlite3.SQLITE_LIMIT_LIKE_PATTERN_LENGTH
+	LimitVariableNumber    = sqlite3.SQLITE_LIMIT_VARIABLE_NUMBER
+	LimitTriggerDepth      = sqlite3.SQLITE_LIMIT_TRIGGER_DEPTH
+	LimitWorkerThreads     = sqlite3.SQLITE_LIMIT_WORKER_THREADS
+)
+
+// Limits holds the run-time limits of a connection. See
+// https://www.sqlite.org/c3ref/c_limit_attached.html for their meaning.
+type Limits struct {
+	Length            int // maximum length of a string or BLOB
+	SQLLength         int // maximum length of an SQL statement, in bytes
+	Column            int // maximum number of columns of a table, index, view or result set
+	ExprDepth         int // maximum depth of the parse tree of an expression
+	CompoundSelect    int // maximum number of terms in a compound SELECT
+	VdbeOp            int // maximum number of instructions of a prepared statement
+	FunctionArg       int // maximum number of arguments of a function
+	Attached          int // maximum number of attached databases
+	LikePatternLength int // maximum length of a LIKE or GLOB pattern
+	VariableNumber    int // maximum index number of any parameter
+	TriggerDepth      int // maximum depth of recursion for triggers
+	WorkerThreads     int // maximum number of auxiliary worker threads of a statement
+}
+
+// int sqlite3_limit(sqlite3*, int id, int newVal);
+//
+// A negative newVal leaves the limit unchanged.
+func (c *conn) limit(id int, newVal int) int {
+	return int(sqlite3.Xsqlite3_limit(c.tls, c.db, int32(id), int32(newVal)))
+}
+
+// Limit sets the run-time limit id, one of the Limit* constants, of the
+// connection to newVal and returns its previous value, eg. to restrict the
+// resources untrusted SQL can use. A negative newVal leaves the limit
+// unchanged, so Limit(id, -1) returns the current value. SQLite silently
+// caps newVal at the compile-time maximum of the limit. Limit returns -1 for
+// an invalid id.
+//
+// Limit is available to users of the driver interfaces, eg. via
+// sql.Conn.Raw, by asserting a driver.Conn to
+// interface{ Limit(id, newVal int) int }; SetLimit is a shortcut for it.
+func (c *conn) Limit(id, newVal int) (old int) {
+	return c.limit(id, newVal)
+}
+
+func (c *conn) limits() Limits {
+	return Limits{
+		Length:            c.limit(sqlite3.SQLITE_LIMIT_LENGTH, -1),
+		SQLLength:         c.limit(sqlite3.SQLITE_LIMIT_SQL_LENGTH, -1),
+		Column:            c.limit(sqlite3.SQLITE_LIMIT_COLUMN, -1),
+		ExprDepth:         c.limit(sqlite3.SQLITE_LIMIT_EXPR_DEPTH, -1),
+		CompoundSelect:    c.limit(sqlite3.SQLITE_LIMIT_COMPOUND_SELECT, -1),
+		VdbeOp:            c.limit(sqlite3.SQLITE_LIMIT_VDBE_OP, -1),
+		FunctionArg:       c.limit(sqlite3.SQLITE_LIMIT_FUNCTION_ARG, -1),
+		Attached:          c.limit(sqlite3.SQLITE_LIMIT_ATTACHED, -1),
+		LikePatternLength: c.limit(sqlite3.SQLITE_LIMIT_LIKE_PATTERN_LENGTH, -1),
+		VariableNumber:    c.limit(sqlite3.SQLITE_LIMIT_VARIABLE_NUMBER, -1),
+		TriggerDepth:      c.limit(sqlite3.SQLITE_LIMIT_TRIGGER_DEPTH, -1),
+		WorkerThreads:     c.limit(sqlite3.SQLITE_LIMIT_WORKER_THREADS, -1),
+	}
+}
+
+// CurrentLimits returns all run-time limits of the connection c.
+func CurrentLimits(c *sql.Conn) (l Limits, err error) {
+	err = withConn(c, func(c *conn) error {
+		l = c.limits()
+		return nil
+	})
+	return l, err
+}
+
+// SetLimit calls Limit on the connection c.
+func SetLimit(c *sql.Conn, id, newVal int) (old int, err error) {
+	err = withConn(c, func(c *conn) error {
+		old = c.Limit(id, newVal)
+		return nil
+	})
+	return old, err
+}
@@ -0,0 +1,115 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestCurrentLimits(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	l, err := CurrentLimits(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("%+v", l)
+	for _, v := range []struct {
+		name string
+		got  int
+		want int
+	}{
+		{"Length", l.Length, 1000000000},
+		{"SQLLength", l.SQLLength, 1000000000},
+		{"Column", l.Column, 2000},
+		{"ExprDepth", l.ExprDepth, 1000},
+		{"CompoundSelect", l.CompoundSelect, 500},
+		{"FunctionArg", l.FunctionArg, 127},
+		{"Attached", l.Attached, 10},
+		{"LikePatternLength", l.LikePatternLength, 50000},
+		{"VariableNumber", l.VariableNumber, 32766},
+		{"TriggerDepth", l.TriggerDepth, 1000},
+	} {
+		if v.got != v.want {
+			t.Errorf("%s: got %v, want %v", v.name, v.got, v.want)
+		}
+	}
+
+	if l.VdbeOp <= 0 || l.WorkerThreads < 0 {
+		t.Errorf("unexpected limits %+v", l)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if err := c.Raw(func(driverConn interface{}) error {
+		dc := driverConn.(interface{ Limit(id, newVal int) int })
+		if g, e := dc.Limit(LimitAttached, 0), 10; g != e {
+			t.Errorf("got %v, want %v", g, e)
+		}
+
+		if g, e := dc.Limit(LimitAttached, -1), 0; g != e {
+			t.Errorf("got %v, want %v", g, e)
+		}
+
+		if g, e := dc.Limit(-42, 1), -1; g != e {
+			t.Errorf("got %v, want %v", g, e)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.ExecContext(ctx, "attach ':memory:' as other"); err == nil {
+		t.Fatal("attach succeeded despite the limit")
+	}
+
+	old, err := SetLimit(c, LimitExprDepth, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if old != 1000 {
+		t.Fatalf("got %v, want 1000", old)
+	}
+
+	var n int
+	if err := c.QueryRowContext(ctx, "select 1+1+1+1+1+1+1+1+1+1+1+1+1+1+1+1+1+1+1+1").Scan(&n); err == nil {
+		t.Fatal("query succeeded despite the limit")
+	}
+
+	if err := c.QueryRowContext(ctx, "select 1+1").Scan(&n); err != nil || n != 2 {
+		t.Fatalf("got %v, %v", n, err)
+	}
+}
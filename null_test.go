@@ -1,7 +1,9 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"testing"
 )
 
@@ -24,3 +26,69 @@ func TestNullBinding(t *testing.T) {
 		return
 	}
 }
+
+func TestNullBindingDriver(t *testing.T) {
+	db, err := sql.Open("sqlite", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	// typeOf binds v through the driver interface directly, bypassing the
+	// conversion done by database/sql.
+	typeOf := func(v interface{}) (typ driver.Value, err error) {
+		err = c.Raw(func(driverConn interface{}) error {
+			rows, err := driverConn.(driver.QueryerContext).QueryContext(
+				context.Background(),
+				"select typeof(?)",
+				[]driver.NamedValue{{Ordinal: 1, Value: v}},
+			)
+			if err != nil {
+				return err
+			}
+
+			defer rows.Close()
+
+			dest := make([]driver.Value, 1)
+			if err := rows.Next(dest); err != nil {
+				return err
+			}
+
+			typ = dest[0]
+			return nil
+		})
+		return typ, err
+	}
+
+	var ns *sql.NullString
+	for _, tt := range []struct {
+		v    interface{}
+		want string
+	}{
+		{sql.NullString{}, "null"},
+		{sql.NullInt64{}, "null"},
+		{sql.NullFloat64{}, "null"},
+		{sql.NullBool{}, "null"},
+		{sql.NullTime{}, "null"},
+		{ns, "null"},
+		{sql.NullString{String: "foo", Valid: true}, "text"},
+		{sql.NullInt64{Int64: 42, Valid: true}, "integer"},
+	} {
+		got, err := typeOf(tt.v)
+		if err != nil {
+			t.Fatalf("%#v: %v", tt.v, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%#v: got %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
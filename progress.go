@@ -0,0 +1,109 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// progressState is the state of the progress handler of a connection, which
+// serves both SetProgressHandler and ProgressCancel.
+type progressState struct {
+	cancelOps int         // see ProgressCancel
+	fn        func() bool // see SetProgressHandler
+	fnOps     int         // the n of SetProgressHandler
+
+	ctx  context.Context // of the running Exec or Query, see cancelOnDone
+	done *int32          // set when ctx interrupts a statement
+
+	h uintptr // handle of the connection passed to progressHandler
+}
+
+// cancelOnDone arranges for the statements executed by c to be interrupted
+// when ctx is done, by the progress handler if the ProgressCancel option is
+// set, or else by interruptOnDone, and returns a function the caller must
+// defer. done is set when a statement is interrupted.
+func (c *conn) cancelOnDone(ctx context.Context, done *int32) func() {
+	if c.progress.cancelOps == 0 {
+		return interruptOnDone(ctx, c, done)
+	}
+
+	if done == nil {
+		done = new(int32)
+	}
+
+	prevCtx, prevDone := c.progress.ctx, c.progress.done
+	c.progress.ctx, c.progress.done = ctx, done
+	return func() {
+		c.progress.ctx, c.progress.done = prevCtx, prevDone
+	}
+}
+
+// updateProgressHandler installs, or removes, the progress handler of c as
+// required by its progressState.
+//
+// void sqlite3_progress_handler(sqlite3*, int, int(*)(void*), void*);
+func (c *conn) updateProgressHandler() {
+	n := c.progress.cancelOps
+	if c.progress.fn != nil && (n == 0 || c.progress.fnOps < n) {
+		n = c.progress.fnOps
+	}
+
+	if n == 0 {
+		sqlite3.Xsqlite3_progress_handler(c.tls, c.db, 0, 0, 0)
+		return
+	}
+
+	if c.progress.h == 0 {
+		c.progress.h = newHandle(c)
+	}
+
+	sqlite3.Xsqlite3_progress_handler(c.tls, c.db, int32(n), *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr) int32
+	}{progressHandler})), c.progress.h)
+}
+
+// progressHandler is the progress handler callback installed by
+// updateProgressHandler. A non zero result interrupts the running statement.
+func progressHandler(tls *libc.TLS, h uintptr) int32 {
+	c := handleValue(h).(*conn)
+	if ctx := c.progress.ctx; ctx != nil && ctx.Err() != nil {
+		atomic.StoreInt32(c.progress.done, 1)
+		return 1
+	}
+
+	if c.progress.fn != nil && c.progress.fn() {
+		return 1
+	}
+
+	return 0
+}
+
+// SetProgressHandler makes the connection c call fn periodically, about every
+// n virtual machine instructions, while it executes statements, eg. to update
+// a progress indicator or to abort long-running statements: if fn returns
+// true, the running statement fails with an *Error with code
+// SQLITE_INTERRUPT. A nil fn, or n < 1, removes the handler. The handler
+// replaces the previous one. fn must not use c.
+//
+// If the ProgressCancel option is set to a smaller number of instructions, fn
+// is called that often instead.
+func SetProgressHandler(c *sql.Conn, n int, fn func() bool) error {
+	return withConn(c, func(c *conn) error {
+		if fn == nil || n < 1 {
+			fn, n = nil, 0
+		}
+
+		c.progress.fn, c.progress.fnOps = fn, n
+		c.updateProgressHandler()
+		return nil
+	})
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// longQuery is a query running for a long time.
+const longQuery = "with recursive c(i) as (select 1 union all select i+1 from c where i < 100000000) select count(*) from c"
+
+func TestSetProgressHandler(t *testing.T) {
+	db, err := sql.Open(driverName, "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	var calls int
+	if err := SetProgressHandler(c, 1000, func() bool {
+		calls++
+		return calls == 10
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	err = c.QueryRowContext(ctx, longQuery).Scan(&n)
+	if e, ok := err.(*Error); !ok || e.Code() != sqlite3.SQLITE_INTERRUPT {
+		t.Fatalf("got %v, want SQLITE_INTERRUPT", err)
+	}
+
+	if calls != 10 {
+		t.Fatalf("got %v calls, want 10", calls)
+	}
+
+	if err := SetProgressHandler(c, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.QueryRowContext(ctx, "with recursive c(i) as (select 1 union all select i+1 from c where i < 10000) select count(*) from c").Scan(&n); err != nil || n != 10000 {
+		t.Fatalf("got %v, %v", n, err)
+	}
+
+	if calls != 10 {
+		t.Fatal("handler called after removal")
+	}
+}
+
+func TestProgressCancel(t *testing.T) {
+	if _, err := NewConnector("file::memory:", ProgressCancel(0)); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	cn, err := NewConnector("file::memory:", ProgressCancel(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(cn)
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	t0 := time.Now()
+	_, err = db.ExecContext(ctx, longQuery)
+	if e, ok := err.(*Error); !ok || e.Code() != sqlite3.SQLITE_INTERRUPT {
+		t.Fatalf("got %v, want SQLITE_INTERRUPT", err)
+	}
+
+	if d := time.Since(t0); d > 10*time.Second {
+		t.Fatalf("cancellation took %v", d)
+	}
+
+	// The connection stays usable, and a user handler coexists with the
+	// cancellation.
+	c, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	var calls int
+	if err := SetProgressHandler(c, 1000, func() bool {
+		calls++
+		return false
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := c.QueryRowContext(context.Background(), "with recursive c(i) as (select 1 union all select i+1 from c where i < 10000) select count(*) from c").Scan(&n); err != nil || n != 10000 {
+		t.Fatalf("got %v, %v", n, err)
+	}
+
+	if calls == 0 {
+		t.Fatal("handler not called")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.ExecContext(ctx, longQuery); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Registry is a set of user defined functions and collations. Unlike the
+// functions registered on the driver by RegisterScalarFunction and friends,
+// which are added to every new connection, the contents of a Registry are
+// added only to the connections of the connectors configured with
+// UseRegistry, so different connectors, eg. of different tests, do not
+// interfere. A function of a Registry replaces a function of the driver with
+// the same name and number of arguments.
+//
+// Changes to a Registry apply to the connections opened after them. The zero
+// value is not usable, use NewRegistry.
+type Registry struct {
+	sync.Mutex
+	udfs       map[functionKey]*userDefinedFunction
+	collations map[string]*collation
+}
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		udfs:       map[functionKey]*userDefinedFunction{},
+		collations: map[string]*collation{},
+	}
+}
+
+// RegisterScalarFunction is like the package level RegisterScalarFunction
+// but adds the function to r.
+func (r *Registry) RegisterScalarFunction(
+	zFuncName string,
+	nArg int32,
+	xFunc func(ctx *FunctionContext, args []driver.Value) (driver.Value, error),
+) error {
+	return r.registerScalarFunction(zFuncName, nArg, sqlite3.SQLITE_UTF8, xFunc)
+}
+
+// RegisterDeterministicScalarFunction is like the package level
+// RegisterDeterministicScalarFunction but adds the function to r.
+func (r *Registry) RegisterDeterministicScalarFunction(
+	zFuncName string,
+	nArg int32,
+	xFunc func(ctx *FunctionContext, args []driver.Value) (driver.Value, error),
+) error {
+	return r.registerScalarFunction(zFuncName, nArg, sqlite3.SQLITE_UTF8|sqlite3.SQLITE_DETERMINISTIC, xFunc)
+}
+
+func (r *Registry) registerScalarFunction(
+	zFuncName string,
+	nArg int32,
+	eTextRep int32,
+	xFunc func(ctx *FunctionContext, args []driver.Value) (driver.Value, error),
+) error {
+	r.Lock()
+	defer r.Unlock()
+
+	k := functionKey{zFuncName, nArg}
+	if _, ok := r.udfs[k]; ok {
+		return errFunctionRegistered(k)
+	}
+
+	// dont free, the connections may create the function as long as r is
+	// in use
+	name, err := libc.CString(zFuncName)
+	if err != nil {
+		return err
+	}
+
+	r.udfs[k] = &userDefinedFunction{
+		zFuncName: name,
+		nArg:      nArg,
+		eTextRep:  eTextRep,
+		xFunc: func(tls *libc.TLS, ctx uintptr, argc int32, argv uintptr) {
+			callFunction(tls, ctx, argc, argv, xFunc)
+		},
+	}
+	return nil
+}
+
+// RegisterAggregateFunction is like the package level
+// RegisterAggregateFunction but adds the function to r.
+func (r *Registry) RegisterAggregateFunction(zFuncName string, nArg int32, makeAggregate func() Aggregator) error {
+	r.Lock()
+	defer r.Unlock()
+
+	k := functionKey{zFuncName, nArg}
+	if _, ok := r.udfs[k]; ok {
+		return errFunctionRegistered(k)
+	}
+
+	// dont free, see registerScalarFunction
+	name, err := libc.CString(zFuncName)
+	if err != nil {
+		return err
+	}
+
+	r.udfs[k] = newAggregateFunction(name, nArg, makeAggregate)
+	return nil
+}
+
+// RegisterWindowFunction is like the package level RegisterWindowFunction but
+// adds the function to r.
+func (r *Registry) RegisterWindowFunction(zFuncName string, nArg int32, makeWindow func() WindowFunction) error {
+	r.Lock()
+	defer r.Unlock()
+
+	k := functionKey{zFuncName, nArg}
+	if _, ok := r.udfs[k]; ok {
+		return errFunctionRegistered(k)
+	}
+
+	// dont free, see registerScalarFunction
+	name, err := libc.CString(zFuncName)
+	if err != nil {
+		return err
+	}
+
+	r.udfs[k] = newWindowFunction(name, nArg, makeWindow)
+	return nil
+}
+
+// RegisterCollation adds to r a collating sequence named zName, usable in
+// COLLATE clauses and column definitions. The compare function must return a
+// negative number, zero or a positive number when a sorts before, equal to or
+// after b, consistently for the same inputs.
+func (r *Registry) RegisterCollation(zName string, compare func(a, b string) int) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.collations[zName]; ok {
+		return fmt.Errorf("sqlite: a collation named %q is already registered", zName)
+	}
+
+	// dont free, see registerScalarFunction
+	name, err := libc.CString(zName)
+	if err != nil {
+		return err
+	}
+
+	r.collations[zName] = &collation{zName: name, compare: compare}
+	return nil
+}
+
+// install adds the contents of r to c.
+func (r *Registry) install(c *conn) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, udf := range r.udfs {
+		if err := c.createFunctionInternal(udf); err != nil {
+			return err
+		}
+	}
+
+	for _, coll := range r.collations {
+		if err := c.createCollation(coll); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UseRegistry adds the functions and collations of r to the connections.
+func UseRegistry(r *Registry) ConnectorOption {
+	return func(cn *connector) error {
+		if r == nil {
+			return fmt.Errorf("sqlite: nil registry")
+		}
+
+		cn.registry = r
+		return nil
+	}
+}
+
+type collation struct {
+	zName   uintptr
+	compare func(a, b string) int
+}
+
+// int sqlite3_create_collation_v2(
+//
+//	sqlite3*,
+//	const char *zName,
+//	int eTextRep,
+//	void *pArg,
+//	int(*xCompare)(void*,int,const void*,int,const void*),
+//	void(*xDestroy)(void*)
+//
+// );
+//
+// Unlike the other interfaces of SQLite, sqlite3_create_collation_v2 does not
+// call xDestroy when it fails.
+func (c *conn) createCollation(coll *collation) error {
+	h := newHandle(coll)
+	if rc := sqlite3.Xsqlite3_create_collation_v2(
+		c.tls,
+		c.db,
+		coll.zName,
+		sqlite3.SQLITE_UTF8,
+		h,
+		*(*uintptr)(unsafe.Pointer(&struct {
+			f func(*libc.TLS, uintptr, int32, uintptr, int32, uintptr) int32
+		}{compareCollation})),
+		*(*uintptr)(unsafe.Pointer(&struct {
+			f func(*libc.TLS, uintptr)
+		}{destroyCollation})),
+	); rc != sqlite3.SQLITE_OK {
+		deleteHandle(h)
+		return c.errstr(rc)
+	}
+
+	return nil
+}
+
+// compareCollation is the xCompare callback of the collations created by
+// createCollation.
+func compareCollation(tls *libc.TLS, h uintptr, n1 int32, p1 uintptr, n2 int32, p2 uintptr) int32 {
+	coll := handleValue(h).(*collation)
+	switch r := coll.compare(string(libc.GoBytes(p1, int(n1))), string(libc.GoBytes(p2, int(n2)))); {
+	case r < 0:
+		return -1
+	case r > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// destroyCollation is the xDestroy callback of the collations created by
+// createCollation.
+func destroyCollation(tls *libc.TLS, h uintptr) {
+	deleteHandle(h)
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	open := func(opts ...ConnectorOption) *sql.DB {
+		cn, err := NewConnector(":memory:", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db := sql.OpenDB(cn)
+		db.SetMaxOpenConns(1)
+		if _, err := db.Exec("create table t(s); insert into t values('a'), ('B'), ('c')"); err != nil {
+			t.Fatal(err)
+		}
+
+		return db
+	}
+
+	newRegistry := func(v int64, compare func(a, b string) int) *Registry {
+		r := NewRegistry()
+		if err := r.RegisterDeterministicScalarFunction("registry_f", 0, func(*FunctionContext, []driver.Value) (driver.Value, error) {
+			return v, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.RegisterCollation("registry_c", compare); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.RegisterCollation("registry_c", compare); err == nil {
+			t.Fatal("unexpected success")
+		}
+
+		return r
+	}
+
+	db1 := open(UseRegistry(newRegistry(1, strings.Compare)))
+	defer db1.Close()
+
+	db2 := open(UseRegistry(newRegistry(2, func(a, b string) int { return strings.Compare(strings.ToLower(b), strings.ToLower(a)) })))
+	defer db2.Close()
+
+	db3 := open()
+	defer db3.Close()
+
+	ctx := context.Background()
+	for _, tt := range []struct {
+		db   *sql.DB
+		v    int64
+		want []string
+	}{
+		{db1, 1, []string{"B", "a", "c"}},
+		{db2, 2, []string{"c", "B", "a"}},
+	} {
+		var v int64
+		if err := tt.db.QueryRow("select registry_f()").Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+
+		if v != tt.v {
+			t.Errorf("got %v, want %v", v, tt.v)
+		}
+
+		var got []string
+		if err := QueryColumn(ctx, tt.db, &got, "select s from t order by s collate registry_c"); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("got %v, want %v", got, tt.want)
+		}
+	}
+
+	if _, err := db3.Exec("select registry_f()"); err == nil || !strings.Contains(err.Error(), "no such function") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err := db3.Exec("select s from t order by s collate registry_c"); err == nil || !strings.Contains(err.Error(), "no such collation") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err := NewConnector(":memory:", UseRegistry(nil)); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
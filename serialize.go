@@ -0,0 +1,140 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"database/sql"
+	"fmt"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Flags of Deserialize.
+const (
+	DeserializeReadOnly   uint = sqlite3.SQLITE_DESERIALIZE_READONLY   // the database cannot be written
+	DeserializeResizeable uint = sqlite3.SQLITE_DESERIALIZE_RESIZEABLE // writes can grow the database
+)
+
+// Serialize returns the content of the database schema, ie. "main", "temp"
+// or the name of an attached database, of the connection, as it would be
+// stored on disk. The result is a copy owned by the caller. It works for any
+// database, not only an in-memory one, and can be loaded by Deserialize, eg.
+// on another connection.
+//
+// Serialize is available to users of the driver interfaces, eg. via
+// sql.Conn.Raw, by asserting a driver.Conn to
+// interface{ Serialize(schema string) ([]byte, error) }; the function
+// Serialize is a shortcut for it.
+//
+// unsigned char *sqlite3_serialize(
+//
+//	sqlite3 *db,           /* The database connection */
+//	const char *zSchema,   /* Which DB to serialize. ex: "main", "temp", ... */
+//	sqlite3_int64 *piSize, /* Write size of the DB here, if not NULL */
+//	unsigned int mFlags    /* Zero or more SQLITE_SERIALIZE_* flags */
+//
+// );
+func (c *conn) Serialize(schema string) ([]byte, error) {
+	zSchema, err := libc.CString(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.free(zSchema)
+
+	piSize, err := c.malloc(8)
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.free(piSize)
+
+	p := sqlite3.Xsqlite3_serialize(c.tls, c.db, zSchema, piSize, 0)
+	if p == 0 {
+		return nil, fmt.Errorf("sqlite: cannot serialize database %q", schema)
+	}
+
+	defer sqlite3.Xsqlite3_free(c.tls, p)
+
+	n := *(*int64)(unsafe.Pointer(piSize))
+	b := make([]byte, n)
+	copy(b, (*libc.RawMem)(unsafe.Pointer(p))[:n:n])
+	return b, nil
+}
+
+// Deserialize replaces the database schema, ie. "main" or the name of an
+// attached database, of the connection with an in-memory database holding a
+// copy of data, as returned by Serialize, without touching the file system.
+// The original database, if any, is detached, not modified. flags is zero or
+// a combination of DeserializeReadOnly and DeserializeResizeable; without the
+// latter, writes cannot make the database larger than data. SQLite owns the
+// copy and frees it when the database is closed, so data can be reused once
+// Deserialize returns.
+//
+// Deserialize fails if the database is in use, eg. by an open transaction or
+// a pending query. It affects only this connection, so when using a sql.DB,
+// use a single sql.Conn, or set MaxOpenConns to 1.
+//
+// Deserialize is available to users of the driver interfaces, eg. via
+// sql.Conn.Raw, by asserting a driver.Conn to
+// interface{ Deserialize(schema string, data []byte, flags uint) error }; the
+// function Deserialize is a shortcut for it.
+//
+// int sqlite3_deserialize(
+//
+//	sqlite3 *db,            /* The database connection */
+//	const char *zSchema,    /* Which DB to reopen with the deserialization */
+//	unsigned char *pData,   /* The serialized database content */
+//	sqlite3_int64 szDb,     /* Number bytes in the deserialization */
+//	sqlite3_int64 szBuf,    /* Total size of buffer pData[] */
+//	unsigned mFlags         /* Zero or more SQLITE_DESERIALIZE_* flags */
+//
+// );
+func (c *conn) Deserialize(schema string, data []byte, flags uint) error {
+	if flags&^(DeserializeReadOnly|DeserializeResizeable) != 0 {
+		return fmt.Errorf("sqlite: invalid deserialize flags %#x", flags)
+	}
+
+	zSchema, err := libc.CString(schema)
+	if err != nil {
+		return err
+	}
+
+	defer c.free(zSchema)
+
+	// The buffer must come from sqlite3_malloc64 for SQLite to free it, or
+	// to resize it, and it must not be Go memory, which the GC could move
+	// or collect.
+	n := len(data)
+	p := sqlite3.Xsqlite3_malloc64(c.tls, uint64(n+1))
+	if p == 0 {
+		return fmt.Errorf("sqlite: cannot allocate %d bytes", n)
+	}
+
+	copy((*libc.RawMem)(unsafe.Pointer(p))[:n:n], data)
+
+	// With SQLITE_DESERIALIZE_FREEONCLOSE, SQLite frees p even on failure.
+	if rc := sqlite3.Xsqlite3_deserialize(c.tls, c.db, zSchema, p, int64(n), int64(n+1), uint32(flags|sqlite3.SQLITE_DESERIALIZE_FREEONCLOSE)); rc != sqlite3.SQLITE_OK {
+		return c.errstr(rc)
+	}
+
+	return nil
+}
+
+// Serialize calls Serialize on the connection c.
+func Serialize(c *sql.Conn, schema string) (data []byte, err error) {
+	err = withConn(c, func(c *conn) error {
+		data, err = c.Serialize(schema)
+		return err
+	})
+	return data, err
+}
+
+// Deserialize calls Deserialize on the connection c.
+func Deserialize(c *sql.Conn, schema string, data []byte, flags uint) error {
+	return withConn(c, func(c *conn) error { return c.Deserialize(schema, data, flags) })
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSerialize(t *testing.T) {
+	ctx := context.Background()
+	src, err := sql.Open(driverName, filepath.Join(t.TempDir(), "src.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer src.Close()
+
+	if _, err := src.ExecContext(ctx, `
+		create table t(i integer, s text);
+		insert into t values(1, 'foo'), (2, 'bar');
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := src.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sc.Close()
+
+	data, err := Serialize(sc, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) == 0 || string(data[:15]) != "SQLite format 3" {
+		t.Fatalf("unexpected serialization of %d bytes", len(data))
+	}
+
+	if _, err := Serialize(sc, "nosuch"); err == nil {
+		t.Fatal("serialized a missing database")
+	}
+
+	dst, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dst.Close()
+
+	dc, err := dst.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dc.Close()
+
+	if err := dc.Raw(func(driverConn interface{}) error {
+		return driverConn.(interface {
+			Deserialize(schema string, data []byte, flags uint) error
+		}).Deserialize("main", data, DeserializeResizeable)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// SQLite owns a copy.
+	for i := range data {
+		data[i] = 0
+	}
+	runtime.GC()
+
+	if _, err := dc.ExecContext(ctx, "insert into t select i+2, s||s from t"); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	var s string
+	if err := dc.QueryRowContext(ctx, "select count(*), group_concat(s) from t").Scan(&n, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := n, 4; g != e {
+		t.Errorf("got %v, want %v", g, e)
+	}
+
+	if g, e := s, "foo,bar,foofoo,barbar"; g != e {
+		t.Errorf("got %q, want %q", g, e)
+	}
+
+	// Round trip through the in-memory database.
+	data, err = Serialize(dc, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Deserialize(dc, "main", data, DeserializeReadOnly); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dc.ExecContext(ctx, "insert into t values(5, 'baz')"); err == nil {
+		t.Fatal("wrote to a read-only database")
+	}
+
+	if err := dc.QueryRowContext(ctx, "select count(*) from t").Scan(&n); err != nil || n != 4 {
+		t.Fatalf("got %v, %v", n, err)
+	}
+
+	if err := Deserialize(dc, "nosuch", data, 0); err == nil {
+		t.Fatal("deserialized into a missing database")
+	}
+
+	if err := Deserialize(dc, "main", data, 1); err == nil {
+		t.Fatal("accepted invalid flags")
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"encoding"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -31,6 +33,12 @@ import (
 var (
 	_ driver.Conn   = (*conn)(nil)
 	_ driver.Driver = (*Driver)(nil)
+
+	_ driver.Connector     = (*connector)(nil)
+	_ driver.DriverContext = (*Driver)(nil)
+
+	_ driver.NamedValueChecker = (*conn)(nil)
+	_ driver.SessionResetter   = (*conn)(nil)
 	//lint:ignore SA1019 TODO implement ExecerContext
 	_ driver.Execer = (*conn)(nil)
 	//lint:ignore SA1019 TODO implement QueryerContext
@@ -68,6 +76,19 @@ func (e *Error) Error() string { return e.msg }
 // Code returns the sqlite result code for this error.
 func (e *Error) Code() int { return e.code }
 
+// Is reports whether target is an *Error with a primary result code, like
+// ErrFull, equal to the primary result code of e, so that errors.Is(err,
+// ErrFull) matches also the extended result codes of SQLITE_FULL.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.code == t.code&0xff && e.code&0xff == t.code
+}
+
+// ErrFull is matched by errors.Is for the errors reporting that a write
+// failed because the database is full, SQLITE_FULL, eg. because it reached
+// its max_page_count or the disk is full.
+var ErrFull error = &Error{msg: "database or disk is full (SQLITE_FULL)", code: sqlite3.SQLITE_FULL}
+
 var (
 	// ErrorCodeString maps Error.Code() to its string representation.
 	ErrorCodeString = map[int]string{
@@ -168,10 +189,16 @@ type rows struct {
 	c       *conn     // connection
 	columns []string  // column names
 	pstmt   uintptr   // correspodning prepared statement
+	err     error     // error of the last step, if any
+	stmt    *stmt     // statement that prepared pstmt
+
+	statementID int64       // see ActiveStatements
+	audit       func(error) // reports the closing of the rows, see Audit
 }
 
-func newRows(c *conn, pstmt uintptr, allocs []uintptr) (r *rows, err error) {
-	r = &rows{c: c, pstmt: pstmt, allocs: allocs}
+func newRows(s *stmt, pstmt uintptr, allocs []uintptr) (r *rows, err error) {
+	c := s.c
+	r = &rows{c: c, pstmt: pstmt, allocs: allocs, stmt: s}
 
 	// deferred close if anything goes wrong
 	defer func() {
@@ -200,14 +227,65 @@ func newRows(c *conn, pstmt uintptr, allocs []uintptr) (r *rows, err error) {
 
 // Close closes the rows iterator.
 func (r *rows) Close() (err error) {
+	endStatement(r.statementID)
+	if r.audit != nil {
+		defer func(audit func(error)) { audit(err) }(r.audit)
+		r.audit = nil
+	}
+
 	// free all allocations made for this rows
 	for _, v := range r.allocs {
 		r.c.free(v)
 	}
 	r.allocs = nil
 
+	if r.err == nil {
+		r.c.checkSchemaChange(r.pstmt)
+	}
+
 	// finalize prepared statement
-	return r.c.finalize(r.pstmt)
+	err = r.stmt.finalize(r.pstmt)
+	r.pstmt = 0
+
+	// sqlite3_finalize reports only the code of a failed step, prefer the
+	// error with the full message
+	if r.err != nil {
+		return r.err
+	}
+
+	return err
+}
+
+// Busy reports whether the prepared statement of r is in the middle of
+// returning rows: Next returned at least one row, but neither reached the end
+// of the result nor failed, and r is not closed. A statement that is not busy
+// holds no read transaction open. Busy is available to users of the driver
+// interfaces, eg. via sql.Conn.Raw, by asserting a driver.Rows to
+// interface{ Busy() bool }.
+func (r *rows) Busy() bool {
+	return r.pstmt != 0 && r.c.stmtBusy(r.pstmt)
+}
+
+// ColumnValue returns the i-th column of the current row as a Value, which
+// can be passed as an argument to another statement of any connection to bind
+// it as is, without converting it to a Go value and back. It is available to
+// users of the driver interfaces, eg. via sql.Conn.Raw, by asserting a
+// driver.Rows to interface{ ColumnValue(int) sqlite.Value }.
+//
+// The Value is valid only until the next call to Next or Close of r, and must
+// not be used while r is used by another goroutine.
+//
+// sqlite3_value *sqlite3_column_value(sqlite3_stmt*, int iCol);
+func (r *rows) ColumnValue(i int) Value {
+	if r.pstmt == 0 {
+		panic("sqlite: ColumnValue on closed rows")
+	}
+
+	if i < 0 || i >= len(r.columns) {
+		panic(fmt.Sprintf("sqlite: column index out of range: %d", i))
+	}
+
+	return Value{tls: r.c.tls, p: sqlite3.Xsqlite3_column_value(r.c.tls, r.pstmt, int32(i))}
 }
 
 // Columns returns the names of the columns. The number of columns of the
@@ -222,9 +300,16 @@ func (r *rows) Columns() (c []string) {
 //
 // Next should return io.EOF when there are no more rows.
 func (r *rows) Next(dest []driver.Value) error {
+	// stepping again after an error would reset the statement and restart
+	// the query
+	if r.err != nil {
+		return r.err
+	}
+
 	// yet another step
 	rc, err := r.c.step(r.pstmt)
 	if err != nil {
+		r.err = err
 		return err
 	}
 
@@ -236,57 +321,94 @@ func (r *rows) Next(dest []driver.Value) error {
 		}
 
 		for i := range dest {
-			ct, err := r.c.columnType(r.pstmt, i)
+			v, err := r.columnValue(i)
 			if err != nil {
-				return err
+				return r.columnError(i, err)
 			}
 
-			switch ct {
-			case sqlite3.SQLITE_INTEGER:
-				v, err := r.c.columnInt64(r.pstmt, i)
-				if err != nil {
-					return err
-				}
+			dest[i] = v
+		}
+		return nil
+	case sqlite3.SQLITE_DONE:
+		return io.EOF
+	default:
+		r.err = r.c.errstr(int32(rc))
+		return r.err
+	}
+}
 
-				dest[i] = v
-			case sqlite3.SQLITE_FLOAT:
-				v, err := r.c.columnDouble(r.pstmt, i)
-				if err != nil {
-					return err
-				}
+// columnValue returns the value of the column i of the current row.
+func (r *rows) columnValue(i int) (driver.Value, error) {
+	ct, err := r.c.columnType(r.pstmt, i)
+	if err != nil {
+		return nil, err
+	}
 
-				dest[i] = v
-			case sqlite3.SQLITE_TEXT:
-				v, err := r.c.columnText(r.pstmt, i)
-				if err != nil {
-					return err
-				}
+	if r.c.stableColumnTypes {
+		ct = stableColumnType(r.c.columnDeclType(r.pstmt, i), ct)
+	}
 
-				switch r.ColumnTypeDatabaseTypeName(i) {
-				case "DATE", "DATETIME", "TIMESTAMP":
-					dest[i], _ = r.c.parseTime(v)
-				default:
-					dest[i] = v
-				}
-			case sqlite3.SQLITE_BLOB:
-				v, err := r.c.columnBlob(r.pstmt, i)
-				if err != nil {
-					return err
-				}
+	switch ct {
+	case sqlite3.SQLITE_INTEGER:
+		return r.c.columnInt64(r.pstmt, i)
+	case sqlite3.SQLITE_FLOAT:
+		return r.c.columnDouble(r.pstmt, i)
+	case sqlite3.SQLITE_TEXT:
+		v, err := r.c.columnText(r.pstmt, i)
+		if err != nil {
+			return nil, err
+		}
 
-				dest[i] = v
-			case sqlite3.SQLITE_NULL:
-				dest[i] = nil
-			default:
-				return fmt.Errorf("internal error: rc %d", rc)
-			}
+		switch r.ColumnTypeDatabaseTypeName(i) {
+		case "DATE", "DATETIME", "TIMESTAMP":
+			t, _ := r.c.parseTime(v)
+			return t, nil
+		default:
+			return v, nil
 		}
-		return nil
-	case sqlite3.SQLITE_DONE:
-		return io.EOF
+	case sqlite3.SQLITE_BLOB:
+		return r.c.columnBlob(r.pstmt, i)
+	case sqlite3.SQLITE_NULL:
+		return nil, nil
 	default:
-		return r.c.errstr(int32(rc))
+		return nil, fmt.Errorf("sqlite: unexpected column type %d", ct)
+	}
+}
+
+// stableColumnType returns the storage class, ct or the one it converts to
+// without loss, to read the value of a column declared as declType, see
+// StableColumnTypes.
+func stableColumnType(declType string, ct int) int {
+	// The affinity rules of https://www.sqlite.org/datatype3.html#determination_of_column_affinity.
+	t := strings.ToUpper(declType)
+	switch {
+	case strings.Contains(t, "INT"):
+		return ct
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		if ct != sqlite3.SQLITE_NULL {
+			return sqlite3.SQLITE_TEXT
+		}
+	case t == "", strings.Contains(t, "BLOB"):
+		return ct
+	case strings.Contains(t, "BOOL"), strings.Contains(t, "DATE"), strings.Contains(t, "TIME"):
+		// NUMERIC, but the integers are flags or Unix times.
+		return ct
+	default: // REAL and NUMERIC
+		if ct == sqlite3.SQLITE_INTEGER {
+			return sqlite3.SQLITE_FLOAT
+		}
+	}
+	return ct
+}
+
+// columnError annotates err, returned for the column i, with the index, name
+// and declared type of the column.
+func (r *rows) columnError(i int, err error) error {
+	typ := r.ColumnTypeDatabaseTypeName(i)
+	if typ == "" {
+		typ = "no declared type"
 	}
+	return fmt.Errorf("sqlite: Next: column %d %q (%s): %w", i, r.columns[i], typ, err)
 }
 
 // Inspired by mattn/go-sqlite3: https://github.com/mattn/go-sqlite3/blob/ab91e934/sqlite3.go#L210-L226
@@ -346,6 +468,13 @@ var writeTimeFormats = map[string]string{
 }
 
 func (c *conn) formatTime(t time.Time) string {
+	// The formats write the zone offset in minutes, so a time in a zone
+	// with an offset of eg. +00:09:21, like local mean times before 1900, is
+	// written as UTC to keep the instant exact.
+	if _, offset := t.Zone(); offset%60 != 0 {
+		t = t.UTC()
+	}
+
 	// default format is the first element from parseTimeFormats slice
 	// this is inspired by https://github.com/mattn/go-sqlite3/blob/85436841b33e86c07dce0fa2e88c31a97c96a22f/sqlite3.go#L1893
 	if c.writeTimeFormat == "" {
@@ -450,6 +579,11 @@ func (r *rows) ColumnTypeScanType(index int) reflect.Type {
 	case sqlite3.SQLITE_BLOB:
 		return reflect.SliceOf(reflect.TypeOf([]byte{}))
 	case sqlite3.SQLITE_NULL:
+		if declType == "boolean" {
+			// A NULL Boolean is not false, scan it into a sql.NullBool.
+			return reflect.TypeOf(sql.NullBool{})
+		}
+
 		return reflect.TypeOf(nil)
 	default:
 		return reflect.TypeOf("")
@@ -459,6 +593,16 @@ func (r *rows) ColumnTypeScanType(index int) reflect.Type {
 type stmt struct {
 	c    *conn
 	psql uintptr
+	sql  string
+
+	// parameters of the statements in psql, by position, cached by the
+	// first execution
+	params [][]bindParam
+
+	// the only statement in psql kept compiled by precompile, see PrepareAll
+	pstmt     uintptr
+	ptail     uintptr // the tail left by compiling pstmt
+	pstmtBusy bool    // pstmt is being executed
 }
 
 func newStmt(c *conn, sql string) (*stmt, error) {
@@ -466,7 +610,7 @@ func newStmt(c *conn, sql string) (*stmt, error) {
 	if err != nil {
 		return nil, err
 	}
-	stm := stmt{c: c, psql: p}
+	stm := stmt{c: c, psql: p, sql: sql}
 
 	return &stm, nil
 }
@@ -475,17 +619,61 @@ func newStmt(c *conn, sql string) (*stmt, error) {
 //
 // As of Go 1.1, a Stmt will not be closed if it's in use by any queries.
 func (s *stmt) Close() (err error) {
+	if s.pstmt != 0 {
+		err = s.c.finalize(s.pstmt)
+		s.pstmt = 0
+	}
 	s.c.free(s.psql)
 	s.psql = 0
+	return err
+}
+
+// precompile compiles the SQL text of s and keeps the result for the
+// executions of s, unless the text has more than one statement.
+func (s *stmt) precompile() error {
+	psql := s.psql
+	pstmt, err := s.c.prepareV2(&psql)
+	if err != nil || pstmt == 0 {
+		return err
+	}
+
+	if !sqlTailIsEmpty(psql) {
+		return s.c.finalize(pstmt)
+	}
+
+	s.pstmt, s.ptail = pstmt, psql
 	return nil
 }
 
+// prepare compiles the next statement of the SQL text of s at *psql and
+// advances *psql past it, or returns the statement kept by precompile.
+func (s *stmt) prepare(psql *uintptr) (uintptr, error) {
+	if *psql == s.psql && s.pstmt != 0 && !s.pstmtBusy {
+		s.pstmtBusy = true
+		*psql = s.ptail
+		return s.pstmt, nil
+	}
+
+	return s.c.prepareV2(psql)
+}
+
+// finalize finalizes pstmt returned by prepare, or resets the statement kept
+// by precompile for its next execution.
+func (s *stmt) finalize(pstmt uintptr) error {
+	if pstmt != 0 && pstmt == s.pstmt {
+		s.pstmtBusy = false
+		return s.c.reset(pstmt)
+	}
+
+	return s.c.finalize(pstmt)
+}
+
 // Exec executes a query that doesn't return rows, such as an INSERT or UPDATE.
 //
 // Deprecated: Drivers should implement StmtExecContext instead (or
 // additionally).
 func (s *stmt) Exec(args []driver.Value) (driver.Result, error) { //TODO StmtExecContext
-	return s.exec(context.Background(), toNamedValues(args))
+	return s.ExecContext(context.Background(), toNamedValues(args))
 }
 
 // toNamedValues converts []driver.Value to []driver.NamedValue
@@ -501,25 +689,48 @@ func (s *stmt) exec(ctx context.Context, args []driver.NamedValue) (r driver.Res
 	var pstmt uintptr
 	var done int32
 	if ctx != nil && ctx.Done() != nil {
-		defer interruptOnDone(ctx, s.c, &done)()
+		defer s.c.cancelOnDone(ctx, &done)()
 	}
 
-	for psql := s.psql; *(*byte)(unsafe.Pointer(psql)) != 0 && atomic.LoadInt32(&done) == 0; {
-		if pstmt, err = s.c.prepareV2(&psql); err != nil {
+	defer endStatement(s.c.beginStatement(s.sql))
+
+	var autoTx bool
+	defer func() {
+		if autoTx {
+			if err2 := s.c.endAutoTx(err == nil); err2 != nil && err == nil {
+				r, err = nil, err2
+			}
+		}
+	}()
+
+	for psql, k := s.psql, 0; *(*byte)(unsafe.Pointer(psql)) != 0 && atomic.LoadInt32(&done) == 0; k++ {
+		if pstmt, err = s.prepare(&psql); err != nil {
 			return nil, err
 		}
 
 		if pstmt == 0 {
+			k--
 			continue
 		}
+
+		if k == 0 && s.c.autoTxMultiStatement && !sqlTailIsEmpty(psql) && sqlite3.Xsqlite3_get_autocommit(s.c.tls, s.c.db) != 0 {
+			if err = s.c.beginAutoTx(); err != nil {
+				s.finalize(pstmt)
+				return nil, err
+			}
+
+			autoTx = true
+		}
 		err = func() (err error) {
 			n, err := s.c.bindParameterCount(pstmt)
 			if err != nil {
 				return err
 			}
 
+			var streams []streamArg
 			if n != 0 {
-				allocs, err := s.c.bind(pstmt, n, args)
+				params := s.bindParams(k, pstmt, n)
+				allocs, err := s.c.bind(pstmt, params, args)
 				if err != nil {
 					return err
 				}
@@ -531,9 +742,16 @@ func (s *stmt) exec(ctx context.Context, args []driver.NamedValue) (r driver.Res
 						}
 					}()
 				}
+
+				streams = boundStreams(params, args)
 			}
 
-			rc, err := s.c.step(pstmt)
+			var rc int
+			if len(streams) != 0 {
+				rc, err = s.c.stepStreams(pstmt, streams)
+			} else {
+				rc, err = s.c.step(pstmt)
+			}
 			if err != nil {
 				return err
 			}
@@ -545,10 +763,11 @@ func (s *stmt) exec(ctx context.Context, args []driver.NamedValue) (r driver.Res
 				return s.c.errstr(int32(rc))
 			}
 
+			s.c.checkSchemaChange(pstmt)
 			return nil
 		}()
 
-		if e := s.c.finalize(pstmt); e != nil && err == nil {
+		if e := s.finalize(pstmt); e != nil && err == nil {
 			err = e
 		}
 
@@ -559,6 +778,49 @@ func (s *stmt) exec(ctx context.Context, args []driver.NamedValue) (r driver.Res
 	return newResult(s.c)
 }
 
+// sqlTailIsEmpty reports whether the SQL text at p, the tail left by
+// sqlite3_prepare_v2, contains only white space and semicolons.
+func sqlTailIsEmpty(p uintptr) bool {
+	for ; ; p++ {
+		switch *(*byte)(unsafe.Pointer(p)) {
+		case 0:
+			return true
+		case ' ', '\t', '\n', '\r', '\f', ';':
+			// nop
+		default:
+			return false
+		}
+	}
+}
+
+// beginAutoTx starts the transaction wrapping a multi-statement Exec, see
+// AutoTxMultiStatement.
+func (c *conn) beginAutoTx() error {
+	sql := "begin"
+	if c.beginMode != "" {
+		sql = "begin " + c.beginMode
+	}
+	_, err := c.exec(context.Background(), sql, nil)
+	return err
+}
+
+// endAutoTx commits, or rolls back, the transaction started by beginAutoTx,
+// unless a statement of the Exec already ended it.
+func (c *conn) endAutoTx(commit bool) (err error) {
+	if sqlite3.Xsqlite3_get_autocommit(c.tls, c.db) != 0 {
+		return nil
+	}
+
+	if commit {
+		if _, err = c.exec(context.Background(), "commit", nil); err == nil || sqlite3.Xsqlite3_get_autocommit(c.tls, c.db) != 0 {
+			return err
+		}
+	}
+
+	c.exec(context.Background(), "rollback", nil)
+	return err
+}
+
 // NumInput returns the number of placeholder parameters.
 //
 // If NumInput returns >= 0, the sql package will sanity check argument counts
@@ -578,7 +840,7 @@ func (s *stmt) NumInput() (n int) {
 // Deprecated: Drivers should implement StmtQueryContext instead (or
 // additionally).
 func (s *stmt) Query(args []driver.Value) (driver.Rows, error) { //TODO StmtQueryContext
-	return s.query(context.Background(), toNamedValues(args))
+	return s.QueryContext(context.Background(), toNamedValues(args))
 }
 
 func (s *stmt) query(ctx context.Context, args []driver.NamedValue) (r driver.Rows, err error) {
@@ -587,22 +849,40 @@ func (s *stmt) query(ctx context.Context, args []driver.NamedValue) (r driver.Ro
 
 	// context honoring
 	if ctx != nil && ctx.Done() != nil {
-		defer interruptOnDone(ctx, s.c, &done)()
+		defer s.c.cancelOnDone(ctx, &done)()
+	}
+
+	for _, v := range args {
+		if _, ok := v.Value.(streamArg); ok {
+			return nil, errStreamQuery
+		}
 	}
 
+	// the statement stays active until the rows are closed
+	id := s.c.beginStatement(s.sql)
+	defer func() {
+		if err != nil {
+			endStatement(id)
+			if pstmt != 0 && pstmt == s.pstmt && s.pstmtBusy {
+				s.finalize(pstmt)
+			}
+		}
+	}()
+
 	// generally, query may contain multiple SQL statements
 	// here we execute every but the last statement
 	// we then create rows instance for deferred execution of the last statement
 
 	// loop on all but last statements
-	for pzTail := s.psql; ; {
+	k := 0 // position of the statement
+	for pzTail := s.psql; ; k++ {
 		// honor the context
 		if atomic.LoadInt32(&done) != 0 {
 			return nil, ctx.Err()
 		}
 
 		// prepare yet another portion of SQL string
-		if pstmt, err = s.c.prepareV2(&pzTail); err != nil {
+		if pstmt, err = s.prepare(&pzTail); err != nil {
 			return nil, err
 		}
 
@@ -616,6 +896,7 @@ func (s *stmt) query(ctx context.Context, args []driver.NamedValue) (r driver.Ro
 		// If the input text contains no SQL (if the input is an empty string or a comment) then *ppStmt is set to NULL
 		if pstmt == 0 {
 			// we can safely skip it
+			k--
 			continue
 		}
 
@@ -627,7 +908,7 @@ func (s *stmt) query(ctx context.Context, args []driver.NamedValue) (r driver.Ro
 
 		if nParams > 0 {
 			// bind the required portion of args
-			if allocs, err := s.c.bind(pstmt, nParams, args); err != nil {
+			if allocs, err := s.c.bind(pstmt, s.bindParams(k, pstmt, nParams), args); err != nil {
 				return nil, err
 			} else {
 				// defer free allocated data
@@ -662,7 +943,7 @@ func (s *stmt) query(ctx context.Context, args []driver.NamedValue) (r driver.Ro
 		}
 
 		// The application must finalize every prepared statement in order to avoid resource leaks.
-		if err := s.c.finalize(pstmt); err != nil {
+		if err := s.finalize(pstmt); err != nil {
 			return nil, err
 		}
 	}
@@ -678,41 +959,68 @@ func (s *stmt) query(ctx context.Context, args []driver.NamedValue) (r driver.Ro
 
 	// bind the required portion of args
 	var allocs []uintptr
-	allocs, err = s.c.bind(pstmt, nParams, args)
+	allocs, err = s.c.bind(pstmt, s.bindParams(k, pstmt, nParams), args)
 	if err != nil {
 		return nil, err
 	}
 
 	// create rows
-	r, err = newRows(s.c, pstmt, allocs)
+	rows, err := newRows(s, pstmt, allocs)
 	if err != nil {
 		return nil, err
 	}
-	return r, nil
+
+	rows.statementID = id
+	return rows, nil
 }
 
 type tx struct {
 	c *conn
 }
 
-func newTx(c *conn, opts driver.TxOptions) (*tx, error) {
+// beginModeKey is the context key overriding the locking behavior of a
+// transaction, see WithWriteTx.
+type beginModeKey struct{}
+
+func newTx(ctx context.Context, c *conn, opts driver.TxOptions) (*tx, error) {
 	r := &tx{c: c}
 
+	mode := c.beginMode
+	if m, ok := ctx.Value(beginModeKey{}).(string); ok {
+		mode = m
+	}
+
 	sql := "begin"
-	if !opts.ReadOnly && c.beginMode != "" {
-		sql = "begin " + c.beginMode
+	if !opts.ReadOnly && mode != "" {
+		sql = "begin " + mode
 	}
 
 	if err := r.exec(context.Background(), sql); err != nil {
 		return nil, err
 	}
 
+	// The pragma resets at the end of every transaction.
+	if c.deferForeignKeys {
+		if err := r.exec(context.Background(), "pragma defer_foreign_keys = on"); err != nil {
+			r.exec(context.Background(), "rollback")
+			return nil, err
+		}
+	}
+
 	return r, nil
 }
 
 // Commit implements driver.Tx.
 func (t *tx) Commit() (err error) {
-	return t.exec(context.Background(), "commit")
+	if err = t.exec(context.Background(), "commit"); err != nil && sqlite3.Xsqlite3_get_autocommit(t.c.tls, t.c.db) == 0 {
+		// A COMMIT failing with eg. SQLITE_BUSY leaves the transaction
+		// open, but database/sql considers it finished and reuses the
+		// connection.
+		if err2 := t.exec(context.Background(), "rollback"); err2 != nil {
+			return fmt.Errorf("%w; rolling back the transaction: %v", err, err2)
+		}
+	}
+	return err
 }
 
 // Rollback implements driver.Tx.
@@ -730,7 +1038,7 @@ func (t *tx) exec(ctx context.Context, sql string) (err error) {
 	//TODO use t.conn.ExecContext() instead
 
 	if ctx != nil && ctx.Done() != nil {
-		defer interruptOnDone(ctx, t.c, nil)()
+		defer t.c.cancelOnDone(ctx, nil)()
 	}
 
 	if rc := sqlite3.Xsqlite3_exec(t.c.tls, t.c.db, psql, 0, 0, 0); rc != sqlite3.SQLITE_OK {
@@ -787,9 +1095,47 @@ type conn struct {
 
 	writeTimeFormat string
 	beginMode       string
+	noUnlockNotify  bool // report SQLITE_LOCKED_SHAREDCACHE instead of waiting
+	queryOnly       bool // keep PRAGMA query_only on, see QueryOnly
+
+	busyTimeout       time.Duration // as last set by the driver, see busyHint
+	busyTimeoutChosen bool          // busyTimeout was set by SetBusyTimeout
+
+	autoTxMultiStatement bool // see AutoTxMultiStatement
+	looseTypeBinding     bool // see LooseTypeBinding
+	stableColumnTypes    bool // see StableColumnTypes
+	deferForeignKeys     bool // see DeferForeignKeys
+	emptyStringAsNull    bool // see EmptyStringAsNull
+	trackStatements      bool // see TrackStatements
+
+	audit func(AuditEvent) // see Audit
+
+	progress progressState // see SetProgressHandler and ProgressCancel
+
+	schemaChangeHook func()
+	schemaVersion    int64 // last schema_version seen by schemaChangeHook
+
+	countVMSteps bool  // accumulate the VM steps of finalized statements
+	vmSteps      int64 // in vmSteps
+
+	openStmts   int   // prepared and not yet finalized statements
+	prepares    int64 // statements compiled since ResetStats
+	totalSteps  int64 // VM steps of the statements finalized since ResetStats
+	changesBase int64 // sqlite3_total_changes64 at ResetStats
+
+	connector *connector // the connector that opened this connection, if any
+
+	backups    map[*Backup]struct{} // unfinished backups from or to c, see NewBackup
+	authorizer uintptr              // handle of the SetAuthorizer callback, if any
+	trace      uintptr              // handle of the RegisterTrace callback, if any
 }
 
-func newConn(dsn string) (*conn, error) {
+// defaultOpenFlags are the sqlite3_open_v2 flags used by newConn.
+const defaultOpenFlags = sqlite3.SQLITE_OPEN_READWRITE | sqlite3.SQLITE_OPEN_CREATE |
+	sqlite3.SQLITE_OPEN_FULLMUTEX |
+	sqlite3.SQLITE_OPEN_URI
+
+func newConn(dsn string, flags int32) (*conn, error) {
 	var query, vfsName string
 
 	// Parse the query parameters from the dsn and them from the dsn if not prefixed by file:
@@ -806,17 +1152,24 @@ func newConn(dsn string) (*conn, error) {
 
 		if !strings.HasPrefix(dsn, "file:") {
 			dsn = dsn[:pos]
+			flags = queryOpenFlags(query, flags)
+		}
+
+		if isNamedMemoryDB(dsn[:pos], query) {
+			flags |= sqlite3.SQLITE_OPEN_SHAREDCACHE
+		}
+	}
+
+	if vfsName == "" {
+		name := dsn
+		if pos := strings.IndexRune(name, '?'); pos >= 0 {
+			name = name[:pos]
 		}
+		vfsName = openHookVFS(name)
 	}
 
 	c := &conn{tls: libc.NewTLS()}
-	db, err := c.openV2(
-		dsn,
-		vfsName,
-		sqlite3.SQLITE_OPEN_READWRITE|sqlite3.SQLITE_OPEN_CREATE|
-			sqlite3.SQLITE_OPEN_FULLMUTEX|
-			sqlite3.SQLITE_OPEN_URI,
-	)
+	db, err := c.openV2(dsn, vfsName, flags)
 	if err != nil {
 		return nil, err
 	}
@@ -847,8 +1200,7 @@ func newConn(dsn string) (*conn, error) {
 func stmtLog(tls *libc.TLS, type1 uint32, cd uintptr, pd uintptr, xd uintptr) int32 { /* tclsqlite.c:661:12: */
 	if type1 == uint32(sqlite3.SQLITE_TRACE_STMT) {
 		// get SQL string
-		stmtEx := libc.GoString(sqlite3.Xsqlite3_expanded_sql(tls, pd))
-		log.Println(strings.Trim(stmtEx, "\r\n\t "))
+		log.Println(strings.Trim(expandedSQL(tls, pd), "\r\n\t "))
 	}
 	return sqlite3.SQLITE_OK
 }
@@ -870,6 +1222,52 @@ func getVFSName(query string) (r string, err error) {
 	return r, nil
 }
 
+// queryOpenFlags returns flags adjusted by the cache and mode query parameters
+// of a dsn that is not an URI. SQLite interprets these parameters only in URI
+// filenames, so for plain names they are mapped to the equivalent
+// sqlite3_open_v2 flags. Other values are ignored.
+func queryOpenFlags(query string, flags int32) int32 {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return flags
+	}
+
+	switch q.Get("cache") {
+	case "shared":
+		flags |= sqlite3.SQLITE_OPEN_SHAREDCACHE
+	case "private":
+		flags |= sqlite3.SQLITE_OPEN_PRIVATECACHE
+	}
+
+	switch q.Get("mode") {
+	case "ro":
+		flags = flags&^(sqlite3.SQLITE_OPEN_READWRITE|sqlite3.SQLITE_OPEN_CREATE) | sqlite3.SQLITE_OPEN_READONLY
+	case "rw":
+		flags &^= sqlite3.SQLITE_OPEN_CREATE
+	case "memory":
+		flags |= sqlite3.SQLITE_OPEN_MEMORY
+	}
+	return flags
+}
+
+// isNamedMemoryDB reports whether the dsn "name?query" names an in-memory
+// database, eg. "file:name?mode=memory", without choosing the cache mode.
+// Connections to such a database share it, and a database of a different name
+// is a different database, as if "cache=shared" was given.
+func isNamedMemoryDB(name, query string) bool {
+	if !strings.HasPrefix(name, "file:") || name == "file:" || name == "file::memory:" {
+		return false
+	}
+
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return false
+	}
+
+	_, cache := q["cache"]
+	return q.Get("mode") == "memory" && !cache
+}
+
 func applyQueryParams(c *conn, query string) error {
 	q, err := url.ParseQuery(query)
 	if err != nil {
@@ -882,12 +1280,22 @@ func applyQueryParams(c *conn, query string) error {
 		return err
 	}
 
+	c.busyTimeout = 5 * time.Second
 	for _, v := range q["_pragma"] {
 		cmd := "pragma " + v
 		_, err := c.exec(context.Background(), cmd, nil)
 		if err != nil {
 			return err
 		}
+
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(v)), "busy_timeout") {
+			ms, err := c.queryInt64("pragma busy_timeout")
+			if err != nil {
+				return err
+			}
+
+			c.busyTimeout = time.Duration(ms) * time.Millisecond
+		}
 	}
 
 	if v := q.Get("_time_format"); v != "" {
@@ -928,6 +1336,9 @@ func (c *conn) columnBlob(pstmt uintptr, iCol int) (v []byte, err error) {
 }
 
 // int sqlite3_column_bytes(sqlite3_stmt*, int iCol);
+//
+// The result fits an int, SQLite refuses to create values larger than
+// SQLITE_LIMIT_LENGTH, which is at most 2^31-1 bytes.
 func (c *conn) columnBytes(pstmt uintptr, iCol int) (_ int, err error) {
 	v := sqlite3.Xsqlite3_column_bytes(c.tls, pstmt, int32(iCol))
 	return int(v), nil
@@ -1016,6 +1427,10 @@ func (c *conn) step(pstmt uintptr) (int, error) {
 }
 
 func (c *conn) retry(pstmt uintptr) error {
+	if c.noUnlockNotify {
+		return c.errstr(sqliteLockedSharedcache)
+	}
+
 	mu := mutexAlloc(c.tls)
 	(*mutex)(unsafe.Pointer(mu)).Lock()
 	rc := sqlite3.Xsqlite3_unlock_notify(
@@ -1049,7 +1464,138 @@ func unlockNotify(t *libc.TLS, ppArg uintptr, nArg int32) {
 	}
 }
 
-func (c *conn) bind(pstmt uintptr, n int, args []driver.NamedValue) (allocs []uintptr, err error) {
+// waitForUnlock waits until the connection that made the last statement of c
+// fail with SQLITE_LOCKED_SHAREDCACHE ends its transaction, or ctx is done.
+func (c *conn) waitForUnlock(ctx context.Context) error {
+	unlocked := make(chan struct{})
+	h := newHandle(unlocked)
+	defer deleteHandle(h)
+
+	rc := sqlite3.Xsqlite3_unlock_notify(
+		c.tls,
+		c.db,
+		*(*uintptr)(unsafe.Pointer(&struct {
+			f func(*libc.TLS, uintptr, int32)
+		}{unlockNotifyChan})),
+		h,
+	)
+	if rc != sqlite3.SQLITE_OK { // Deadlock, see https://www.sqlite.org/c3ref/unlock_notify.html
+		return c.errstr(rc)
+	}
+
+	select {
+	case <-unlocked:
+		return nil
+	case <-ctx.Done():
+		// Cancel the callback, which may have run by now.
+		sqlite3.Xsqlite3_unlock_notify(c.tls, c.db, 0, 0)
+		return ctx.Err()
+	}
+}
+
+// unlockNotifyChan is the unlock-notify callback of waitForUnlock, which
+// closes the channels registered for the handles at ppArg.
+func unlockNotifyChan(t *libc.TLS, ppArg uintptr, nArg int32) {
+	for i := int32(0); i < nArg; i++ {
+		close(handleValue(*(*uintptr)(unsafe.Pointer(ppArg))).(chan struct{}))
+		ppArg += ptrSize
+	}
+}
+
+// WaitForUnlock waits until the table lock that made the last statement of
+// the connection c fail with SQLITE_LOCKED_SHAREDCACHE is released, ie. the
+// transaction of the other connection to the same shared cache holding it
+// ends, so that the application can retry instead of spinning. It returns
+// immediately if no statement of c was blocked, and ctx.Err() if ctx is done
+// first. Statements wait by themselves unless the UnlockNotify connector
+// option disabled it.
+//
+// It fails with SQLITE_LOCKED if waiting would deadlock, because the other
+// connection is itself waiting for a lock held by c.
+//
+//	int sqlite3_unlock_notify(
+//		sqlite3 *pBlocked,                          /* Waiting connection */
+//		void (*xNotify)(void **apArg, int nArg),    /* Callback function to invoke */
+//		void *pNotifyArg                            /* Argument to pass to xNotify */
+//	);
+func WaitForUnlock(ctx context.Context, c *sql.Conn) error {
+	return withConn(c, func(c *conn) error { return c.waitForUnlock(ctx) })
+}
+
+// bindParam describes a parameter of a prepared statement.
+type bindParam struct {
+	name    string // name without the prefix, empty for "?"
+	ordinal int    // ordinal of the matching unnamed argument, if any
+}
+
+// bindParams returns the parameters of pstmt, the statement at position k in
+// s, which has n parameters. They are cached for the next executions of s.
+func (s *stmt) bindParams(k int, pstmt uintptr, n int) []bindParam {
+	for len(s.params) <= k {
+		s.params = append(s.params, nil)
+	}
+	if len(s.params[k]) != n {
+		s.params[k] = s.c.bindParams(pstmt, n)
+	}
+	return s.params[k]
+}
+
+func (c *conn) bindParams(pstmt uintptr, n int) []bindParam {
+	r := make([]bindParam, n)
+	for i := range r {
+		name, _ := c.bindParameterName(pstmt, i+1)
+		if name == "" {
+			r[i].ordinal = i + 1
+			continue
+		}
+
+		r[i].name = name[1:]
+
+		// For ?NNN and $NNN params, match if NNN == v.Ordinal.
+		//
+		// Supporting this for $NNN is a special case that makes eg
+		// `select $1, $2, $3 ...` work without needing to use
+		// sql.Named.
+		if name[0] == '?' || name[0] == '$' {
+			if n, err := strconv.Atoi(name[1:]); err == nil && n > 0 && strconv.Itoa(n) == name[1:] {
+				r[i].ordinal = n
+			}
+		}
+	}
+	return r
+}
+
+// argIndex returns the index of the argument of p in args, or -1 if there is
+// none. The maps, if not nil, index args by name and ordinal.
+//
+// sqlite supports '$', '@' and ':' prefixes for string identifiers and '?'
+// for numeric, so we cannot combine different prefixes with the same name
+// because `database/sql` requires variable names to start with a letter.
+func (p bindParam) argIndex(args []driver.NamedValue, byName map[string]int, byOrdinal map[int]int) int {
+	if byName == nil {
+		for j, v := range args {
+			if p.ordinal != 0 && v.Ordinal == p.ordinal || p.name != "" && v.Name == p.name {
+				return j
+			}
+		}
+		return -1
+	}
+
+	r := -1
+	if j, ok := byOrdinal[p.ordinal]; ok && p.ordinal != 0 {
+		r = j
+	}
+	if j, ok := byName[p.name]; ok && p.name != "" && (r < 0 || j < r) {
+		r = j
+	}
+	return r
+}
+
+// bindLinearSearchMax is the number of arguments up to which bind searches
+// them linearly instead of building an index.
+const bindLinearSearchMax = 16
+
+func (c *conn) bind(pstmt uintptr, params []bindParam, args []driver.NamedValue) (allocs []uintptr, err error) {
 	defer func() {
 		if err == nil {
 			return
@@ -1061,53 +1607,42 @@ func (c *conn) bind(pstmt uintptr, n int, args []driver.NamedValue) (allocs []ui
 		allocs = nil
 	}()
 
-	for i := 1; i <= n; i++ {
-		name, err := c.bindParameterName(pstmt, i)
-		if err != nil {
-			return allocs, err
-		}
-
-		var found bool
-		var v driver.NamedValue
-		for _, v = range args {
-			if name != "" {
-				// For ?NNN and $NNN params, match if NNN == v.Ordinal.
-				//
-				// Supporting this for $NNN is a special case that makes eg
-				// `select $1, $2, $3 ...` work without needing to use
-				// sql.Named.
-				if (name[0] == '?' || name[0] == '$') && name[1:] == strconv.Itoa(v.Ordinal) {
-					found = true
-					break
-				}
-
-				// sqlite supports '$', '@' and ':' prefixes for string
-				// identifiers and '?' for numeric, so we cannot
-				// combine different prefixes with the same name
-				// because `database/sql` requires variable names
-				// to start with a letter
-				if name[1:] == v.Name[:] {
-					found = true
-					break
-				}
-			} else {
-				if v.Ordinal == i {
-					found = true
-					break
-				}
+	var byName map[string]int
+	var byOrdinal map[int]int
+	if len(args) > bindLinearSearchMax {
+		byName = make(map[string]int, len(args))
+		byOrdinal = make(map[int]int, len(args))
+		for j := len(args) - 1; j >= 0; j-- { // the first argument wins
+			if args[j].Name != "" {
+				byName[args[j].Name] = j
 			}
+			byOrdinal[args[j].Ordinal] = j
 		}
+	}
 
-		if !found {
-			if name != "" {
-				return allocs, fmt.Errorf("missing named argument %q", name[1:])
+	for i := 1; i <= len(params); i++ {
+		param := params[i-1]
+		j := param.argIndex(args, byName, byOrdinal)
+		if j < 0 {
+			if param.name != "" {
+				return allocs, fmt.Errorf("missing named argument %q", param.name)
 			}
 
 			return allocs, fmt.Errorf("missing argument with index %d", i)
 		}
 
+		v := args[j]
+		value := v.Value
+		if vr, ok := value.(driver.Valuer); ok {
+			// Eg. sql.NullString when called through the driver interface
+			// directly, bypassing the conversion done by database/sql.
+			if value, err = valuerValue(vr); err != nil {
+				return allocs, err
+			}
+		}
+
 		var p uintptr
-		switch x := v.Value.(type) {
+		switch x := value.(type) {
 		case int64:
 			if err := c.bindInt64(pstmt, i, x); err != nil {
 				return allocs, err
@@ -1129,14 +1664,48 @@ func (c *conn) bind(pstmt uintptr, n int, args []driver.NamedValue) (allocs []ui
 				return allocs, err
 			}
 		case string:
-			if p, err = c.bindText(pstmt, i, x); err != nil {
+			if x == "" && c.emptyStringAsNull {
+				p, err = c.bindNull(pstmt, i)
+			} else {
+				p, err = c.bindText(pstmt, i, x)
+			}
+			if err != nil {
 				return allocs, err
 			}
-		case time.Time:
-			if p, err = c.bindText(pstmt, i, c.formatTime(x)); err != nil {
+		case blobArg:
+			if p, err = c.bindBlob(pstmt, i, []byte(x)); err != nil {
 				return allocs, err
 			}
-		case nil:
+		case textArg:
+			if p, err = c.bindText(pstmt, i, string(x)); err != nil {
+				return allocs, err
+			}
+		case pointerArg:
+			if err := c.bindPointer(pstmt, i, x); err != nil {
+				return allocs, err
+			}
+		case streamArg:
+			if err := c.bindZeroBlob(pstmt, i, x.size); err != nil {
+				return allocs, err
+			}
+		case Value:
+			if rc := sqlite3.Xsqlite3_bind_value(c.tls, pstmt, int32(i), x.p); rc != sqlite3.SQLITE_OK {
+				return allocs, c.errstr(rc)
+			}
+		case time.Time:
+			if p, err = c.bindText(pstmt, i, c.formatTime(x)); err != nil {
+				return allocs, err
+			}
+		case encoding.BinaryMarshaler:
+			b, err := x.MarshalBinary()
+			if err != nil {
+				return allocs, err
+			}
+
+			if p, err = c.bindBlob(pstmt, i, b); err != nil {
+				return allocs, err
+			}
+		case nil:
 			if p, err = c.bindNull(pstmt, i); err != nil {
 				return allocs, err
 			}
@@ -1150,6 +1719,254 @@ func (c *conn) bind(pstmt uintptr, n int, args []driver.NamedValue) (allocs []ui
 	return allocs, nil
 }
 
+// valuerValue returns vr.Value(). A nil pointer implementing driver.Valuer
+// with a value receiver, like a nil *sql.NullString, yields nil.
+func valuerValue(vr driver.Valuer) (driver.Value, error) {
+	if rv := reflect.ValueOf(vr); rv.Kind() == reflect.Ptr && rv.IsNil() && rv.Type().Elem().Implements(valuerType) {
+		return nil, nil
+	}
+
+	return vr.Value()
+}
+
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+// CheckNamedValue implements driver.NamedValueChecker. Values implementing
+// encoding.BinaryMarshaler that the default conversion of database/sql does
+// not handle are passed through to bind, which stores them as a BLOB, and so
+// are the values returned by AsBlob, AsText, BindPointer and BindStream and
+// Value. Everything else is left to the default conversion.
+//
+// With the LooseTypeBinding connector option, []string and []int64 values are
+// bound as JSON arrays.
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	switch x := nv.Value.(type) {
+	case blobArg, textArg, pointerArg, streamArg, Value:
+		return nil
+	case []string:
+		if c.looseTypeBinding {
+			return bindJSONArray(nv, x == nil, x)
+		}
+	case []int64:
+		if c.looseTypeBinding {
+			return bindJSONArray(nv, x == nil, x)
+		}
+	case [16]byte:
+		nv.Value = x[:]
+		return nil
+	}
+
+	if _, ok := nv.Value.(encoding.BinaryMarshaler); !ok {
+		return driver.ErrSkip
+	}
+
+	// Eg. time.Time, *time.Time or driver.Valuer implementations.
+	if _, err := driver.DefaultParameterConverter.ConvertValue(nv.Value); err == nil {
+		return driver.ErrSkip
+	}
+
+	return nil
+}
+
+// bindJSONArray sets the value of nv to the JSON text of v, or to NULL if v is
+// a nil slice.
+func bindJSONArray(nv *driver.NamedValue, isNil bool, v interface{}) error {
+	if isNil {
+		nv.Value = nil
+		return nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	nv.Value = string(b)
+	return nil
+}
+
+// ResetSession implements driver.SessionResetter. It is called by
+// database/sql before a connection is reused and re-applies the QueryOnly
+// connector option, which a statement may have turned off.
+func (c *conn) ResetSession(ctx context.Context) error {
+	if c.queryOnly {
+		if _, err := c.exec(ctx, "pragma query_only = on", nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type (
+	blobArg string
+	textArg []byte
+)
+
+// AsBlob returns an argument binding s as a BLOB instead of TEXT, eg. to
+// compare it with the values of a BLOB column, which never equal TEXT.
+func AsBlob(s string) interface{} { return blobArg(s) }
+
+// AsText returns an argument binding b as TEXT instead of a BLOB. The bytes
+// should be valid UTF-8.
+func AsText(b []byte) interface{} { return textArg(b) }
+
+// NullableBlob returns b, or nil if b is nil, so that a nil slice is bound, or
+// returned by a registered function, as NULL instead of an empty BLOB.
+func NullableBlob(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+
+	return b
+}
+
+// ScanJSON returns a sql.Scanner decoding the JSON text of a column into dst,
+// which must be a pointer, eg. to read back the []string and []int64 values
+// bound as JSON arrays with the LooseTypeBinding connector option, since
+// database/sql leaves no way to the driver to scan them directly. NULL sets
+// *dst to its zero value.
+//
+//	var ids []int64
+//	err := db.QueryRow("select ids from t").Scan(sqlite.ScanJSON(&ids))
+func ScanJSON(dst interface{}) sql.Scanner { return jsonScanner{dst} }
+
+type jsonScanner struct{ dst interface{} }
+
+// Scan implements sql.Scanner.
+func (s jsonScanner) Scan(src interface{}) error {
+	rv := reflect.ValueOf(s.dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("sqlite: ScanJSON: non-pointer or nil destination %T", s.dst)
+	}
+
+	switch x := src.(type) {
+	case nil:
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+		return nil
+	case string:
+		return json.Unmarshal([]byte(x), s.dst)
+	case []byte:
+		return json.Unmarshal(x, s.dst)
+	default:
+		return fmt.Errorf("sqlite: ScanJSON: cannot decode %T", src)
+	}
+}
+
+type pointerArg struct {
+	typ string
+	v   interface{}
+}
+
+// BindPointer returns an argument binding v using the pointer-passing
+// interface of SQLite. The parameter is NULL to SQL, but functions written in
+// Go can get v back from it using Value.Pointer with the same typ. The type
+// tag prevents a pointer from being interpreted as something it is not, pick
+// one unlikely to collide, eg. prefixed by the name of the package.
+//
+// v is referenced for as long as SQLite keeps the binding, ie. until the
+// statement is reset or closed, and must not be modified before. It is not
+// copied to C memory, so it can be any Go value.
+//
+// int sqlite3_bind_pointer(sqlite3_stmt*, int, void*, const char*,void(*)(void*));
+func BindPointer(typ string, v interface{}) interface{} { return pointerArg{typ, v} }
+
+// pointerTypes maps the type tags of BindPointer to C strings. SQLite
+// requires them to outlive the bindings, so they are never freed.
+var pointerTypes = struct {
+	sync.Mutex
+	m map[string]uintptr
+}{
+	m: map[string]uintptr{},
+}
+
+func pointerType(typ string) (uintptr, error) {
+	pointerTypes.Lock()
+	defer pointerTypes.Unlock()
+
+	if p, ok := pointerTypes.m[typ]; ok {
+		return p, nil
+	}
+
+	p, err := libc.CString(typ)
+	if err != nil {
+		return 0, err
+	}
+
+	pointerTypes.m[typ] = p
+	return p, nil
+}
+
+// ScanBinary returns a sql.Scanner that decodes a BLOB column into dst using
+// its UnmarshalBinary method. It is the counterpart of binding a value
+// implementing encoding.BinaryMarshaler, which is stored as a BLOB. Scanning
+// a NULL leaves dst unchanged.
+//
+//	var v myType // *myType implements encoding.BinaryUnmarshaler
+//	err := db.QueryRow("select b from t").Scan(sqlite.ScanBinary(&v))
+func ScanBinary(dst encoding.BinaryUnmarshaler) sql.Scanner {
+	return binaryScanner{dst}
+}
+
+type binaryScanner struct {
+	dst encoding.BinaryUnmarshaler
+}
+
+// Scan implements sql.Scanner.
+func (s binaryScanner) Scan(src interface{}) error {
+	switch x := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return s.dst.UnmarshalBinary(x)
+	case string:
+		return s.dst.UnmarshalBinary([]byte(x))
+	default:
+		return fmt.Errorf("sqlite: cannot scan %T into %T", src, s.dst)
+	}
+}
+
+// ScanNumber returns a sql.Scanner that stores an INTEGER or REAL column into
+// dst in its exact decimal representation, eg. to re-serialize rows to JSON
+// without the precision loss of float64 for large integers. REAL values
+// always have a fractional part or an exponent, so that they stay
+// distinguishable from INTEGER values, eg. 2.0 is stored as "2.0". Scanning a
+// NULL leaves dst unchanged.
+//
+//	var n json.Number
+//	err := db.QueryRow("select v from t").Scan(sqlite.ScanNumber(&n))
+func ScanNumber(dst *json.Number) sql.Scanner {
+	return numberScanner{dst}
+}
+
+type numberScanner struct {
+	dst *json.Number
+}
+
+// Scan implements sql.Scanner.
+func (s numberScanner) Scan(src interface{}) error {
+	switch x := src.(type) {
+	case nil:
+		return nil
+	case int64:
+		*s.dst = json.Number(strconv.FormatInt(x, 10))
+		return nil
+	case float64:
+		if math.IsInf(x, 0) || math.IsNaN(x) {
+			return fmt.Errorf("sqlite: cannot scan %v into %T", x, s.dst)
+		}
+
+		v := strconv.FormatFloat(x, 'g', -1, 64)
+		if !strings.ContainsAny(v, ".e") {
+			v += ".0"
+		}
+		*s.dst = json.Number(v)
+		return nil
+	default:
+		return fmt.Errorf("sqlite: cannot scan %T into %T", src, s.dst)
+	}
+}
+
 // int sqlite3_bind_null(sqlite3_stmt*, int);
 func (c *conn) bindNull(pstmt uintptr, idx1 int) (uintptr, error) {
 	if rc := sqlite3.Xsqlite3_bind_null(c.tls, pstmt, int32(idx1)); rc != sqlite3.SQLITE_OK {
@@ -1159,8 +1976,35 @@ func (c *conn) bindNull(pstmt uintptr, idx1 int) (uintptr, error) {
 	return 0, nil
 }
 
+// int sqlite3_bind_pointer(sqlite3_stmt*, int, void*, const char*,void(*)(void*));
+func (c *conn) bindPointer(pstmt uintptr, idx1 int, value pointerArg) error {
+	typ, err := pointerType(value.typ)
+	if err != nil {
+		return err
+	}
+
+	// SQLite calls the destructor also when the binding fails.
+	h := newHandle(value.v)
+	if rc := sqlite3.Xsqlite3_bind_pointer(c.tls, pstmt, int32(idx1), h, typ, *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr)
+	}{releasePointer}))); rc != sqlite3.SQLITE_OK {
+		return c.errstr(rc)
+	}
+
+	return nil
+}
+
+// releasePointer is the destructor of the bindings of BindPointer.
+func releasePointer(tls *libc.TLS, h uintptr) {
+	deleteHandle(h)
+}
+
 // int sqlite3_bind_text(sqlite3_stmt*,int,const char*,int,void(*)(void*));
 func (c *conn) bindText(pstmt uintptr, idx1 int, value string) (uintptr, error) {
+	if len(value) > math.MaxInt32 {
+		return 0, c.errcode(sqlite3.SQLITE_TOOBIG)
+	}
+
 	p, err := libc.CString(value)
 	if err != nil {
 		return 0, err
@@ -1176,6 +2020,10 @@ func (c *conn) bindText(pstmt uintptr, idx1 int, value string) (uintptr, error)
 
 // int sqlite3_bind_blob(sqlite3_stmt*, int, const void*, int n, void(*)(void*));
 func (c *conn) bindBlob(pstmt uintptr, idx1 int, value []byte) (uintptr, error) {
+	if len(value) > math.MaxInt32 {
+		return 0, c.errcode(sqlite3.SQLITE_TOOBIG)
+	}
+
 	if value != nil && len(value) == 0 {
 		if rc := sqlite3.Xsqlite3_bind_zeroblob(c.tls, pstmt, int32(idx1), 0); rc != sqlite3.SQLITE_OK {
 			return 0, c.errstr(rc)
@@ -1239,6 +2087,15 @@ func (c *conn) bindParameterCount(pstmt uintptr) (_ int, err error) {
 
 // int sqlite3_finalize(sqlite3_stmt *pStmt);
 func (c *conn) finalize(pstmt uintptr) error {
+	if pstmt != 0 {
+		steps := int64(c.stmtStatus(pstmt, sqlite3.SQLITE_STMTSTATUS_VM_STEP, false))
+		c.totalSteps += steps
+		if c.countVMSteps {
+			c.vmSteps += steps
+		}
+		c.openStmts--
+	}
+
 	if rc := sqlite3.Xsqlite3_finalize(c.tls, pstmt); rc != sqlite3.SQLITE_OK {
 		return c.errstr(rc)
 	}
@@ -1246,6 +2103,25 @@ func (c *conn) finalize(pstmt uintptr) error {
 	return nil
 }
 
+// reset resets pstmt for its next execution and clears its bindings.
+//
+// int sqlite3_reset(sqlite3_stmt *pStmt);
+func (c *conn) reset(pstmt uintptr) error {
+	steps := int64(c.stmtStatus(pstmt, sqlite3.SQLITE_STMTSTATUS_VM_STEP, true))
+	c.totalSteps += steps
+	if c.countVMSteps {
+		c.vmSteps += steps
+	}
+
+	rc := sqlite3.Xsqlite3_reset(c.tls, pstmt)
+	sqlite3.Xsqlite3_clear_bindings(c.tls, pstmt)
+	if rc != sqlite3.SQLITE_OK {
+		return c.errstr(rc)
+	}
+
+	return nil
+}
+
 // int sqlite3_prepare_v2(
 //
 //	sqlite3 *db,            /* Database handle */
@@ -1271,21 +2147,177 @@ func (c *conn) prepareV2(zSQL *uintptr) (pstmt uintptr, err error) {
 		return 0, err
 	}
 
+	schemaRetried := false
 	for {
 		switch rc := sqlite3.Xsqlite3_prepare_v2(c.tls, c.db, *zSQL, -1, ppstmt, pptail); rc {
 		case sqlite3.SQLITE_OK:
 			*zSQL = *(*uintptr)(unsafe.Pointer(pptail))
-			return *(*uintptr)(unsafe.Pointer(ppstmt)), nil
+			pstmt := *(*uintptr)(unsafe.Pointer(ppstmt))
+			if pstmt != 0 {
+				c.openStmts++
+				c.prepares++
+			}
+			return pstmt, nil
 		case sqliteLockedSharedcache:
 			if err := c.retry(0); err != nil {
 				return 0, err
 			}
+		case sqlite3.SQLITE_SCHEMA:
+			// The schema was changed by another connection while preparing.
+			// SQLite has reloaded it by now, so try once more.
+			if schemaRetried {
+				return 0, c.errstr(rc)
+			}
+
+			schemaRetried = true
 		default:
 			return 0, c.errstr(rc)
 		}
 	}
 }
 
+// const char *sqlite3_db_filename(sqlite3 *db, const char *zDbName);
+//
+// The result is empty for in-memory and temporary databases.
+func (c *conn) fileName(schema string) string {
+	p, err := libc.CString(schema)
+	if err != nil {
+		return ""
+	}
+
+	defer c.free(p)
+	return libc.GoString(sqlite3.Xsqlite3_db_filename(c.tls, c.db, p))
+}
+
+// int sqlite3_file_control(sqlite3*, const char *zDbName, int op, void*);
+//
+// fileControlInt64 passes a pointer to v as the argument and returns the value
+// it points to afterwards.
+func (c *conn) fileControlInt64(schema string, op int32, v int64) (int64, error) {
+	zDb, err := libc.CString(schema)
+	if err != nil {
+		return 0, err
+	}
+
+	defer c.free(zDb)
+
+	p, err := c.malloc(8)
+	if err != nil {
+		return 0, err
+	}
+
+	defer c.free(p)
+
+	*(*int64)(unsafe.Pointer(p)) = v
+	if rc := sqlite3.Xsqlite3_file_control(c.tls, c.db, zDb, op, p); rc != sqlite3.SQLITE_OK {
+		return 0, c.errstr(rc)
+	}
+
+	return *(*int64)(unsafe.Pointer(p)), nil
+}
+
+// setMemDBMaxSize limits the size of the in-memory main database to n bytes,
+// see MemDBMaxSize.
+func (c *conn) setMemDBMaxSize(n int64) error {
+	_, err := c.fileControlInt64("main", sqlite3.SQLITE_FCNTL_SIZE_LIMIT, n)
+	if e, ok := err.(*Error); !ok || e.code != sqlite3.SQLITE_NOTFOUND {
+		return err
+	}
+
+	// Not the memdb VFS.
+	if c.fileName("main") != "" {
+		return fmt.Errorf("sqlite: MemDBMaxSize: the main database is not in memory")
+	}
+
+	pageSize, err := c.queryInt64("pragma page_size")
+	if err != nil {
+		return err
+	}
+
+	pages := n / pageSize
+	if pages < 1 {
+		pages = 1
+	}
+	_, err = c.exec(context.Background(), fmt.Sprintf("pragma max_page_count = %d", pages), nil)
+	return err
+}
+
+// const char *sqlite3_db_name(sqlite3 *db, int N);
+func (c *conn) schemaNames() (r []string) {
+	for i := int32(0); ; i++ {
+		p := sqlite3.Xsqlite3_db_name(c.tls, c.db, i)
+		if p == 0 {
+			return r
+		}
+
+		r = append(r, libc.GoString(p))
+	}
+}
+
+// int sqlite3_stmt_status(sqlite3_stmt*, int op,int resetFlg);
+func (c *conn) stmtStatus(pstmt uintptr, op int32, reset bool) int {
+	return int(sqlite3.Xsqlite3_stmt_status(c.tls, pstmt, op, libc.Bool32(reset)))
+}
+
+// int sqlite3_stmt_busy(sqlite3_stmt*);
+func (c *conn) stmtBusy(pstmt uintptr) bool {
+	return sqlite3.Xsqlite3_stmt_busy(c.tls, pstmt) != 0
+}
+
+// int sqlite3_stmt_readonly(sqlite3_stmt *pStmt);
+func (c *conn) stmtReadonly(pstmt uintptr) bool {
+	return sqlite3.Xsqlite3_stmt_readonly(c.tls, pstmt) != 0
+}
+
+// isReadOnly reports whether all statements in query leave the database
+// unchanged.
+func (c *conn) isReadOnly(query string) (bool, error) {
+	psql, err := libc.CString(query)
+	if err != nil {
+		return false, err
+	}
+
+	defer c.free(psql)
+
+	for pzTail := psql; *(*byte)(unsafe.Pointer(pzTail)) != 0; {
+		pstmt, err := c.prepareV2(&pzTail)
+		if err != nil {
+			return false, err
+		}
+
+		if pstmt == 0 {
+			continue
+		}
+
+		ro := c.stmtReadonly(pstmt)
+		if err := c.finalize(pstmt); err != nil {
+			return false, err
+		}
+
+		if !ro {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// StatementIsReadOnly reports whether query, which may consist of multiple
+// statements, makes no direct changes to the database when executed on c.
+// The statements are prepared but not executed. A layer splitting reads and
+// writes can use it to route read-only statements to a replica.
+//
+// Note that per SQLite, transaction control statements such as BEGIN or
+// COMMIT are read-only. See https://www.sqlite.org/c3ref/stmt_readonly.html
+// for details.
+func StatementIsReadOnly(c *sql.Conn, query string) (ro bool, err error) {
+	err = withConn(c, func(c *conn) error {
+		ro, err = c.isReadOnly(query)
+		return err
+	})
+	return ro, err
+}
+
 // void sqlite3_interrupt(sqlite3*);
 func (c *conn) interrupt(pdb uintptr) (err error) {
 	c.Lock() // Defend against race with .Close invoked by context handling.
@@ -1366,6 +2398,12 @@ func (c *conn) free(p uintptr) {
 	}
 }
 
+// busyHint is appended to the SQLITE_BUSY errors of the connections whose
+// busy timeout the DSN set to zero, which fail as soon as they find the
+// database locked. Connections that chose not to wait with SetBusyTimeout do
+// not get it.
+const busyHint = "; the busy timeout is 0, so the connection does not wait for locks, consider a nonzero _pragma=busy_timeout(ms) DSN parameter"
+
 // const char *sqlite3_errstr(int);
 func (c *conn) errstr(rc int32) error {
 	p := sqlite3.Xsqlite3_errstr(c.tls, rc)
@@ -1374,6 +2412,9 @@ func (c *conn) errstr(rc int32) error {
 	var s string
 	if rc == sqlite3.SQLITE_BUSY {
 		s = " (SQLITE_BUSY)"
+		if c.busyTimeout == 0 && !c.busyTimeoutChosen {
+			s += busyHint
+		}
 	}
 	switch msg := libc.GoString(p); {
 	case msg == str:
@@ -1383,6 +2424,13 @@ func (c *conn) errstr(rc int32) error {
 	}
 }
 
+// errcode returns the error for the result code rc of a failure detected by
+// the driver, which, unlike errstr, does not include the message of the
+// connection's last error.
+func (c *conn) errcode(rc int32) error {
+	return &Error{msg: fmt.Sprintf("%s (%v)", libc.GoString(sqlite3.Xsqlite3_errstr(c.tls, rc)), rc), code: int(rc)}
+}
+
 // Begin starts a transaction.
 //
 // Deprecated: Drivers should implement ConnBeginTx instead (or additionally).
@@ -1391,7 +2439,7 @@ func (c *conn) Begin() (driver.Tx, error) {
 }
 
 func (c *conn) begin(ctx context.Context, opts driver.TxOptions) (t driver.Tx, err error) {
-	return newTx(c, opts)
+	return newTx(ctx, c, opts)
 }
 
 // Close invalidates and potentially stops any current prepared statements and
@@ -1406,11 +2454,29 @@ func (c *conn) Close() error {
 	defer c.Unlock()
 
 	if c.db != 0 {
+		c.finishBackups()
+		if c.connector != nil {
+			c.connector.release(c)
+			c.connector = nil
+		}
+
 		if err := c.closeV2(c.db); err != nil {
 			return err
 		}
 
 		c.db = 0
+		if c.authorizer != 0 {
+			deleteHandle(c.authorizer)
+			c.authorizer = 0
+		}
+		if c.progress.h != 0 {
+			deleteHandle(c.progress.h)
+			c.progress.h = 0
+		}
+		if c.trace != 0 {
+			deleteHandle(c.trace)
+			c.trace = 0
+		}
 	}
 
 	if c.tls != nil {
@@ -1420,43 +2486,473 @@ func (c *conn) Close() error {
 	return nil
 }
 
-// int sqlite3_close_v2(sqlite3*);
-func (c *conn) closeV2(db uintptr) error {
-	if rc := sqlite3.Xsqlite3_close_v2(c.tls, db); rc != sqlite3.SQLITE_OK {
-		return c.errstr(rc)
+// refreshSchema makes the connection re-read the database schema if it was
+// changed by another connection. Reading sqlite_master verifies the schema
+// cookie, which discards the cached schema when it is stale.
+func (c *conn) refreshSchema() error {
+	_, err := c.exec(context.Background(), "select count(*) from sqlite_master", nil)
+	return err
+}
+
+// ConnStats reports statistics of a connection as returned by Stats.
+type ConnStats struct {
+	OpenStatements int   // prepared statements not finalized yet
+	Prepares       int64 // statements compiled, see PrepareAll
+	TotalSteps     int64 // virtual machine steps of the finalized statements
+	TotalChanges   int64 // rows inserted, updated or deleted
+}
+
+func (c *conn) stats() ConnStats {
+	return ConnStats{
+		OpenStatements: c.openStmts,
+		Prepares:       c.prepares,
+		TotalSteps:     c.totalSteps,
+		TotalChanges:   int64(sqlite3.Xsqlite3_total_changes64(c.tls, c.db)) - c.changesBase,
 	}
+}
 
-	return nil
+func (c *conn) resetStats() {
+	c.prepares = 0
+	c.totalSteps = 0
+	c.changesBase = int64(sqlite3.Xsqlite3_total_changes64(c.tls, c.db))
 }
 
-type userDefinedFunction struct {
-	zFuncName uintptr
-	nArg      int32
-	eTextRep  int32
-	xFunc     func(*libc.TLS, uintptr, int32, uintptr)
+// Stats returns the statistics of the connection c since it was opened or
+// ResetStats was last called on it. OpenStatements helps asserting that no
+// statements leak: it drops back to zero once all rows and prepared
+// statements used on c are closed. Statements run internally by the
+// connection count too.
+func Stats(c *sql.Conn) (r ConnStats, err error) {
+	err = withConn(c, func(c *conn) error {
+		r = c.stats()
+		return nil
+	})
+	return r, err
+}
 
-	freeOnce sync.Once
+// ResetStats resets the Prepares, TotalSteps and TotalChanges reported by
+// Stats for the connection c to zero. OpenStatements is not affected.
+func ResetStats(c *sql.Conn) error {
+	return withConn(c, func(c *conn) error {
+		c.resetStats()
+		return nil
+	})
 }
 
-func (c *conn) createFunctionInternal(fun *userDefinedFunction) error {
-	if rc := sqlite3.Xsqlite3_create_function(
-		c.tls,
-		c.db,
-		fun.zFuncName,
-		fun.nArg,
-		fun.eTextRep,
-		0,
-		*(*uintptr)(unsafe.Pointer(&fun.xFunc)),
-		0,
-		0,
-	); rc != sqlite3.SQLITE_OK {
-		return c.errstr(rc)
-	}
-	return nil
+// RefreshSchema makes the connection c re-read the database schema after it
+// was changed externally, for example by another process, so that the
+// change is picked up before the next statement is prepared.
+func RefreshSchema(c *sql.Conn) error {
+	return withConn(c, (*conn).refreshSchema)
 }
 
-// Execer is an optional interface that may be implemented by a Conn.
-//
+// QueryVMSteps runs query with args on the connection c, reading all the
+// rows it returns, and reports the number of virtual machine steps SQLite
+// executed, as counted by SQLITE_STMTSTATUS_VM_STEP. Unlike the wall time,
+// the count does not depend on the hardware or load, so it is a
+// deterministic measure for comparing the cost of query variants, eg. with
+// and without an index.
+func QueryVMSteps(ctx context.Context, c *sql.Conn, query string, args ...interface{}) (n int64, err error) {
+	if err := withConn(c, func(c *conn) error {
+		c.countVMSteps, c.vmSteps = true, 0
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	// Runs after rows.Close has finalized the statement.
+	defer withConn(c, func(c *conn) error {
+		if err == nil {
+			n = c.vmSteps
+		}
+		c.countVMSteps, c.vmSteps = false, 0
+		return nil
+	})
+
+	rows, err := c.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, rows.Close()
+}
+
+// SchemaNames returns the names of the databases open on the connection c:
+// "main", "temp" and the names of the attached databases.
+func SchemaNames(c *sql.Conn) (r []string, err error) {
+	err = withConn(c, func(c *conn) error {
+		r = c.schemaNames()
+		return nil
+	})
+	return r, err
+}
+
+// schemaVersionQuery returns the schema_version of the main database.
+func (c *conn) schemaVersionQuery() (v int64, err error) {
+	return c.queryInt64("pragma schema_version")
+}
+
+// queryInt64 returns the first column of the first row of query, which must
+// produce at least one row.
+func (c *conn) queryInt64(query string) (v int64, err error) {
+	psql, err := libc.CString(query)
+	if err != nil {
+		return 0, err
+	}
+
+	defer c.free(psql)
+
+	pzTail := psql
+	pstmt, err := c.prepareV2(&pzTail)
+	if err != nil {
+		return 0, err
+	}
+
+	defer c.finalize(pstmt)
+
+	rc, err := c.step(pstmt)
+	if err != nil {
+		return 0, err
+	}
+
+	if rc != sqlite3.SQLITE_ROW {
+		return 0, c.errstr(int32(rc))
+	}
+
+	return c.columnInt64(pstmt, 0)
+}
+
+// checkSchemaChange calls the schema change hook if the statement pstmt,
+// which was just executed, may have changed the schema and the schema
+// version differs from the last one seen.
+func (c *conn) checkSchemaChange(pstmt uintptr) {
+	if c.schemaChangeHook == nil || c.stmtReadonly(pstmt) {
+		return
+	}
+
+	v, err := c.schemaVersionQuery()
+	if err != nil || v == c.schemaVersion {
+		return
+	}
+
+	c.schemaVersion = v
+	c.schemaChangeHook()
+}
+
+func (c *conn) setSchemaChangeHook(f func()) (err error) {
+	if f != nil {
+		if c.schemaVersion, err = c.schemaVersionQuery(); err != nil {
+			return err
+		}
+	}
+
+	c.schemaChangeHook = f
+	return nil
+}
+
+// RegisterSchemaChangeHook registers f to be called on the connection c
+// after a statement executed on c changed the database schema, eg. by CREATE,
+// DROP or ALTER, so that caches of prepared statements or schema metadata can
+// be invalidated. A nil f removes the hook.
+//
+// Changes are detected by comparing PRAGMA schema_version after every
+// statement that is not read-only, which adds a small cost to such
+// statements. A schema change made by another connection is reported with
+// the next write on c. f must not use c.
+func RegisterSchemaChangeHook(c *sql.Conn, f func()) error {
+	return withConn(c, func(c *conn) error { return c.setSchemaChangeHook(f) })
+}
+
+// SchemaVersion returns PRAGMA schema_version of the main database of c.
+// SQLite increments it whenever any connection changes the schema, so an
+// application caching prepared statements or schema metadata per connection
+// can remember the version it saw when filling the cache and discard the
+// cache when SchemaVersion reports a different one, eg. before reusing a
+// cached statement after another connection ran CREATE, DROP or ALTER.
+// Unlike RegisterSchemaChangeHook, it also observes changes made by other
+// connections without a write on c.
+func SchemaVersion(c *sql.Conn) (v int, err error) {
+	err = withConn(c, func(c *conn) error {
+		n, err := c.schemaVersionQuery()
+		v = int(n)
+		return err
+	})
+	return v, err
+}
+
+// int sqlite3_busy_timeout(sqlite3*, int ms);
+func (c *conn) setBusyTimeout(d time.Duration) (prev time.Duration, err error) {
+	// SQLite keeps the timeout, which PRAGMA busy_timeout reports, but
+	// sqlite3_busy_timeout does not return the previous one.
+	ms, err := c.queryInt64("pragma busy_timeout")
+	if err != nil {
+		return 0, err
+	}
+
+	// SQLite takes an int of milliseconds.
+	n := d / time.Millisecond
+	switch {
+	case n < 0:
+		n = 0
+	case n > math.MaxInt32:
+		n = math.MaxInt32
+	}
+	if rc := sqlite3.Xsqlite3_busy_timeout(c.tls, c.db, int32(n)); rc != sqlite3.SQLITE_OK {
+		return 0, c.errstr(rc)
+	}
+
+	c.busyTimeout, c.busyTimeoutChosen = n*time.Millisecond, true
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// int sqlite3_db_config(sqlite3*, SQLITE_DBCONFIG_LOOKASIDE, void *pBuf, int sz, int cnt);
+func (c *conn) setLookaside(size, count int) error {
+	va := libc.NewVaList(uintptr(0), int32(size), int32(count))
+	if va == 0 {
+		return fmt.Errorf("sqlite: cannot allocate memory")
+	}
+
+	defer c.free(va)
+
+	if rc := sqlite3.Xsqlite3_db_config(c.tls, c.db, sqlite3.SQLITE_DBCONFIG_LOOKASIDE, va); rc != sqlite3.SQLITE_OK {
+		return c.errstr(rc)
+	}
+
+	return nil
+}
+
+// SetBusyTimeout sets the time the connection c waits for a lock held by
+// another connection before failing with SQLITE_BUSY, rounded down to
+// milliseconds and capped at math.MaxInt32 milliseconds, about 24.8 days. A
+// zero or negative d disables waiting. It returns the
+// previous timeout, which includes one set by PRAGMA busy_timeout, so that
+// it can be restored after a single operation:
+//
+//	prev, err := sqlite.SetBusyTimeout(c, time.Minute)
+//	if err != nil {
+//		return err
+//	}
+//
+//	defer sqlite.SetBusyTimeout(c, prev)
+//
+// New connections start with a 5 second timeout.
+func SetBusyTimeout(c *sql.Conn, d time.Duration) (prev time.Duration, err error) {
+	err = withConn(c, func(c *conn) error {
+		prev, err = c.setBusyTimeout(d)
+		return err
+	})
+	return prev, err
+}
+
+// resetDatabase empties the main database of c, see ResetDatabase.
+//
+//	int sqlite3_db_config(sqlite3*, int op, ...);
+func (c *conn) resetDatabase() (err error) {
+	if err = c.setDBConfig(sqlite3.SQLITE_DBCONFIG_RESET_DATABASE, 1); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err2 := c.setDBConfig(sqlite3.SQLITE_DBCONFIG_RESET_DATABASE, 0); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+
+	_, err = c.exec(context.Background(), "vacuum", nil)
+	return err
+}
+
+// setDBConfig sets the boolean sqlite3_db_config option op.
+func (c *conn) setDBConfig(op, v int32) error {
+	va := libc.NewVaList(v, uintptr(0))
+	if va == 0 {
+		return fmt.Errorf("sqlite: cannot allocate memory")
+	}
+
+	defer c.free(va)
+
+	if rc := sqlite3.Xsqlite3_db_config(c.tls, c.db, op, va); rc != sqlite3.SQLITE_OK {
+		return c.errstr(rc)
+	}
+
+	return nil
+}
+
+// ResetDatabase deletes the whole content and schema of the main database of
+// the connection c, keeping the file and the connection open, eg. to recreate
+// a corrupt database in place. It performs the sequence documented for
+// SQLITE_DBCONFIG_RESET_DATABASE:
+//
+//	sqlite3_db_config(db, SQLITE_DBCONFIG_RESET_DATABASE, 1, 0);
+//	sqlite3_exec(db, "VACUUM", 0, 0, 0);
+//	sqlite3_db_config(db, SQLITE_DBCONFIG_RESET_DATABASE, 0, 0);
+//
+// The data cannot be recovered afterwards. It fails if c is in a transaction
+// or other connections use the database.
+func ResetDatabase(c *sql.Conn) error {
+	return withConn(c, func(c *conn) error { return c.resetDatabase() })
+}
+
+// SeedRandom makes the pseudo-random number generator of SQLite, used by
+// random(), randomblob() and for temporary file names, restart from a state
+// derived from seed, so that a test gets the same random values on every run.
+// A zero seed restores the default, unpredictable, seeding by the VFS.
+//
+// The generator is global to the process and shared by all connections, so
+// the sequence a connection sees is only reproducible if no other connection
+// uses it concurrently. SeedRandom is meant for tests, never seed the
+// generator in production.
+//
+//	sqlite3_test_control(SQLITE_TESTCTRL_PRNG_SEED, int x, sqlite3 *db);
+func SeedRandom(seed int32) error {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	va := libc.NewVaList(seed, uintptr(0))
+	if va == 0 {
+		return fmt.Errorf("sqlite: cannot allocate memory")
+	}
+
+	defer libc.Xfree(tls, va)
+
+	if rc := sqlite3.Xsqlite3_test_control(tls, sqlite3.SQLITE_TESTCTRL_PRNG_SEED, va); rc != sqlite3.SQLITE_OK {
+		return fmt.Errorf("sqlite: SeedRandom: %s", libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+
+	return nil
+}
+
+// withConn calls f with the driver connection underlying c.
+func withConn(c *sql.Conn, f func(*conn) error) error {
+	return c.Raw(func(driverConn interface{}) error {
+		dc, ok := driverConn.(*conn)
+		if !ok {
+			return fmt.Errorf("sqlite: unexpected driver connection type %T", driverConn)
+		}
+
+		return f(dc)
+	})
+}
+
+// int sqlite3_close_v2(sqlite3*);
+func (c *conn) closeV2(db uintptr) error {
+	if rc := sqlite3.Xsqlite3_close_v2(c.tls, db); rc != sqlite3.SQLITE_OK {
+		return c.errstr(rc)
+	}
+
+	return nil
+}
+
+// functionKey identifies a user defined function. SQLite keeps the functions
+// of the same name but different numbers of arguments apart.
+type functionKey struct {
+	name string
+	nArg int32
+}
+
+// errFunctionRegistered reports a duplicate registration of a function.
+func errFunctionRegistered(k functionKey) error {
+	if k.nArg < 0 {
+		return fmt.Errorf("a variadic function named %q is already registered", k.name)
+	}
+
+	return fmt.Errorf("a function named %q with %d arguments is already registered", k.name, k.nArg)
+}
+
+type userDefinedFunction struct {
+	zFuncName uintptr // owned by the registry of the function, SQLite copies it
+	nArg      int32
+	eTextRep  int32
+	xFunc     func(*libc.TLS, uintptr, int32, uintptr)
+
+	// xStep and xFinal are set, instead of xFunc, for aggregate
+	// functions, window functions set also xValue and xInverse.
+	xStep    func(*libc.TLS, uintptr, int32, uintptr)
+	xFinal   func(*libc.TLS, uintptr)
+	xValue   func(*libc.TLS, uintptr)
+	xInverse func(*libc.TLS, uintptr, int32, uintptr)
+}
+
+func (c *conn) createFunctionInternal(fun *userDefinedFunction) error {
+	if fun.xValue != nil {
+		return c.createWindowFunction(fun)
+	}
+
+	// The handle keeps fun, and so xFunc, alive until SQLite destroys the
+	// function, when it is replaced or the connection is closed. SQLite
+	// copies the name.
+	if rc := sqlite3.Xsqlite3_create_function_v2(
+		c.tls,
+		c.db,
+		fun.zFuncName,
+		fun.nArg,
+		fun.eTextRep,
+		newHandle(fun),
+		*(*uintptr)(unsafe.Pointer(&fun.xFunc)),
+		*(*uintptr)(unsafe.Pointer(&fun.xStep)),
+		*(*uintptr)(unsafe.Pointer(&fun.xFinal)),
+		*(*uintptr)(unsafe.Pointer(&struct {
+			f func(*libc.TLS, uintptr)
+		}{destroyFunction})),
+	); rc != sqlite3.SQLITE_OK {
+		return c.errstr(rc)
+	}
+	return nil
+}
+
+// int sqlite3_create_window_function(
+//
+//	sqlite3 *db,
+//	const char *zFunctionName,
+//	int nArg,
+//	int eTextRep,
+//	void *pApp,
+//	void (*xStep)(sqlite3_context*,int,sqlite3_value**),
+//	void (*xFinal)(sqlite3_context*),
+//	void (*xValue)(sqlite3_context*),
+//	void (*xInverse)(sqlite3_context*,int,sqlite3_value**),
+//	void(*xDestroy)(void*)
+//
+// );
+func (c *conn) createWindowFunction(fun *userDefinedFunction) error {
+	// See createFunctionInternal.
+	if rc := sqlite3.Xsqlite3_create_window_function(
+		c.tls,
+		c.db,
+		fun.zFuncName,
+		fun.nArg,
+		fun.eTextRep,
+		newHandle(fun),
+		*(*uintptr)(unsafe.Pointer(&fun.xStep)),
+		*(*uintptr)(unsafe.Pointer(&fun.xFinal)),
+		*(*uintptr)(unsafe.Pointer(&fun.xValue)),
+		*(*uintptr)(unsafe.Pointer(&fun.xInverse)),
+		*(*uintptr)(unsafe.Pointer(&struct {
+			f func(*libc.TLS, uintptr)
+		}{destroyFunction})),
+	); rc != sqlite3.SQLITE_OK {
+		return c.errstr(rc)
+	}
+	return nil
+}
+
+// destroyFunction is the xDestroy callback of the functions created by
+// createFunctionInternal and createWindowFunction. SQLite calls it also when
+// creating the function fails.
+func destroyFunction(tls *libc.TLS, h uintptr) {
+	deleteHandle(h)
+}
+
+// Execer is an optional interface that may be implemented by a Conn.
+//
 // If a Conn does not implement Execer, the sql package's DB.Exec will first
 // prepare a query, execute the statement, and then close the statement.
 //
@@ -1464,7 +2960,7 @@ func (c *conn) createFunctionInternal(fun *userDefinedFunction) error {
 //
 // Deprecated: Drivers should implement ExecerContext instead.
 func (c *conn) Exec(query string, args []driver.Value) (driver.Result, error) {
-	return c.exec(context.Background(), query, toNamedValues(args))
+	return c.ExecContext(context.Background(), query, toNamedValues(args))
 }
 
 func (c *conn) exec(ctx context.Context, query string, args []driver.NamedValue) (r driver.Result, err error) {
@@ -1487,9 +2983,20 @@ func (c *conn) Prepare(query string) (driver.Stmt, error) {
 	return c.prepare(context.Background(), query)
 }
 
-func (c *conn) prepare(ctx context.Context, query string) (s driver.Stmt, err error) {
-	//TODO use ctx
-	return newStmt(c, query)
+func (c *conn) prepare(ctx context.Context, query string) (driver.Stmt, error) {
+	s, err := newStmt(c, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx != nil && ctx.Value(precompileKey{}) != nil {
+		if err := s.precompile(); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
 }
 
 // Queryer is an optional interface that may be implemented by a Conn.
@@ -1501,7 +3008,7 @@ func (c *conn) prepare(ctx context.Context, query string) (s driver.Stmt, err er
 //
 // Deprecated: Drivers should implement QueryerContext instead.
 func (c *conn) Query(query string, args []driver.Value) (driver.Rows, error) {
-	return c.query(context.Background(), query, toNamedValues(args))
+	return c.QueryContext(context.Background(), query, toNamedValues(args))
 }
 
 func (c *conn) query(ctx context.Context, query string, args []driver.NamedValue) (r driver.Rows, err error) {
@@ -1522,10 +3029,15 @@ func (c *conn) query(ctx context.Context, query string, args []driver.NamedValue
 // Driver implements database/sql/driver.Driver.
 type Driver struct {
 	// user defined functions that are added to every new connection on Open
-	udfs map[string]*userDefinedFunction
+	udfs map[functionKey]*userDefinedFunction
+	// virtual table modules that are added to every new connection on Open
+	modules map[string]*module
 }
 
-var d = &Driver{udfs: make(map[string]*userDefinedFunction)}
+var d = &Driver{
+	udfs:    make(map[functionKey]*userDefinedFunction),
+	modules: make(map[string]*module),
+}
 
 func newDriver() *Driver { return d }
 
@@ -1551,19 +3063,59 @@ func newDriver() *Driver { return d }
 // database. Currently the only supported value is "sqlite", which corresponds
 // to format 7 from https://www.sqlite.org/lang_datefunc.html#time_values,
 // including the timezone specifier. If this parameter is not specified, then
-// the default String() format will be used.
+// the default format "2006-01-02 15:04:05.999999999-07:00" will be used. It
+// keeps nanosecond precision, so scanning a time written this way from a
+// DATE, DATETIME or TIMESTAMP column reproduces it exactly.
 //
 // _txlock: The locking behavior to use when beginning a transaction. May be
 // "deferred", "immediate", or "exclusive" (case insensitive). The default is to
 // not specify one, which SQLite maps to "deferred". More information is
 // available at
 // https://www.sqlite.org/lang_transaction.html#deferred_immediate_and_exclusive_transactions
+//
+// cache: "shared" or "private" selects the cache mode of the connection. mode:
+// "ro", "rw", "rwc" or "memory" opens the database read-only, read-write,
+// read-write creating it if it does not exist (the default), or as an
+// in-memory database. These parameters have the same meaning as in SQLite URI
+// filenames, https://www.sqlite.org/uri.html, and are honored for plain file
+// names as well.
+//
+// A read-heavy service can open the same file using "?cache=shared&mode=ro".
+// All such connections of the process, even those of different *sql.DB
+// handles, then share one page cache, which saves memory and keeps the pages
+// read by one connection warm for the others. Note that connections sharing a
+// cache use table level locking among themselves, so shared cache should not
+// be combined with writers.
+//
+// A name like "file:name?mode=memory" opens the in-memory database called
+// name. Unless the cache query parameter is given, all connections opening
+// the same name share one database, so it works with the connection pool of
+// database/sql, while different names are distinct databases. Other
+// connections can attach it using the same URI, eg. "ATTACH
+// 'file:name?mode=memory' AS other". The database is deleted when its last
+// connection is closed. Names ":memory:" and "file::memory:" keep opening a
+// new private database per connection.
 func (d *Driver) Open(name string) (driver.Conn, error) {
-	c, err := newConn(name)
+	return d.open(&connector{driver: d, dsn: name})
+}
+
+// OpenConnector implements driver.DriverContext. The name has the same format
+// as the one accepted by Open.
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	return &connector{driver: d, dsn: name}, nil
+}
+
+func (d *Driver) open(cn *connector) (driver.Conn, error) {
+	c, err := newConn(cn.dsn, cn.openFlags())
 	if err != nil {
 		return nil, err
 	}
 
+	if err = cn.configure(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+
 	for _, udf := range d.udfs {
 		if err = c.createFunctionInternal(udf); err != nil {
 			c.Close()
@@ -1571,6 +3123,23 @@ func (d *Driver) Open(name string) (driver.Conn, error) {
 		}
 	}
 
+	for _, mod := range d.modules {
+		if err = c.createModuleInternal(mod); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if cn.registry != nil {
+		if err = cn.registry.install(c); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	c.connector = cn
+	cn.acquire(c)
+
 	if LogSqlStatements {
 		log.Println("new connection")
 	}
@@ -1580,12 +3149,145 @@ func (d *Driver) Open(name string) (driver.Conn, error) {
 
 // FunctionContext represents the context user defined functions execute in.
 // Fields and/or methods of this type may get addedd in the future.
-type FunctionContext struct{}
+type FunctionContext struct {
+	tls     *libc.TLS
+	ctx     uintptr
+	argc    int32
+	argv    uintptr
+	subtype uint
+}
+
+func newFunctionContext(tls *libc.TLS, ctx uintptr, argc int32, argv uintptr) *FunctionContext {
+	return &FunctionContext{tls: tls, ctx: ctx, argc: argc, argv: argv}
+}
+
+// Value returns the i-th argument of the function call as a Value. It
+// exposes properties of the argument lost by the conversion to driver.Value,
+// like the storage class of a TEXT argument holding a number.
+//
+// The Value is valid only until the function returns.
+func (ctx *FunctionContext) Value(i int) Value {
+	if i < 0 || i >= int(ctx.argc) {
+		panic(fmt.Sprintf("sqlite: function argument index out of range: %d", i))
+	}
+
+	return Value{tls: ctx.tls, p: *(*uintptr)(unsafe.Pointer(ctx.argv + uintptr(i)*sqliteValPtrSize))}
+}
+
+// SetResultSubtype sets the subtype of the value returned by the function to
+// subtype, which SQLite keeps only in its lower 8 bits. Subtype-aware
+// functions, like the JSON functions for the subtype 'J' (74), receiving the
+// result as an argument can act on it, see Value.Subtype.
+//
+// void sqlite3_result_subtype(sqlite3_context*,unsigned int);
+func (ctx *FunctionContext) SetResultSubtype(subtype uint) {
+	ctx.subtype = subtype
+}
+
+// ValueType is the fundamental datatype of a Value.
+type ValueType int
+
+// ValueType constants.
+const (
+	ValueInteger ValueType = sqlite3.SQLITE_INTEGER
+	ValueFloat   ValueType = sqlite3.SQLITE_FLOAT
+	ValueText    ValueType = sqlite3.SQLITE_TEXT
+	ValueBlob    ValueType = sqlite3.SQLITE_BLOB
+	ValueNull    ValueType = sqlite3.SQLITE_NULL
+)
+
+// String implements fmt.Stringer.
+func (t ValueType) String() string {
+	switch t {
+	case ValueInteger:
+		return "INTEGER"
+	case ValueFloat:
+		return "FLOAT"
+	case ValueText:
+		return "TEXT"
+	case ValueBlob:
+		return "BLOB"
+	case ValueNull:
+		return "NULL"
+	default:
+		return fmt.Sprintf("ValueType(%d)", int(t))
+	}
+}
+
+// Value is an argument of a user defined function call, or a result column
+// returned by ColumnValue, an sqlite3_value. Passed as an argument to Exec or
+// Query, it is bound by sqlite3_bind_value.
+type Value struct {
+	tls *libc.TLS
+	p   uintptr
+}
+
+// Type returns the datatype of v.
+//
+// int sqlite3_value_type(sqlite3_value*);
+func (v Value) Type() ValueType {
+	return ValueType(sqlite3.Xsqlite3_value_type(v.tls, v.p))
+}
+
+// NumericType returns the datatype of v after applying NUMERIC affinity to
+// it, ie. ValueInteger or ValueFloat for a TEXT value that looks like a
+// number. It may convert v in place, so that Type reports the converted
+// datatype afterwards.
+//
+// int sqlite3_value_numeric_type(sqlite3_value*);
+func (v Value) NumericType() ValueType {
+	return ValueType(sqlite3.Xsqlite3_value_numeric_type(v.tls, v.p))
+}
+
+// Pointer returns the value bound by BindPointer with the type tag typ, or
+// nil if v is not such a pointer.
+//
+// void *sqlite3_value_pointer(sqlite3_value*, const char*);
+func (v Value) Pointer(typ string) interface{} {
+	t, err := pointerType(typ)
+	if err != nil {
+		return nil
+	}
+
+	h := sqlite3.Xsqlite3_value_pointer(v.tls, v.p, t)
+	if h == 0 {
+		return nil
+	}
+
+	return handleValue(h)
+}
+
+// Subtype returns the subtype of v, eg. 'J' (74) for the values returned by
+// the JSON functions, or 0.
+//
+// unsigned int sqlite3_value_subtype(sqlite3_value*);
+func (v Value) Subtype() uint {
+	return uint(sqlite3.Xsqlite3_value_subtype(v.tls, v.p))
+}
 
 const sqliteValPtrSize = unsafe.Sizeof(&sqlite3.Sqlite3_value{})
 
 // RegisterScalarFunction registers a scalar function named zFuncName with nArg
-// arguments. Passing -1 for nArg indicates the function is variadic.
+// arguments. Passing -1 for nArg indicates the function is variadic. Functions
+// of the same name may be registered for different nArg, SQLite calls the one
+// matching the number of arguments of the call, or else the variadic one.
+//
+// The arguments are passed as int64, float64, string, []byte or nil for NULL,
+// use FunctionContext.Value to inspect them further. The value returned by
+// xFunc becomes the result of the function:
+//
+//	nil                      NULL
+//	int64, bool              INTEGER
+//	time.Time                INTEGER, the Unix time in seconds
+//	float64                  REAL
+//	string                   TEXT
+//	[]byte                   BLOB, empty (not NULL) for a nil or empty slice
+//	AsBlob(s), AsText(b)     BLOB, TEXT
+//	NullableBlob(b)          BLOB, NULL for a nil slice
+//	driver.Valuer            the result of its Value method, eg. NULL for
+//	                         an invalid sql.NullString
+//
+// Any other type is an error.
 //
 // The new function will be available to all new connections opened after
 // executing RegisterScalarFunction.
@@ -1643,8 +3345,9 @@ func registerScalarFunction(
 	xFunc func(ctx *FunctionContext, args []driver.Value) (driver.Value, error),
 ) error {
 
-	if _, ok := d.udfs[zFuncName]; ok {
-		return fmt.Errorf("a function named %q is already registered", zFuncName)
+	k := functionKey{zFuncName, nArg}
+	if _, ok := d.udfs[k]; ok {
+		return errFunctionRegistered(k)
 	}
 
 	// dont free, functions registered on the driver live as long as the program
@@ -1658,86 +3361,144 @@ func registerScalarFunction(
 		nArg:      nArg,
 		eTextRep:  eTextRep,
 		xFunc: func(tls *libc.TLS, ctx uintptr, argc int32, argv uintptr) {
-			setErrorResult := func(res error) {
-				errmsg, cerr := libc.CString(res.Error())
-				if cerr != nil {
-					panic(cerr)
-				}
-				defer libc.Xfree(tls, errmsg)
-				sqlite3.Xsqlite3_result_error(tls, ctx, errmsg, -1)
-				sqlite3.Xsqlite3_result_error_code(tls, ctx, sqlite3.SQLITE_ERROR)
-			}
+			callFunction(tls, ctx, argc, argv, xFunc)
+		},
+	}
+	d.udfs[k] = udf
 
-			args := make([]driver.Value, argc)
-			for i := int32(0); i < argc; i++ {
-				valPtr := *(*uintptr)(unsafe.Pointer(argv + uintptr(i)*sqliteValPtrSize))
-
-				switch valType := sqlite3.Xsqlite3_value_type(tls, valPtr); valType {
-				case sqlite3.SQLITE_TEXT:
-					args[i] = libc.GoString(sqlite3.Xsqlite3_value_text(tls, valPtr))
-				case sqlite3.SQLITE_INTEGER:
-					args[i] = sqlite3.Xsqlite3_value_int64(tls, valPtr)
-				case sqlite3.SQLITE_FLOAT:
-					args[i] = sqlite3.Xsqlite3_value_double(tls, valPtr)
-				case sqlite3.SQLITE_NULL:
-					args[i] = nil
-				case sqlite3.SQLITE_BLOB:
-					size := sqlite3.Xsqlite3_value_bytes(tls, valPtr)
-					blobPtr := sqlite3.Xsqlite3_value_blob(tls, valPtr)
-					v := make([]byte, size)
-					copy(v, (*libc.RawMem)(unsafe.Pointer(blobPtr))[:size:size])
-					args[i] = v
-				default:
-					panic(fmt.Sprintf("unexpected argument type %q passed by sqlite", valType))
-				}
-			}
+	return nil
+}
 
-			res, err := xFunc(&FunctionContext{}, args)
-			if err != nil {
-				setErrorResult(err)
-				return
-			}
+// callFunction calls xFunc with the argc arguments at argv and sets its
+// result, or error, as the result of the function call ctx.
+func callFunction(tls *libc.TLS, ctx uintptr, argc int32, argv uintptr, xFunc func(*FunctionContext, []driver.Value) (driver.Value, error)) {
+	fc := newFunctionContext(tls, ctx, argc, argv)
+	fc.setResult(xFunc(fc, functionArgs(tls, argc, argv)))
+}
 
-			switch resTyped := res.(type) {
-			case nil:
-				sqlite3.Xsqlite3_result_null(tls, ctx)
-			case int64:
-				sqlite3.Xsqlite3_result_int64(tls, ctx, resTyped)
-			case float64:
-				sqlite3.Xsqlite3_result_double(tls, ctx, resTyped)
-			case bool:
-				sqlite3.Xsqlite3_result_int(tls, ctx, libc.Bool32(resTyped))
-			case time.Time:
-				sqlite3.Xsqlite3_result_int64(tls, ctx, resTyped.Unix())
-			case string:
-				size := int32(len(resTyped))
-				cstr, err := libc.CString(resTyped)
-				if err != nil {
-					panic(err)
-				}
-				defer libc.Xfree(tls, cstr)
-				sqlite3.Xsqlite3_result_text(tls, ctx, cstr, size, sqlite3.SQLITE_TRANSIENT)
-			case []byte:
-				size := int32(len(resTyped))
-				if size == 0 {
-					sqlite3.Xsqlite3_result_zeroblob(tls, ctx, 0)
-					return
-				}
-				p := libc.Xmalloc(tls, types.Size_t(size))
-				if p == 0 {
-					panic(fmt.Sprintf("unable to allocate space for blob: %d", size))
-				}
-				defer libc.Xfree(tls, p)
-				copy((*libc.RawMem)(unsafe.Pointer(p))[:size:size], resTyped)
+// setResult sets res, with the subtype set by SetResultSubtype, or err as the
+// result of the function call.
+func (ctx *FunctionContext) setResult(res driver.Value, err error) {
+	setErrorResult := errorResultFunction(ctx.tls, ctx.ctx)
+	if err != nil {
+		setErrorResult(err)
+		return
+	}
 
-				sqlite3.Xsqlite3_result_blob(tls, ctx, p, size, sqlite3.SQLITE_TRANSIENT)
-			default:
-				setErrorResult(fmt.Errorf("function did not return a valid driver.Value: %T", resTyped))
-				return
-			}
-		},
+	if err := functionReturnValue(ctx.tls, ctx.ctx, res); err != nil {
+		setErrorResult(err)
+		return
+	}
+
+	if ctx.subtype != 0 {
+		sqlite3.Xsqlite3_result_subtype(ctx.tls, ctx.ctx, uint32(ctx.subtype))
+	}
+}
+
+func errorResultFunction(tls *libc.TLS, ctx uintptr) func(error) {
+	return func(res error) {
+		errmsg, cerr := libc.CString(res.Error())
+		if cerr != nil {
+			panic(cerr)
+		}
+		defer libc.Xfree(tls, errmsg)
+		sqlite3.Xsqlite3_result_error(tls, ctx, errmsg, -1)
+		sqlite3.Xsqlite3_result_error_code(tls, ctx, sqlite3.SQLITE_ERROR)
+	}
+}
+
+// functionArgs converts the argc sqlite3_value pointers at argv to Go values.
+func functionArgs(tls *libc.TLS, argc int32, argv uintptr) []driver.Value {
+	args := make([]driver.Value, argc)
+	for i := int32(0); i < argc; i++ {
+		valPtr := *(*uintptr)(unsafe.Pointer(argv + uintptr(i)*sqliteValPtrSize))
+
+		switch valType := sqlite3.Xsqlite3_value_type(tls, valPtr); valType {
+		case sqlite3.SQLITE_TEXT:
+			args[i] = libc.GoString(sqlite3.Xsqlite3_value_text(tls, valPtr))
+		case sqlite3.SQLITE_INTEGER:
+			args[i] = sqlite3.Xsqlite3_value_int64(tls, valPtr)
+		case sqlite3.SQLITE_FLOAT:
+			args[i] = sqlite3.Xsqlite3_value_double(tls, valPtr)
+		case sqlite3.SQLITE_NULL:
+			args[i] = nil
+		case sqlite3.SQLITE_BLOB:
+			size := sqlite3.Xsqlite3_value_bytes(tls, valPtr)
+			blobPtr := sqlite3.Xsqlite3_value_blob(tls, valPtr)
+			v := make([]byte, size)
+			copy(v, (*libc.RawMem)(unsafe.Pointer(blobPtr))[:size:size])
+			args[i] = v
+		default:
+			panic(fmt.Sprintf("unexpected argument type %q passed by sqlite", valType))
+		}
+	}
+
+	return args
+}
+
+// functionReturnValue sets res as the result of the function call ctx.
+func functionReturnValue(tls *libc.TLS, ctx uintptr, res driver.Value) error {
+	switch resTyped := res.(type) {
+	case nil:
+		sqlite3.Xsqlite3_result_null(tls, ctx)
+	case int64:
+		sqlite3.Xsqlite3_result_int64(tls, ctx, resTyped)
+	case float64:
+		sqlite3.Xsqlite3_result_double(tls, ctx, resTyped)
+	case bool:
+		sqlite3.Xsqlite3_result_int(tls, ctx, libc.Bool32(resTyped))
+	case time.Time:
+		sqlite3.Xsqlite3_result_int64(tls, ctx, resTyped.Unix())
+	case string:
+		if len(resTyped) > math.MaxInt32 {
+			sqlite3.Xsqlite3_result_error_toobig(tls, ctx)
+			return nil
+		}
+
+		size := int32(len(resTyped))
+		cstr, err := libc.CString(resTyped)
+		if err != nil {
+			panic(err)
+		}
+		defer libc.Xfree(tls, cstr)
+		sqlite3.Xsqlite3_result_text(tls, ctx, cstr, size, sqlite3.SQLITE_TRANSIENT)
+	case []byte:
+		if len(resTyped) > math.MaxInt32 {
+			sqlite3.Xsqlite3_result_error_toobig(tls, ctx)
+			return nil
+		}
+
+		size := int32(len(resTyped))
+		if size == 0 {
+			sqlite3.Xsqlite3_result_zeroblob(tls, ctx, 0)
+			return nil
+		}
+		p := libc.Xmalloc(tls, types.Size_t(size))
+		if p == 0 {
+			panic(fmt.Sprintf("unable to allocate space for blob: %d", size))
+		}
+		defer libc.Xfree(tls, p)
+		copy((*libc.RawMem)(unsafe.Pointer(p))[:size:size], resTyped)
+
+		sqlite3.Xsqlite3_result_blob(tls, ctx, p, size, sqlite3.SQLITE_TRANSIENT)
+	case blobArg:
+		return functionReturnValue(tls, ctx, []byte(resTyped))
+	case textArg:
+		return functionReturnValue(tls, ctx, string(resTyped))
+	case driver.Valuer:
+		v, err := valuerValue(resTyped)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := v.(driver.Valuer); ok {
+			return fmt.Errorf("function returned a driver.Valuer %T returning a driver.Valuer %T", resTyped, v)
+		}
+
+		return functionReturnValue(tls, ctx, v)
+	default:
+		return fmt.Errorf("function did not return a valid driver.Value: %T", resTyped)
 	}
-	d.udfs[zFuncName] = udf
 
 	return nil
 }
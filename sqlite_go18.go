@@ -14,7 +14,7 @@ import (
 
 // Ping implements driver.Pinger
 func (c *conn) Ping(ctx context.Context) error {
-	_, err := c.ExecContext(ctx, "select 1", nil)
+	_, err := c.exec(ctx, "select 1", nil)
 	return err
 }
 
@@ -30,20 +30,36 @@ func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, e
 
 // ExecContext implements driver.ExecerContext
 func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.audit != nil {
+		return c.auditExec(query, args, func() (driver.Result, error) { return c.exec(ctx, query, args) })
+	}
+
 	return c.exec(ctx, query, args)
 }
 
 // QueryContext implements driver.QueryerContext
 func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.audit != nil {
+		return c.auditQuery(query, args, func() (driver.Rows, error) { return c.query(ctx, query, args) })
+	}
+
 	return c.query(ctx, query, args)
 }
 
 // ExecContext implements driver.StmtExecContext
 func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if s.c.audit != nil {
+		return s.c.auditExec(s.sql, args, func() (driver.Result, error) { return s.exec(ctx, args) })
+	}
+
 	return s.exec(ctx, args)
 }
 
 // QueryContext implements driver.StmtQueryContext
 func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if s.c.audit != nil {
+		return s.c.auditQuery(s.sql, args, func() (driver.Rows, error) { return s.query(ctx, args) })
+	}
+
 	return s.query(ctx, args)
 }
@@ -0,0 +1,129 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// activeStatements records the statements being executed by the connections
+// of the process that track them, see ActiveStatements.
+var activeStatements = struct {
+	sync.Mutex
+	m    map[int64]*StatementInfo
+	last int64
+}{
+	m: map[int64]*StatementInfo{},
+}
+
+// StatementInfo describes a statement being executed, as reported by
+// ActiveStatements.
+type StatementInfo struct {
+	// SQL is the text passed to Exec or Query, which may consist of several
+	// statements.
+	SQL string
+	// Start is the time the execution started.
+	Start time.Time
+
+	id int64
+	c  *conn
+}
+
+// Elapsed returns the time elapsed since the execution started.
+func (s StatementInfo) Elapsed() time.Duration {
+	return time.Since(s.Start)
+}
+
+// Cancel interrupts the statement, if it is still being executed, making it
+// fail with SQLITE_INTERRUPT like a canceled context does. Interrupting works
+// by sqlite3_interrupt, so it interrupts also the other statements running on
+// the same connection, eg. those of the sql.Rows being iterated concurrently.
+// A statement that has not started running yet is not interrupted.
+func (s StatementInfo) Cancel() {
+	activeStatements.Lock()
+	defer activeStatements.Unlock()
+
+	if _, ok := activeStatements.m[s.id]; ok {
+		s.c.interrupt(s.c.db)
+	}
+}
+
+// ActiveStatements returns the statements being executed by the connections
+// of the process opened with the TrackStatements option, oldest first. A
+// statement executed by Exec is active until Exec returns, one executed by
+// Query until its rows are closed.
+func ActiveStatements() []StatementInfo {
+	activeStatements.Lock()
+	defer activeStatements.Unlock()
+
+	r := make([]StatementInfo, 0, len(activeStatements.m))
+	for _, v := range activeStatements.m {
+		r = append(r, *v)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].id < r[j].id })
+	return r
+}
+
+// beginStatement records that c started to execute sql and returns the id
+// to pass to endStatement when it is done, which is zero if c does not track
+// its statements.
+func (c *conn) beginStatement(sql string) int64 {
+	if !c.trackStatements {
+		return 0
+	}
+
+	activeStatements.Lock()
+	defer activeStatements.Unlock()
+
+	activeStatements.last++
+	id := activeStatements.last
+	activeStatements.m[id] = &StatementInfo{SQL: sql, Start: time.Now(), id: id, c: c}
+	return id
+}
+
+func endStatement(id int64) {
+	if id == 0 {
+		return
+	}
+
+	activeStatements.Lock()
+	delete(activeStatements.m, id)
+	activeStatements.Unlock()
+}
+
+// precompileKey is the context key making conn.prepare compile the statement,
+// see PrepareAll.
+type precompileKey struct{}
+
+// PrepareAll prepares queries on the connection c and returns the statements
+// in the same order. Unlike the statements prepared by c.PrepareContext, which
+// are compiled anew by every execution, those consisting of a single SQL
+// statement are compiled once, right away, and kept compiled until closed, so
+// an application running a fixed set of queries pays the parsing and planning
+// costs only at startup. A statement executed again while its rows are still
+// open is compiled anew for that execution. SQLite recompiles a kept statement
+// by itself after a schema change.
+//
+// On error, the statements prepared so far are closed.
+func PrepareAll(ctx context.Context, c *sql.Conn, queries []string) ([]*sql.Stmt, error) {
+	ctx = context.WithValue(ctx, precompileKey{}, true)
+	r := make([]*sql.Stmt, 0, len(queries))
+	for _, v := range queries {
+		s, err := c.PrepareContext(ctx, v)
+		if err != nil {
+			for _, s := range r {
+				s.Close()
+			}
+			return nil, err
+		}
+
+		r = append(r, s)
+	}
+	return r, nil
+}
@@ -0,0 +1,211 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActiveStatements(t *testing.T) {
+	cn, err := NewConnector(":memory:", TrackStatements(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(cn)
+	defer db.Close()
+
+	find := func(query string) (StatementInfo, bool) {
+		for _, v := range ActiveStatements() {
+			if v.SQL == query {
+				return v, true
+			}
+		}
+		return StatementInfo{}, false
+	}
+
+	const slow = "with recursive c(x) as (select 1 union all select x+1 from c) select count(*) from c -- TestActiveStatements"
+	errs := make(chan error, 1)
+	go func() {
+		var n int
+		errs <- db.QueryRow(slow).Scan(&n)
+	}()
+
+	// Keep canceling until the query, which may not have started running
+	// yet, returns.
+	deadline := time.After(10 * time.Second)
+	for done := false; !done; {
+		select {
+		case err := <-errs:
+			if err == nil || !strings.Contains(err.Error(), "interrupted") {
+				t.Fatalf("got %v, want an interrupted error", err)
+			}
+			done = true
+		case <-time.After(10 * time.Millisecond):
+			if s, ok := find(slow); ok {
+				if s.Start.IsZero() || s.Elapsed() <= 0 {
+					t.Fatalf("unexpected %+v", s)
+				}
+
+				s.Cancel()
+			}
+		case <-deadline:
+			t.Fatal("query not canceled")
+		}
+	}
+
+	if _, ok := find(slow); ok {
+		t.Fatal("canceled query still active")
+	}
+
+	const query = "select 1 -- TestActiveStatements"
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := find(query); !ok {
+		t.Fatal("query with open rows not active")
+	}
+
+	rows.Close()
+	if _, ok := find(query); ok {
+		t.Fatal("query with closed rows still active")
+	}
+
+	// Connections without the option are not tracked.
+	db2, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db2.Close()
+
+	if rows, err = db2.Query(query); err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	if _, ok := find(query); ok {
+		t.Fatal("untracked query active")
+	}
+}
+
+func TestPrepareAll(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if _, err := c.ExecContext(ctx, "create table t(i)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := PrepareAll(ctx, c, []string{"select 1", "select * from nonexistent"}); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	stmts, err := PrepareAll(ctx, c, []string{"insert into t values(?)", "select i from t where i > ? order by i"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insert, query := stmts[0], stmts[1]
+	if err := ResetStats(c); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err := insert.ExecContext(ctx, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sum := func() (n int) {
+		rows, err := query.QueryContext(ctx, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			var i int
+			if err := rows.Scan(&i); err != nil {
+				t.Fatal(err)
+			}
+
+			n += i
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		return n
+	}
+
+	for i := 0; i < 3; i++ {
+		if g, e := sum(), 5+6+7+8+9; g != e {
+			t.Fatalf("got %v, want %v", g, e)
+		}
+	}
+
+	stats, err := Stats(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Prepares != 0 || stats.OpenStatements != 2 {
+		t.Fatalf("unexpected %+v", stats)
+	}
+
+	// Nested executions of the same statement and a schema change.
+	rows, err := query.QueryContext(ctx, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := sum(), 5+6+7+8+9; g != e {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	rows.Close()
+	if _, err := c.ExecContext(ctx, "create index x on t(i)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := sum(), 5+6+7+8+9; g != e {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	for _, v := range stmts {
+		if err := v.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stats, err = Stats(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.OpenStatements != 0 {
+		t.Fatalf("unexpected %+v", stats)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// streamArg is the argument returned by BindStream.
+type streamArg struct {
+	column string
+	r      io.Reader
+	size   int64
+}
+
+// BindStream returns an argument for Exec that stores exactly size bytes read
+// from r as a BLOB in column without buffering them in memory. The statement
+// inserts, or updates column to, a zeroblob of the size and the driver then
+// copies r into it through an incremental blob handle, all within a
+// savepoint, so the change is rolled back if reading r fails or r has fewer
+// than size bytes.
+//
+//	_, err := db.Exec("insert into t(name, data) values(?, ?)", name, sqlite.BindStream("data", f, size))
+//
+// The statement must change exactly one row of a rowid table, and column must
+// name the column the argument is stored in. Exec fails, and the change is
+// rolled back, if column of the changed row does not hold a BLOB of the size.
+// Query rejects the argument.
+func BindStream(column string, r io.Reader, size int64) interface{} {
+	return streamArg{column, r, size}
+}
+
+// errStreamQuery is returned by Query for BindStream arguments.
+var errStreamQuery = errors.New("sqlite: BindStream arguments are supported only by Exec")
+
+// boundStreams returns the BindStream arguments bound to params.
+func boundStreams(params []bindParam, args []driver.NamedValue) (r []streamArg) {
+	for _, p := range params {
+		if j := p.argIndex(args, nil, nil); j >= 0 {
+			if x, ok := args[j].Value.(streamArg); ok {
+				r = append(r, x)
+			}
+		}
+	}
+	return r
+}
+
+// int sqlite3_bind_zeroblob64(sqlite3_stmt*, int, sqlite3_uint64);
+func (c *conn) bindZeroBlob(pstmt uintptr, idx1 int, n int64) error {
+	if n < 0 {
+		return fmt.Errorf("sqlite: invalid BindStream size %d", n)
+	}
+
+	if rc := sqlite3.Xsqlite3_bind_zeroblob64(c.tls, pstmt, int32(idx1), sqlite3.Sqlite3_uint64(n)); rc != sqlite3.SQLITE_OK {
+		return c.errstr(rc)
+	}
+
+	return nil
+}
+
+// rowChange is a row change reported by the update hook.
+type rowChange struct {
+	schema, table string
+	rowid         int64
+	n             int
+}
+
+// stepStreams steps pstmt, which has streams bound, and copies the streams to
+// the row it changes. The statement and the copying are wrapped in a
+// savepoint.
+func (c *conn) stepStreams(pstmt uintptr, streams []streamArg) (rc int, err error) {
+	if _, err = c.exec(context.Background(), "savepoint bind_stream", nil); err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err != nil {
+			c.exec(context.Background(), "rollback to bind_stream", nil)
+		}
+		if _, err2 := c.exec(context.Background(), "release bind_stream", nil); err2 != nil && err == nil {
+			err = err2
+		}
+	}()
+
+	var change rowChange
+	h := newHandle(&change)
+	defer deleteHandle(h)
+
+	sqlite3.Xsqlite3_update_hook(c.tls, c.db, *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, int32, uintptr, uintptr, int64)
+	}{streamUpdateHook})), h)
+	rc, err = c.step(pstmt)
+	sqlite3.Xsqlite3_update_hook(c.tls, c.db, 0, 0)
+	if err != nil {
+		return rc, err
+	}
+
+	switch rc & 0xff {
+	case sqlite3.SQLITE_DONE, sqlite3.SQLITE_ROW:
+		// nop
+	default:
+		return rc, c.errstr(int32(rc))
+	}
+
+	if change.n != 1 {
+		return rc, fmt.Errorf("sqlite: BindStream: the statement changed %d rows, want 1", change.n)
+	}
+
+	if err := c.checkStreamColumns(change, streams); err != nil {
+		return rc, err
+	}
+
+	for _, v := range streams {
+		if err := c.writeStream(change, v); err != nil {
+			return rc, err
+		}
+	}
+
+	return rc, nil
+}
+
+// streamUpdateHook is the sqlite3_update_hook callback installed by
+// stepStreams.
+func streamUpdateHook(tls *libc.TLS, h uintptr, op int32, zDb, zTab uintptr, rowid int64) {
+	change := handleValue(h).(*rowChange)
+	if op == sqlite3.SQLITE_DELETE {
+		return
+	}
+
+	change.schema = libc.GoString(zDb)
+	change.table = libc.GoString(zTab)
+	change.rowid = rowid
+	change.n++
+}
+
+// checkStreamColumns verifies that the columns named by streams hold, in the
+// changed row, the zeroblobs bound for them, so that a stream bound to one
+// column never overwrites another.
+func (c *conn) checkStreamColumns(change rowChange, streams []streamArg) error {
+	used := map[string]bool{}
+	for _, v := range streams {
+		if v.column == "" {
+			return fmt.Errorf("sqlite: BindStream: missing column name")
+		}
+
+		if used[v.column] {
+			return fmt.Errorf("sqlite: BindStream: several streams for column %s", v.column)
+		}
+
+		used[v.column] = true
+		var ok []string
+		if err := c.queryStrings(
+			&ok,
+			fmt.Sprintf("select 1 from %s.%s where rowid = ? and typeof(%[3]s) = 'blob' and length(%[3]s) = ?", QuoteIdentifier(change.schema), QuoteIdentifier(change.table), QuoteIdentifier(v.column)),
+			change.rowid, v.size,
+		); err != nil {
+			return fmt.Errorf("sqlite: BindStream: %v", err)
+		}
+
+		if len(ok) == 0 {
+			return fmt.Errorf("sqlite: BindStream: column %s of the changed row of %s does not hold a BLOB of size %d", v.column, change.table, v.size)
+		}
+	}
+	return nil
+}
+
+// queryStrings appends to dst the first column, as text, of the rows returned
+// by query.
+func (c *conn) queryStrings(dst *[]string, query string, args ...driver.Value) error {
+	r, err := c.query(context.Background(), query, toNamedValues(args))
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	dest := []driver.Value{nil}
+	for {
+		switch err := r.Next(dest); err {
+		case nil:
+			*dst = append(*dst, fmt.Sprint(dest[0]))
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// streamBufferSize is the size of the chunks writeStream copies.
+const streamBufferSize = 1 << 16
+
+// writeStream copies v to its column of the changed row.
+func (c *conn) writeStream(change rowChange, v streamArg) error {
+	if v.size > math.MaxInt32 {
+		return c.errcode(sqlite3.SQLITE_TOOBIG)
+	}
+
+	blob, err := c.blobOpen(change.schema, change.table, v.column, change.rowid, true)
+	if err != nil {
+		return err
+	}
+
+	defer sqlite3.Xsqlite3_blob_close(c.tls, blob)
+
+	buf, err := c.malloc(streamBufferSize)
+	if err != nil {
+		return err
+	}
+
+	defer c.free(buf)
+
+	b := (*libc.RawMem)(unsafe.Pointer(buf))[:streamBufferSize:streamBufferSize]
+	for off := int64(0); off < v.size; {
+		n := v.size - off
+		if n > streamBufferSize {
+			n = streamBufferSize
+		}
+
+		if _, err := io.ReadFull(v.r, b[:n]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("sqlite: BindStream: %v", err)
+		}
+
+		if rc := sqlite3.Xsqlite3_blob_write(c.tls, blob, buf, int32(n), int32(off)); rc != sqlite3.SQLITE_OK {
+			return c.errstr(rc)
+		}
+
+		off += n
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// streamPattern is an io.Reader of a repeating byte pattern.
+type streamPattern struct{ off int64 }
+
+func (r *streamPattern) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = byte(r.off % 251)
+		r.off++
+	}
+	return len(b), nil
+}
+
+func TestBindStream(t *testing.T) {
+	db, err := sql.Open(driverName, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	if _, err := db.Exec("create table t(name text, a blob, b blob)"); err != nil {
+		t.Fatal(err)
+	}
+
+	const size = 50 << 20
+	if _, err := db.Exec("insert into t(name, b) values(?, ?)", "big", BindStream("b", &streamPattern{}, size)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := sha256.New()
+	io.CopyN(want, &streamPattern{}, size)
+	var got []byte
+	if err := db.QueryRow("select b from t where name = 'big'").Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != size {
+		t.Fatalf("got %v bytes", len(got))
+	}
+
+	h := sha256.Sum256(got)
+	if !bytes.Equal(h[:], want.Sum(nil)) {
+		t.Fatal("stream corrupted")
+	}
+
+	// Two streams, one of them replacing a value by an update.
+	if _, err := db.Exec(
+		"update t set a = ?, b = ? where name = 'big'",
+		BindStream("a", strings.NewReader("abc"), 3), BindStream("b", strings.NewReader("defg"), 4),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	var a, b string
+	if err := db.QueryRow("select a, b from t").Scan(&a, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	if a != "abc" || b != "defg" {
+		t.Fatalf("got %q, %q", a, b)
+	}
+
+	// Another column holding a BLOB of the same size is left alone.
+	if _, err := db.Exec("update t set b = ? where name = 'big'", BindStream("b", strings.NewReader("xyz"), 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.QueryRow("select a, b from t").Scan(&a, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	if a != "abc" || b != "xyz" {
+		t.Fatalf("got %q, %q", a, b)
+	}
+
+	// The named column must receive the argument.
+	for _, v := range []string{"", "a", "nosuch"} {
+		if _, err := db.Exec("update t set b = ? where name = 'big'", BindStream(v, strings.NewReader("uvwx"), 4)); err == nil {
+			t.Fatalf("column %q: unexpected success", v)
+		}
+	}
+
+	if err := db.QueryRow("select a, b from t").Scan(&a, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	if a != "abc" || b != "xyz" {
+		t.Fatalf("got %q, %q", a, b)
+	}
+
+	// A short reader rolls the insert back.
+	if _, err := db.Exec("insert into t(name, a) values('short', ?)", BindStream("a", strings.NewReader("abc"), 4)); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	var n int
+	if err := db.QueryRow("select count(*) from t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Fatalf("got %v rows, want 1", n)
+	}
+
+	if _, err := db.Query("select ?", BindStream("a", strings.NewReader("abc"), 3)); err == nil {
+		t.Fatal("unexpected success")
+	}
+}
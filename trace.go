@@ -0,0 +1,122 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Trace event types, to be combined into the mask of RegisterTrace.
+const (
+	TraceStmt    uint = sqlite3.SQLITE_TRACE_STMT    // a statement starts running
+	TraceProfile uint = sqlite3.SQLITE_TRACE_PROFILE // a statement finished
+	TraceRow     uint = sqlite3.SQLITE_TRACE_ROW     // a statement produced a row
+	TraceClose   uint = sqlite3.SQLITE_TRACE_CLOSE   // the connection closes
+)
+
+// TraceEvent is an event reported to the callback of RegisterTrace.
+type TraceEvent struct {
+	Type uint // TraceStmt, TraceProfile, TraceRow or TraceClose
+	// SQL is the text of the statement with its parameters replaced by the
+	// bound values, or, for a TraceStmt event of a statement run by a
+	// trigger, a comment naming the trigger. It is empty for TraceClose.
+	SQL string
+	// Duration is the time the statement took to run, for TraceProfile.
+	Duration time.Duration
+}
+
+// traceFunc is the callback of RegisterTrace.
+type traceFunc func(TraceEvent)
+
+// int sqlite3_trace_v2(
+//
+//	sqlite3*,
+//	unsigned uMask,
+//	int(*xCallback)(unsigned,void*,void*,void*),
+//	void *pCtx
+//
+// );
+func (c *conn) setTrace(mask uint, fn traceFunc) error {
+	var xCallback, h uintptr
+	if fn != nil && mask != 0 {
+		xCallback = *(*uintptr)(unsafe.Pointer(&struct {
+			f func(*libc.TLS, uint32, uintptr, uintptr, uintptr) int32
+		}{traceCallback}))
+		h = newHandle(fn)
+	} else {
+		mask = 0
+	}
+
+	if rc := sqlite3.Xsqlite3_trace_v2(c.tls, c.db, uint32(mask), xCallback, h); rc != sqlite3.SQLITE_OK {
+		if h != 0 {
+			deleteHandle(h)
+		}
+		return c.errstr(rc)
+	}
+
+	if c.trace != 0 {
+		deleteHandle(c.trace)
+	}
+	c.trace = h
+	return nil
+}
+
+// traceCallback is the xCallback installed by setTrace.
+func traceCallback(tls *libc.TLS, typ uint32, h, p, x uintptr) int32 {
+	fn := handleValue(h).(traceFunc)
+	ev := TraceEvent{Type: uint(typ)}
+	switch typ {
+	case sqlite3.SQLITE_TRACE_STMT:
+		if s := libc.GoString(x); strings.HasPrefix(s, "--") {
+			ev.SQL = s
+			break
+		}
+
+		ev.SQL = expandedSQL(tls, p)
+	case sqlite3.SQLITE_TRACE_PROFILE:
+		ev.SQL = expandedSQL(tls, p)
+		ev.Duration = time.Duration(*(*int64)(unsafe.Pointer(x)))
+	case sqlite3.SQLITE_TRACE_ROW:
+		ev.SQL = expandedSQL(tls, p)
+	}
+	fn(ev)
+	return 0
+}
+
+// expandedSQL returns the SQL text of pstmt with its parameters expanded.
+//
+// char *sqlite3_expanded_sql(sqlite3_stmt *pStmt);
+func expandedSQL(tls *libc.TLS, pstmt uintptr) string {
+	p := sqlite3.Xsqlite3_expanded_sql(tls, pstmt)
+	if p == 0 {
+		return ""
+	}
+
+	defer sqlite3.Xsqlite3_free(tls, p)
+
+	return libc.GoString(p)
+}
+
+// RegisterTrace makes the connection c call fn for the events whose types are
+// set in mask, a combination of TraceStmt, TraceProfile, TraceRow and
+// TraceClose, eg. to log the executed statements and their durations:
+//
+//	err := sqlite.RegisterTrace(c, sqlite.TraceProfile, func(ev sqlite.TraceEvent) {
+//		log.Printf("%s: %v", ev.SQL, ev.Duration)
+//	})
+//
+// The callback replaces the previous one, and a nil fn, or a zero mask,
+// removes it, so tracing can be turned on and off at any time. It also
+// replaces the logging enabled by LogSqlStatements. fn is called on the
+// goroutine executing the statement and must not use c.
+func RegisterTrace(c *sql.Conn, mask uint, fn func(TraceEvent)) error {
+	return withConn(c, func(c *conn) error { return c.setTrace(mask, fn) })
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestRegisterTrace(t *testing.T) {
+	db, err := sql.Open(driverName, "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if _, err := c.ExecContext(ctx, "create table t(i, s)"); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []TraceEvent
+	if err := RegisterTrace(c, TraceStmt|TraceProfile|TraceRow|TraceClose, func(ev TraceEvent) {
+		events = append(events, ev)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.ExecContext(ctx, "insert into t values(?, ?)", 42, "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := len(events), 2; g != e {
+		t.Fatalf("got %v events, want %v: %+v", g, e, events)
+	}
+
+	const want = "insert into t values(42, 'x')"
+	if ev := events[0]; ev.Type != TraceStmt || ev.SQL != want {
+		t.Fatalf("got %+v, want a TraceStmt event of %q", ev, want)
+	}
+
+	if ev := events[1]; ev.Type != TraceProfile || ev.SQL != want || ev.Duration < 0 {
+		t.Fatalf("got %+v, want a TraceProfile event of %q", ev, want)
+	}
+
+	events = nil
+	rows, err := c.QueryContext(ctx, "select i from t union all select 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for rows.Next() {
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	for _, ev := range events {
+		if ev.Type == TraceRow {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Fatalf("got %v TraceRow events, want 2: %+v", n, events)
+	}
+
+	if err := RegisterTrace(c, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	events = nil
+	if _, err := c.ExecContext(ctx, "delete from t"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 0 {
+		t.Fatalf("got events after removal: %+v", events)
+	}
+
+	if err := RegisterTrace(c, TraceClose, func(ev TraceEvent) {
+		events = append(events, ev)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+	db.Close()
+	if len(events) != 1 || events[0].Type != TraceClose {
+		t.Fatalf("got %+v, want a TraceClose event", events)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+)
+
+// UUID is a UUID stored as a 16 byte BLOB, which takes less than half the
+// space of its text form and compares by the bytes. A UUID argument is bound
+// as a BLOB, so it matches the values of a column storing UUIDs this way:
+//
+//	var id sqlite.UUID
+//	err := db.QueryRow("select id from t where name = ?", name).Scan(&id)
+//	...
+//	err = db.QueryRow("select name from t where id = ?", id).Scan(&name)
+//
+// Scan also accepts the text form, eg. "9be4398c-d527-4efb-93a4-fc532cbaf804",
+// so that columns holding either form can be read. Use String to bind the
+// text form instead. A [16]byte argument is bound as a BLOB as well.
+type UUID [16]byte
+
+// ParseUUID parses the text form of a UUID, 32 hexadecimal digits grouped as
+// 8-4-4-4-12 by hyphens, optionally enclosed in braces.
+func ParseUUID(s string) (u UUID, err error) {
+	if len(s) == 38 && s[0] == '{' && s[37] == '}' {
+		s = s[1:37]
+	}
+
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("sqlite: invalid UUID %q", s)
+	}
+
+	b := make([]byte, 0, 32)
+	b = append(b, s[:8]...)
+	b = append(b, s[9:13]...)
+	b = append(b, s[14:18]...)
+	b = append(b, s[19:23]...)
+	b = append(b, s[24:]...)
+	if _, err := hex.Decode(u[:], b); err != nil {
+		return UUID{}, fmt.Errorf("sqlite: invalid UUID %q", s)
+	}
+
+	return u, nil
+}
+
+// String returns the text form of u in lower case.
+func (u UUID) String() string {
+	var b [36]byte
+	hex.Encode(b[:8], u[:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
+
+// Value implements driver.Valuer.
+func (u UUID) Value() (driver.Value, error) { return u[:], nil }
+
+// Scan implements sql.Scanner. It accepts a 16 byte BLOB or the text form of
+// a UUID. Scanning a NULL is an error, scan nullable columns into a *UUID
+// variable.
+func (u *UUID) Scan(src interface{}) (err error) {
+	switch x := src.(type) {
+	case []byte:
+		if len(x) == len(u) {
+			copy(u[:], x)
+			return nil
+		}
+
+		*u, err = ParseUUID(string(x))
+		return err
+	case string:
+		*u, err = ParseUUID(x)
+		return err
+	default:
+		return fmt.Errorf("sqlite: cannot scan %T into %T", src, u)
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (u UUID) MarshalText() ([]byte, error) { return []byte(u.String()), nil }
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (u *UUID) UnmarshalText(b []byte) (err error) {
+	*u, err = ParseUUID(string(b))
+	return err
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestUUID(t *testing.T) {
+	const s = "9be4398c-d527-4efb-93a4-fc532cbaf804"
+	id, err := ParseUUID(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g := id.String(); g != s {
+		t.Fatalf("got %q, want %q", g, s)
+	}
+
+	if _, err := ParseUUID("9be4398c-d527-4efb-93a4-fc532cbaf8zz"); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	db, err := sql.Open(driverName, "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	if _, err := db.Exec("create table t(id blob primary key, name text); insert into t values(?, 'a'), (?, 'b'), (?, 'c')",
+		id, [16]byte{1}, s,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	var typ string
+	var n int
+	if err := db.QueryRow("select typeof(id), length(id) from t where name = 'a'").Scan(&typ, &n); err != nil {
+		t.Fatal(err)
+	}
+
+	if typ != "blob" || n != 16 {
+		t.Fatalf("got %s of length %d, want a 16 byte blob", typ, n)
+	}
+
+	var name string
+	if err := db.QueryRow("select name from t where id = ?", id).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "a" {
+		t.Fatalf("got %q, want %q", name, "a")
+	}
+
+	for _, tt := range []struct {
+		name string
+		want UUID
+	}{
+		{"a", id},
+		{"b", UUID{1}},
+		{"c", id}, // text form
+	} {
+		var got UUID
+		if err := db.QueryRow("select id from t where name = ?", tt.name).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	var p *UUID
+	if err := db.QueryRow("select null").Scan(&p); err != nil {
+		t.Fatal(err)
+	}
+
+	if p != nil {
+		t.Fatalf("got %v, want nil", p)
+	}
+}
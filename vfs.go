@@ -0,0 +1,633 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"modernc.org/libc"
+	"modernc.org/libc/sys/types"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var openHooks struct {
+	sync.Mutex
+	hooks []func(name string) (vfs string, ok bool)
+}
+
+// RegisterOpenHook registers hook to select the VFS of the databases opened
+// afterwards without a vfs query parameter in their DSN. The hook gets the
+// file name of the DSN, without the query, and returns the name of the VFS
+// to use and true, or false to leave the choice to the next hook and finally
+// to SQLite, which uses the default VFS. The hooks are consulted in the order
+// of registration and must be safe for concurrent use.
+//
+//	sqlite.RegisterOpenHook(func(name string) (string, bool) {
+//		if strings.HasPrefix(name, "s3://") {
+//			return "s3", true
+//		}
+//
+//		return "", false
+//	})
+func RegisterOpenHook(hook func(name string) (vfs string, ok bool)) {
+	openHooks.Lock()
+	defer openHooks.Unlock()
+
+	openHooks.hooks = append(openHooks.hooks, hook)
+}
+
+// openHookVFS returns the VFS selected by the open hooks for name, if any.
+func openHookVFS(name string) string {
+	openHooks.Lock()
+	hooks := openHooks.hooks
+	openHooks.Unlock()
+
+	for _, hook := range hooks {
+		if vfs, ok := hook(name); ok {
+			return vfs
+		}
+	}
+	return ""
+}
+
+// RegisteredVFSes returns the names of the registered VFSes, the default one
+// first, eg. to debug a "no such vfs" error.
+func RegisteredVFSes() []string {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	// sqlite3_vfs_find initializes the library, so that the VFSes of the
+	// platform are registered.
+	pVfs := sqlite3.Xsqlite3_vfs_find(tls, 0)
+	mu := sqlite3.Xsqlite3_mutex_alloc(tls, sqlite3.SQLITE_MUTEX_STATIC_MAIN)
+	sqlite3.Xsqlite3_mutex_enter(tls, mu)
+	defer sqlite3.Xsqlite3_mutex_leave(tls, mu)
+
+	var r []string
+	for ; pVfs != 0; pVfs = (*sqlite3.Sqlite3_vfs)(unsafe.Pointer(pVfs)).FpNext {
+		r = append(r, libc.GoString((*sqlite3.Sqlite3_vfs)(unsafe.Pointer(pVfs)).FzName))
+	}
+	return r
+}
+
+// VFSExists reports whether a VFS named name is registered.
+//
+//	sqlite3_vfs *sqlite3_vfs_find(const char *zVfsName);
+func VFSExists(name string) bool {
+	zName, err := libc.CString(name)
+	if err != nil {
+		return false
+	}
+
+	tls := libc.NewTLS()
+	defer func() {
+		libc.Xfree(tls, zName)
+		tls.Close()
+	}()
+
+	return sqlite3.Xsqlite3_vfs_find(tls, zName) != 0
+}
+
+// Operations of the files of a passthrough VFS reported to VFSHooks.
+const (
+	VFSOpen     = iota // The file is opened.
+	VFSClose           // The file is closed.
+	VFSRead            // Len(Data) bytes are read at Offset.
+	VFSWrite           // Len(Data) bytes are written at Offset.
+	VFSTruncate        // The file is truncated to Offset bytes.
+	VFSSync            // The file is synced.
+	VFSFileSize        // The size of the file is queried.
+	VFSDelete          // The file is deleted.
+)
+
+// VFSEvent describes an operation of a passthrough VFS, see VFSHooks.
+type VFSEvent struct {
+	Op     int    // One of the VFS* operations.
+	Name   string // Name of the file, empty for temporary files.
+	Offset int64  // Offset of VFSRead and VFSWrite, size of VFSTruncate.
+
+	// Data is the buffer of VFSRead and VFSWrite. It refers to memory
+	// owned by SQLite and must not be retained after the hook returns.
+	// Before VFSRead its content is undefined.
+	Data []byte
+
+	// RC is the result code of the operation, like sqlite3.SQLITE_OK or
+	// sqlite3.SQLITE_IOERR_SHORT_READ. It is set before calling After.
+	RC int
+}
+
+// VFSHooks are the hooks of a passthrough VFS, see RegisterPassthroughVFS.
+// They are called concurrently by all the connections using the VFS, so they
+// must be safe for concurrent use.
+type VFSHooks struct {
+	// Before, if not nil, is called before each operation. If it returns
+	// an error, the operation is not performed and fails with the code of
+	// the error, if it is an *Error like ErrFull, or SQLITE_IOERR. The
+	// error is ignored for VFSClose, files are always closed.
+	Before func(e *VFSEvent) error
+
+	// After, if not nil, is called after each operation. If it returns an
+	// error for an operation that succeeded, the operation fails like it
+	// would for Before.
+	After func(e *VFSEvent) error
+}
+
+// passthroughVFS is the Go side of a VFS registered by
+// RegisterPassthroughVFS.
+type passthroughVFS struct {
+	hooks VFSHooks
+	pReal uintptr // *sqlite3.Sqlite3_vfs being wrapped
+
+	sync.Mutex
+	methods map[uintptr]uintptr // real sqlite3_io_methods: the wrapping ones
+}
+
+// passthroughFile is the sqlite3_file of a passthrough VFS. The file of the
+// wrapped VFS follows it in memory.
+type passthroughFile struct {
+	base   sqlite3.Sqlite3_file
+	handle uintptr // refers to a *passthroughFileState
+}
+
+type passthroughFileState struct {
+	vfs  *passthroughVFS
+	name string
+}
+
+// realFile returns the file of the wrapped VFS of pFile.
+func realFile(pFile uintptr) uintptr {
+	return pFile + unsafe.Sizeof(passthroughFile{})
+}
+
+// realMethods returns the sqlite3_io_methods of the wrapped file of pFile.
+func realMethods(pFile uintptr) *sqlite3.Sqlite3_io_methods {
+	return (*sqlite3.Sqlite3_io_methods)(unsafe.Pointer((*sqlite3.Sqlite3_file)(unsafe.Pointer(realFile(pFile))).FpMethods))
+}
+
+func passthroughFileStateOf(pFile uintptr) *passthroughFileState {
+	return handleValue((*passthroughFile)(unsafe.Pointer(pFile)).handle).(*passthroughFileState)
+}
+
+// RegisterPassthroughVFS registers the VFS name, which forwards all
+// operations to the default VFS of the platform, eg. "unix", calling the
+// hooks before and after the file operations listed by the VFS* constants.
+// It is meant for instrumentation, like counting the bytes read or injecting
+// failures or latency in tests. The other methods, like locking, are
+// forwarded without calling the hooks.
+//
+// The VFS is selected by the vfs query parameter of the DSN, eg.
+// "test.db?vfs=name", and it lives as long as the program.
+func RegisterPassthroughVFS(name string, hooks VFSHooks) error {
+	zName, err := libc.CString(name)
+	if err != nil {
+		return err
+	}
+
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	if sqlite3.Xsqlite3_vfs_find(tls, zName) != 0 {
+		libc.Xfree(tls, zName)
+		return fmt.Errorf("sqlite: a VFS named %q is already registered", name)
+	}
+
+	pReal := sqlite3.Xsqlite3_vfs_find(tls, 0)
+	if pReal == 0 {
+		libc.Xfree(tls, zName)
+		return errors.New("sqlite: no default VFS")
+	}
+
+	pVfs := libc.Xcalloc(tls, 1, types.Size_t(unsafe.Sizeof(sqlite3.Sqlite3_vfs{})))
+	if pVfs == 0 {
+		libc.Xfree(tls, zName)
+		return fmt.Errorf("sqlite: cannot allocate VFS %q", name)
+	}
+
+	v := &passthroughVFS{hooks: hooks, pReal: pReal, methods: map[uintptr]uintptr{}}
+	p := (*sqlite3.Sqlite3_vfs)(unsafe.Pointer(pVfs))
+	*p = *(*sqlite3.Sqlite3_vfs)(unsafe.Pointer(pReal))
+	p.FszOsFile += int32(unsafe.Sizeof(passthroughFile{}))
+	p.FpNext = 0
+	p.FzName = zName
+	p.FpAppData = newHandle(v)
+	p.FxOpen = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, uintptr, uintptr, int32, uintptr) int32
+	}{vfsOpen}))
+	p.FxDelete = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, uintptr, int32) int32
+	}{vfsDelete}))
+	p.FxAccess = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, uintptr, int32, uintptr) int32
+	}{vfsAccess}))
+	p.FxFullPathname = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, uintptr, int32, uintptr) int32
+	}{vfsFullPathname}))
+	if rc := sqlite3.Xsqlite3_vfs_register(tls, pVfs, 0); rc != sqlite3.SQLITE_OK {
+		deleteHandle(p.FpAppData)
+		libc.Xfree(tls, pVfs)
+		libc.Xfree(tls, zName)
+		return fmt.Errorf("sqlite: cannot register VFS %q: %s", name, libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+
+	return nil
+}
+
+// run calls f, which performs the operation described by e, and the hooks
+// of v around it.
+func (v *passthroughVFS) run(e *VFSEvent, f func() int32) int32 {
+	if v.hooks.Before != nil {
+		if err := v.hooks.Before(e); err != nil && e.Op != VFSClose {
+			return hookErrorCode(err)
+		}
+	}
+
+	rc := f()
+	e.RC = int(rc)
+	if v.hooks.After != nil {
+		if err := v.hooks.After(e); err != nil && rc == sqlite3.SQLITE_OK {
+			return hookErrorCode(err)
+		}
+	}
+
+	return rc
+}
+
+func hookErrorCode(err error) int32 {
+	var e *Error
+	if errors.As(err, &e) && e.code != sqlite3.SQLITE_OK {
+		return int32(e.code)
+	}
+
+	return sqlite3.SQLITE_IOERR
+}
+
+func passthroughVFSOf(pVfs uintptr) *passthroughVFS {
+	return handleValue((*sqlite3.Sqlite3_vfs)(unsafe.Pointer(pVfs)).FpAppData).(*passthroughVFS)
+}
+
+// ioMethods returns the sqlite3_io_methods wrapping pReal, which are allocated
+// once and never freed.
+func (v *passthroughVFS) ioMethods(pReal uintptr) (uintptr, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if p, ok := v.methods[pReal]; ok {
+		return p, nil
+	}
+
+	pMethods := libc.Xcalloc(nil, 1, types.Size_t(unsafe.Sizeof(sqlite3.Sqlite3_io_methods{})))
+	if pMethods == 0 {
+		return 0, errors.New("sqlite: cannot allocate VFS methods")
+	}
+
+	r := (*sqlite3.Sqlite3_io_methods)(unsafe.Pointer(pReal))
+	p := (*sqlite3.Sqlite3_io_methods)(unsafe.Pointer(pMethods))
+	p.FiVersion = r.FiVersion
+	p.FxClose = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr) int32
+	}{vfsClose}))
+	p.FxRead = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, uintptr, int32, int64) int32
+	}{vfsRead}))
+	p.FxWrite = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, uintptr, int32, int64) int32
+	}{vfsWrite}))
+	p.FxTruncate = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, int64) int32
+	}{vfsTruncate}))
+	p.FxSync = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, int32) int32
+	}{vfsSync}))
+	p.FxFileSize = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, uintptr) int32
+	}{vfsFileSize}))
+	p.FxLock = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, int32) int32
+	}{vfsLock}))
+	p.FxUnlock = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, int32) int32
+	}{vfsUnlock}))
+	p.FxCheckReservedLock = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, uintptr) int32
+	}{vfsCheckReservedLock}))
+	p.FxFileControl = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, int32, uintptr) int32
+	}{vfsFileControl}))
+	p.FxSectorSize = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr) int32
+	}{vfsSectorSize}))
+	p.FxDeviceCharacteristics = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr) int32
+	}{vfsDeviceCharacteristics}))
+	// The optional methods are provided only if the wrapped file has them.
+	if r.FiVersion >= 2 && r.FxShmMap != 0 {
+		p.FxShmMap = *(*uintptr)(unsafe.Pointer(&struct {
+			f func(*libc.TLS, uintptr, int32, int32, int32, uintptr) int32
+		}{vfsShmMap}))
+		p.FxShmLock = *(*uintptr)(unsafe.Pointer(&struct {
+			f func(*libc.TLS, uintptr, int32, int32, int32) int32
+		}{vfsShmLock}))
+		p.FxShmBarrier = *(*uintptr)(unsafe.Pointer(&struct {
+			f func(*libc.TLS, uintptr)
+		}{vfsShmBarrier}))
+		p.FxShmUnmap = *(*uintptr)(unsafe.Pointer(&struct {
+			f func(*libc.TLS, uintptr, int32) int32
+		}{vfsShmUnmap}))
+	}
+	if r.FiVersion >= 3 && r.FxFetch != 0 {
+		p.FxFetch = *(*uintptr)(unsafe.Pointer(&struct {
+			f func(*libc.TLS, uintptr, int64, int32, uintptr) int32
+		}{vfsFetch}))
+		p.FxUnfetch = *(*uintptr)(unsafe.Pointer(&struct {
+			f func(*libc.TLS, uintptr, int64, uintptr) int32
+		}{vfsUnfetch}))
+	}
+	v.methods[pReal] = pMethods
+	return pMethods, nil
+}
+
+func vfsOpen(tls *libc.TLS, pVfs, zName, pFile uintptr, flags int32, pOutFlags uintptr) int32 {
+	v := passthroughVFSOf(pVfs)
+	f := (*passthroughFile)(unsafe.Pointer(pFile))
+	f.base.FpMethods = 0
+	e := &VFSEvent{Op: VFSOpen}
+	if zName != 0 {
+		e.Name = libc.GoString(zName)
+	}
+	return v.run(e, func() int32 {
+		rc := (*struct {
+			f func(*libc.TLS, uintptr, uintptr, uintptr, int32, uintptr) int32
+		})(unsafe.Pointer(&struct{ uintptr }{(*sqlite3.Sqlite3_vfs)(unsafe.Pointer(v.pReal)).FxOpen})).f(tls, v.pReal, zName, realFile(pFile), flags, pOutFlags)
+		pReal := (*sqlite3.Sqlite3_file)(unsafe.Pointer(realFile(pFile))).FpMethods
+		if pReal == 0 {
+			return rc
+		}
+
+		// SQLite calls xClose even if xOpen fails, as long as the
+		// methods are set.
+		pMethods, err := v.ioMethods(pReal)
+		if err != nil {
+			(*struct {
+				f func(*libc.TLS, uintptr) int32
+			})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxClose})).f(tls, realFile(pFile))
+			return sqlite3.SQLITE_NOMEM
+		}
+
+		f.handle = newHandle(&passthroughFileState{vfs: v, name: e.Name})
+		f.base.FpMethods = pMethods
+		return rc
+	})
+}
+
+func vfsDelete(tls *libc.TLS, pVfs, zName uintptr, syncDir int32) int32 {
+	v := passthroughVFSOf(pVfs)
+	return v.run(&VFSEvent{Op: VFSDelete, Name: libc.GoString(zName)}, func() int32 {
+		return (*struct {
+			f func(*libc.TLS, uintptr, uintptr, int32) int32
+		})(unsafe.Pointer(&struct{ uintptr }{(*sqlite3.Sqlite3_vfs)(unsafe.Pointer(v.pReal)).FxDelete})).f(tls, v.pReal, zName, syncDir)
+	})
+}
+
+func vfsAccess(tls *libc.TLS, pVfs, zName uintptr, flags int32, pResOut uintptr) int32 {
+	pReal := passthroughVFSOf(pVfs).pReal
+	return (*struct {
+		f func(*libc.TLS, uintptr, uintptr, int32, uintptr) int32
+	})(unsafe.Pointer(&struct{ uintptr }{(*sqlite3.Sqlite3_vfs)(unsafe.Pointer(pReal)).FxAccess})).f(tls, pReal, zName, flags, pResOut)
+}
+
+func vfsFullPathname(tls *libc.TLS, pVfs, zName uintptr, nOut int32, zOut uintptr) int32 {
+	pReal := passthroughVFSOf(pVfs).pReal
+	return (*struct {
+		f func(*libc.TLS, uintptr, uintptr, int32, uintptr) int32
+	})(unsafe.Pointer(&struct{ uintptr }{(*sqlite3.Sqlite3_vfs)(unsafe.Pointer(pReal)).FxFullPathname})).f(tls, pReal, zName, nOut, zOut)
+}
+
+func vfsClose(tls *libc.TLS, pFile uintptr) int32 {
+	f := (*passthroughFile)(unsafe.Pointer(pFile))
+	s := passthroughFileStateOf(pFile)
+	rc := s.vfs.run(&VFSEvent{Op: VFSClose, Name: s.name}, func() int32 {
+		return (*struct {
+			f func(*libc.TLS, uintptr) int32
+		})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxClose})).f(tls, realFile(pFile))
+	})
+	deleteHandle(f.handle)
+	f.handle = 0
+	f.base.FpMethods = 0
+	return rc
+}
+
+func vfsRead(tls *libc.TLS, pFile, pBuf uintptr, iAmt int32, iOfst int64) int32 {
+	s := passthroughFileStateOf(pFile)
+	e := &VFSEvent{Op: VFSRead, Name: s.name, Offset: iOfst, Data: (*libc.RawMem)(unsafe.Pointer(pBuf))[:iAmt:iAmt]}
+	return s.vfs.run(e, func() int32 {
+		return (*struct {
+			f func(*libc.TLS, uintptr, uintptr, int32, int64) int32
+		})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxRead})).f(tls, realFile(pFile), pBuf, iAmt, iOfst)
+	})
+}
+
+func vfsWrite(tls *libc.TLS, pFile, pBuf uintptr, iAmt int32, iOfst int64) int32 {
+	s := passthroughFileStateOf(pFile)
+	e := &VFSEvent{Op: VFSWrite, Name: s.name, Offset: iOfst, Data: (*libc.RawMem)(unsafe.Pointer(pBuf))[:iAmt:iAmt]}
+	return s.vfs.run(e, func() int32 {
+		return (*struct {
+			f func(*libc.TLS, uintptr, uintptr, int32, int64) int32
+		})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxWrite})).f(tls, realFile(pFile), pBuf, iAmt, iOfst)
+	})
+}
+
+func vfsTruncate(tls *libc.TLS, pFile uintptr, size int64) int32 {
+	s := passthroughFileStateOf(pFile)
+	return s.vfs.run(&VFSEvent{Op: VFSTruncate, Name: s.name, Offset: size}, func() int32 {
+		return (*struct {
+			f func(*libc.TLS, uintptr, int64) int32
+		})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxTruncate})).f(tls, realFile(pFile), size)
+	})
+}
+
+func vfsSync(tls *libc.TLS, pFile uintptr, flags int32) int32 {
+	s := passthroughFileStateOf(pFile)
+	return s.vfs.run(&VFSEvent{Op: VFSSync, Name: s.name}, func() int32 {
+		return (*struct {
+			f func(*libc.TLS, uintptr, int32) int32
+		})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxSync})).f(tls, realFile(pFile), flags)
+	})
+}
+
+func vfsFileSize(tls *libc.TLS, pFile, pSize uintptr) int32 {
+	s := passthroughFileStateOf(pFile)
+	return s.vfs.run(&VFSEvent{Op: VFSFileSize, Name: s.name}, func() int32 {
+		return (*struct {
+			f func(*libc.TLS, uintptr, uintptr) int32
+		})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxFileSize})).f(tls, realFile(pFile), pSize)
+	})
+}
+
+func vfsLock(tls *libc.TLS, pFile uintptr, lock int32) int32 {
+	return (*struct {
+		f func(*libc.TLS, uintptr, int32) int32
+	})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxLock})).f(tls, realFile(pFile), lock)
+}
+
+func vfsUnlock(tls *libc.TLS, pFile uintptr, lock int32) int32 {
+	return (*struct {
+		f func(*libc.TLS, uintptr, int32) int32
+	})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxUnlock})).f(tls, realFile(pFile), lock)
+}
+
+func vfsCheckReservedLock(tls *libc.TLS, pFile, pResOut uintptr) int32 {
+	return (*struct {
+		f func(*libc.TLS, uintptr, uintptr) int32
+	})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxCheckReservedLock})).f(tls, realFile(pFile), pResOut)
+}
+
+func vfsFileControl(tls *libc.TLS, pFile uintptr, op int32, pArg uintptr) int32 {
+	return (*struct {
+		f func(*libc.TLS, uintptr, int32, uintptr) int32
+	})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxFileControl})).f(tls, realFile(pFile), op, pArg)
+}
+
+func vfsSectorSize(tls *libc.TLS, pFile uintptr) int32 {
+	return (*struct {
+		f func(*libc.TLS, uintptr) int32
+	})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxSectorSize})).f(tls, realFile(pFile))
+}
+
+func vfsDeviceCharacteristics(tls *libc.TLS, pFile uintptr) int32 {
+	return (*struct {
+		f func(*libc.TLS, uintptr) int32
+	})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxDeviceCharacteristics})).f(tls, realFile(pFile))
+}
+
+func vfsShmMap(tls *libc.TLS, pFile uintptr, iPg, pgsz, bExtend int32, pp uintptr) int32 {
+	return (*struct {
+		f func(*libc.TLS, uintptr, int32, int32, int32, uintptr) int32
+	})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxShmMap})).f(tls, realFile(pFile), iPg, pgsz, bExtend, pp)
+}
+
+func vfsShmLock(tls *libc.TLS, pFile uintptr, offset, n, flags int32) int32 {
+	return (*struct {
+		f func(*libc.TLS, uintptr, int32, int32, int32) int32
+	})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxShmLock})).f(tls, realFile(pFile), offset, n, flags)
+}
+
+func vfsShmBarrier(tls *libc.TLS, pFile uintptr) {
+	(*struct {
+		f func(*libc.TLS, uintptr)
+	})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxShmBarrier})).f(tls, realFile(pFile))
+}
+
+func vfsShmUnmap(tls *libc.TLS, pFile uintptr, deleteFlag int32) int32 {
+	return (*struct {
+		f func(*libc.TLS, uintptr, int32) int32
+	})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxShmUnmap})).f(tls, realFile(pFile), deleteFlag)
+}
+
+func vfsFetch(tls *libc.TLS, pFile uintptr, iOfst int64, iAmt int32, pp uintptr) int32 {
+	return (*struct {
+		f func(*libc.TLS, uintptr, int64, int32, uintptr) int32
+	})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxFetch})).f(tls, realFile(pFile), iOfst, iAmt, pp)
+}
+
+func vfsUnfetch(tls *libc.TLS, pFile uintptr, iOfst int64, p uintptr) int32 {
+	return (*struct {
+		f func(*libc.TLS, uintptr, int64, uintptr) int32
+	})(unsafe.Pointer(&struct{ uintptr }{realMethods(pFile).FxUnfetch})).f(tls, realFile(pFile), iOfst, p)
+}
+
+// checksumVFS keeps the page checksums of the database files of a VFS
+// registered by RegisterChecksumVFS.
+type checksumVFS struct {
+	sync.Mutex
+	files map[string]*checksumFile
+}
+
+// checksumFile holds the checksums of the pages of a database file, by offset.
+type checksumFile struct {
+	pageSize int64
+	sums     map[int64]uint32
+}
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// RegisterChecksumVFS registers the VFS name, a passthrough VFS, see
+// RegisterPassthroughVFS, that computes a CRC-32C checksum of every page
+// written to a database file and verifies it when the page is read back, so
+// that silent corruption by unreliable storage is detected. A read of a page
+// that does not match its checksum fails with SQLITE_IOERR_DATA.
+//
+// The checksums are kept in memory, so only the pages written by the process
+// since the registration are verified, and memory use grows by a few bytes per
+// page written. Journal and WAL files, and pages read through memory-mapped
+// I/O, see Mmap, are not verified.
+func RegisterChecksumVFS(name string) error {
+	v := &checksumVFS{files: map[string]*checksumFile{}}
+	return RegisterPassthroughVFS(name, VFSHooks{After: v.after})
+}
+
+func (v *checksumVFS) after(e *VFSEvent) error {
+	if e.RC != sqlite3.SQLITE_OK || e.Name == "" || strings.HasSuffix(e.Name, "-journal") || strings.HasSuffix(e.Name, "-wal") {
+		return nil
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	switch e.Op {
+	case VFSWrite:
+		n := int64(len(e.Data))
+		f := v.files[e.Name]
+		if !isPage(e.Offset, n) {
+			// Not a whole page, forget the checksums of the pages
+			// it overlaps.
+			if f != nil && f.pageSize != 0 {
+				for off := e.Offset - e.Offset%f.pageSize; off < e.Offset+n; off += f.pageSize {
+					delete(f.sums, off)
+				}
+			}
+			return nil
+		}
+
+		if f == nil || f.pageSize != n {
+			f = &checksumFile{pageSize: n, sums: map[int64]uint32{}}
+			v.files[e.Name] = f
+		}
+		f.sums[e.Offset] = crc32.Checksum(e.Data, checksumTable)
+	case VFSRead:
+		f := v.files[e.Name]
+		if f == nil || int64(len(e.Data)) != f.pageSize || e.Offset%f.pageSize != 0 {
+			return nil
+		}
+
+		if sum, ok := f.sums[e.Offset]; ok && sum != crc32.Checksum(e.Data, checksumTable) {
+			return &Error{
+				msg:  fmt.Sprintf("checksum mismatch of the page at offset %d of %s", e.Offset, e.Name),
+				code: sqlite3.SQLITE_IOERR_DATA,
+			}
+		}
+	case VFSTruncate:
+		if f := v.files[e.Name]; f != nil {
+			for off := range f.sums {
+				if off >= e.Offset {
+					delete(f.sums, off)
+				}
+			}
+		}
+	case VFSDelete:
+		delete(v.files, e.Name)
+	}
+	return nil
+}
+
+// isPage reports whether a write of n bytes at off may be a database page,
+// whose size is a power of two between 512 and 65536.
+func isPage(off, n int64) bool {
+	return n >= 512 && n <= 65536 && n&(n-1) == 0 && off%n == 0
+}
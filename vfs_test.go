@@ -0,0 +1,270 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func TestPassthroughVFS(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sqlite-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var (
+		mu        sync.Mutex
+		opened    []string
+		pageReads int
+		writes    int
+		failWrite bool
+	)
+	fn := filepath.Join(dir, "tmp.db")
+	hooks := VFSHooks{
+		Before: func(e *VFSEvent) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if e.Op == VFSWrite && failWrite {
+				return ErrFull
+			}
+
+			return nil
+		},
+		After: func(e *VFSEvent) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch e.Op {
+			case VFSOpen:
+				opened = append(opened, e.Name)
+			case VFSRead:
+				if e.Name == fn && len(e.Data) == 4096 && e.Offset%4096 == 0 {
+					pageReads++
+				}
+			case VFSWrite:
+				writes++
+			}
+			return nil
+		},
+	}
+	if err := RegisterPassthroughVFS("passthrough_test", hooks); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RegisterPassthroughVFS("passthrough_test", hooks); err == nil {
+		t.Fatal("unexpected success")
+	}
+
+	if !VFSExists("passthrough_test") {
+		t.Fatal("VFS not registered")
+	}
+
+	if VFSExists("no such vfs") {
+		t.Fatal("unexpected VFS")
+	}
+
+	names := RegisteredVFSes()
+	if len(names) < 2 || names[0] == "passthrough_test" {
+		t.Fatalf("unexpected VFSes %q", names)
+	}
+
+	found := false
+	for _, v := range names {
+		found = found || v == "passthrough_test"
+	}
+	if !found {
+		t.Fatalf("passthrough_test not in %q", names)
+	}
+
+	db, err := sql.Open(driverName, fn+"?vfs=passthrough_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("pragma journal_mode=wal; create table t(s); insert into t select printf('%.500c', 'x') from (with recursive c(x) as (select 1 union all select x+1 from c where x < 100) select x from c)"); err != nil {
+		t.Fatal(err)
+	}
+
+	var pages int
+	if err := db.QueryRow("pragma page_count").Scan(&pages); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Close()
+
+	mu.Lock()
+	if len(opened) == 0 || opened[0] != fn {
+		t.Errorf("unexpected opened files %q", opened)
+	}
+	if writes == 0 {
+		t.Error("no writes")
+	}
+	pageReads = 0
+	mu.Unlock()
+
+	if db, err = sql.Open(driverName, fn+"?vfs=passthrough_test"); err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	var n int
+	if err := db.QueryRow("select count(*) from t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 100 {
+		t.Fatalf("got %v rows", n)
+	}
+
+	mu.Lock()
+	if pageReads < pages-1 {
+		t.Errorf("got %v page reads, want at least %v", pageReads, pages-1)
+	}
+	failWrite = true
+	mu.Unlock()
+
+	_, err = db.Exec("insert into t values(1)")
+	if !errors.Is(err, ErrFull) || !strings.Contains(err.Error(), "full") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestOpenHook(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		mu    sync.Mutex
+		names []string
+	)
+	RegisterOpenHook(func(name string) (string, bool) {
+		if !strings.HasPrefix(name, "s3://") {
+			return "", false
+		}
+
+		mu.Lock()
+		names = append(names, name)
+		mu.Unlock()
+		return "memdb", true
+	})
+
+	// The s3:// name would not open without the hook, its directory does not
+	// exist.
+	for _, name := range []string{
+		filepath.Join(dir, "test.db"),
+		"s3://" + filepath.Join(dir, "bucket.db"),
+	} {
+		db, err := sql.Open(driverName, name+"?_pragma=busy_timeout(1000)")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := db.Exec("create table t(i); insert into t values(1)"); err != nil {
+			t.Fatal(err)
+		}
+
+		db.Close()
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "test.db")); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("unexpected files %v", entries)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(names) != 1 || names[0] != "s3://"+filepath.Join(dir, "bucket.db") {
+		t.Fatalf("unexpected names %q", names)
+	}
+}
+
+func TestChecksumVFS(t *testing.T) {
+	if err := RegisterChecksumVFS("checksum-test"); err != nil {
+		t.Fatal(err)
+	}
+
+	fn := filepath.Join(t.TempDir(), "test.db")
+	open := func() *sql.DB {
+		db, err := sql.Open(driverName, fn+"?vfs=checksum-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return db
+	}
+
+	db := open()
+	if _, err := db.Exec(`
+create table t(b);
+with recursive c(x) as (select 1 union all select x+1 from c where x < 100)
+insert into t select randomblob(1000) from c;
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Close()
+
+	count := func(db *sql.DB) error {
+		var n int
+		return db.QueryRow("select count(*) from t where length(b) = 1000").Scan(&n)
+	}
+
+	db = open()
+	if err := count(db); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Close()
+
+	// Flip a byte of the last page behind SQLite's back.
+	f, err := os.OpenFile(fn, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, fi.Size()-100); err != nil {
+		t.Fatal(err)
+	}
+
+	b[0] ^= 0xff
+	if _, err := f.WriteAt(b, fi.Size()-100); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+
+	db = open()
+	defer db.Close()
+
+	err = count(db)
+	if e, ok := err.(*Error); !ok || e.Code() != sqlite3.SQLITE_IOERR_DATA {
+		t.Fatalf("got %v, want SQLITE_IOERR_DATA", err)
+	}
+}
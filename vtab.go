@@ -0,0 +1,661 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"unsafe"
+
+	"modernc.org/libc"
+	"modernc.org/libc/sys/types"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Operators of an IndexConstraint. See
+// https://www.sqlite.org/c3ref/c_index_constraint_eq.html for details.
+const (
+	IndexConstraintEQ        = sqlite3.SQLITE_INDEX_CONSTRAINT_EQ
+	IndexConstraintGT        = sqlite3.SQLITE_INDEX_CONSTRAINT_GT
+	IndexConstraintLE        = sqlite3.SQLITE_INDEX_CONSTRAINT_LE
+	IndexConstraintLT        = sqlite3.SQLITE_INDEX_CONSTRAINT_LT
+	IndexConstraintGE        = sqlite3.SQLITE_INDEX_CONSTRAINT_GE
+	IndexConstraintMATCH     = sqlite3.SQLITE_INDEX_CONSTRAINT_MATCH
+	IndexConstraintLIKE      = sqlite3.SQLITE_INDEX_CONSTRAINT_LIKE
+	IndexConstraintGLOB      = sqlite3.SQLITE_INDEX_CONSTRAINT_GLOB
+	IndexConstraintREGEXP    = sqlite3.SQLITE_INDEX_CONSTRAINT_REGEXP
+	IndexConstraintNE        = sqlite3.SQLITE_INDEX_CONSTRAINT_NE
+	IndexConstraintISNOT     = sqlite3.SQLITE_INDEX_CONSTRAINT_ISNOT
+	IndexConstraintISNOTNULL = sqlite3.SQLITE_INDEX_CONSTRAINT_ISNOTNULL
+	IndexConstraintISNULL    = sqlite3.SQLITE_INDEX_CONSTRAINT_ISNULL
+	IndexConstraintIS        = sqlite3.SQLITE_INDEX_CONSTRAINT_IS
+	IndexConstraintLIMIT     = sqlite3.SQLITE_INDEX_CONSTRAINT_LIMIT
+	IndexConstraintOFFSET    = sqlite3.SQLITE_INDEX_CONSTRAINT_OFFSET
+	IndexConstraintFUNCTION  = sqlite3.SQLITE_INDEX_CONSTRAINT_FUNCTION
+)
+
+// IndexScanUnique is the IndexInfo.IdxFlags bit telling SQLite that the plan
+// visits at most one row.
+const IndexScanUnique = sqlite3.SQLITE_INDEX_SCAN_UNIQUE
+
+// Module is a virtual table module implemented in Go. See
+// https://www.sqlite.org/vtab.html for an overview of virtual tables.
+type Module interface {
+	// Connect is called both when a virtual table is created by CREATE
+	// VIRTUAL TABLE and when an existing one is opened. args[0] is the
+	// module name, args[1] the database name, args[2] the table name and
+	// the rest are the arguments of the CREATE VIRTUAL TABLE statement, if
+	// any. The returned schema must be a CREATE TABLE statement declaring
+	// the columns of the table, eg. "create table x(key, value)". The table
+	// name in schema is ignored.
+	//
+	// Because the module can also be used as an eponymous virtual table,
+	// it is possible to query it by its module name without creating it
+	// first. Columns declared HIDDEN then act as the arguments of a
+	// table-valued function.
+	Connect(args []string) (table VTab, schema string, err error)
+}
+
+// VTab is an instance of a virtual table created by a Module.
+type VTab interface {
+	// BestIndex is called while planning a query against the table. It
+	// inspects the constraints and ORDER BY terms in info and sets the
+	// output fields to describe how the table will be scanned.
+	BestIndex(info *IndexInfo) error
+
+	// Open returns a new cursor over the table.
+	Open() (VTabCursor, error)
+
+	// Disconnect is called when the connection stops using the table.
+	Disconnect() error
+
+	// Destroy is called when the table is dropped by DROP TABLE.
+	Destroy() error
+}
+
+// VTabCursor iterates over the rows of a VTab.
+type VTabCursor interface {
+	// Filter starts a new scan of the table using the plan chosen by
+	// BestIndex. idxNum and idxStr are the values set by BestIndex and args
+	// are the right-hand values of the constraints for which BestIndex set
+	// a positive ArgvIndex, in ArgvIndex order.
+	Filter(idxNum int, idxStr string, args []driver.Value) error
+
+	// Next advances the cursor to the next row.
+	Next() error
+
+	// EOF reports whether the cursor is past the last row.
+	EOF() bool
+
+	// Column returns the value of the column col, counting from zero, of
+	// the current row.
+	Column(col int) (driver.Value, error)
+
+	// Rowid returns the rowid of the current row.
+	Rowid() (int64, error)
+
+	// Close releases the cursor.
+	Close() error
+}
+
+// VTabFindFunction may be implemented by a VTab to overload SQL functions
+// taking a column of the table as their first argument, eg. to implement the
+// MATCH operator, which calls the match(pattern, column) function.
+//
+// SQLite only consults FindFunction for functions that already exist, so a
+// function without a global implementation must first be declared using
+// OverloadFunction.
+type VTabFindFunction interface {
+	// FindFunction returns the implementation of the function name taking
+	// nArg arguments, or nil to use the global implementation. The first
+	// implementation returned for a name and nArg is kept until the table is
+	// disconnected.
+	FindFunction(name string, nArg int) func(ctx *FunctionContext, args []driver.Value) (driver.Value, error)
+}
+
+// IndexConstraint is a WHERE clause constraint passed to VTab.BestIndex.
+type IndexConstraint struct {
+	Column int  // Column constrained, -1 for the rowid.
+	Op     int  // Constraint operator, one of the IndexConstraint* values.
+	Usable bool // Whether the constraint can be used in this plan.
+
+	// ArgvIndex is set by BestIndex. If positive, the right-hand value of
+	// the constraint is passed to VTabCursor.Filter as args[ArgvIndex-1].
+	ArgvIndex int
+
+	// Omit is set by BestIndex to tell SQLite it need not double check the
+	// constraint on the rows produced by the cursor.
+	Omit bool
+}
+
+// IndexOrderBy is an ORDER BY term passed to VTab.BestIndex.
+type IndexOrderBy struct {
+	Column int  // Column number.
+	Desc   bool // Whether the order is descending.
+}
+
+// IndexInfo describes a query against a virtual table being planned. The
+// Constraints, OrderBy and ColUsed fields are inputs. BestIndex sets the
+// remaining fields and the ArgvIndex and Omit fields of the constraints it
+// uses.
+//
+// See https://www.sqlite.org/vtab.html#the_xbestindex_method for details.
+type IndexInfo struct {
+	Constraints []IndexConstraint
+	OrderBy     []IndexOrderBy
+	ColUsed     uint64 // Mask of the columns used by the statement.
+
+	IdxNum          int     // Passed to VTabCursor.Filter.
+	IdxStr          string  // Passed to VTabCursor.Filter.
+	OrderByConsumed bool    // Whether the cursor produces rows in the OrderBy order.
+	EstimatedCost   float64 // Estimated cost of the plan.
+	EstimatedRows   int64   // Estimated number of rows produced.
+	IdxFlags        int     // Mask of IndexScan* flags.
+
+	tls *libc.TLS
+	p   uintptr // *sqlite3.Sqlite3_index_info
+}
+
+// Distinct returns the sqlite3_vtab_distinct value of the query: 0 if the
+// rows must be produced as is, 1 if only the ORDER BY columns must be
+// distinct and grouped, 2 if they only need to be distinct and 3 if they
+// only need to be distinct for the columns in ColUsed. It may only be
+// called from BestIndex.
+func (info *IndexInfo) Distinct() int {
+	return int(sqlite3.Xsqlite3_vtab_distinct(info.tls, info.p))
+}
+
+// Collation returns the name of the collating sequence of the constraint i.
+// It may only be called from BestIndex.
+func (info *IndexInfo) Collation(i int) string {
+	return libc.GoString(sqlite3.Xsqlite3_vtab_collation(info.tls, info.p, int32(i)))
+}
+
+// The layout of these types matches their C counterparts, which are not
+// exported by the sqlite3 package.
+type (
+	indexConstraint struct {
+		iColumn     int32
+		op          uint8
+		usable      uint8
+		_           [2]byte
+		iTermOffset int32
+	}
+
+	indexOrderBy struct {
+		iColumn int32
+		desc    uint8
+		_       [3]byte
+	}
+
+	indexConstraintUsage struct {
+		argvIndex int32
+		omit      uint8
+		_         [3]byte
+	}
+)
+
+// goVTab is the sqlite3_vtab allocated for a VTab.
+type goVTab struct {
+	base   sqlite3.Sqlite3_vtab
+	handle uintptr // refers to a *vtabState
+}
+
+type vtabState struct {
+	table VTab
+	funcs map[vtabFunc]uintptr // handles of the functions returned by FindFunction
+}
+
+// vtabFunc identifies a function overloaded by VTabFindFunction.
+type vtabFunc struct {
+	name string
+	nArg int32
+}
+
+// goVTabCursor is the sqlite3_vtab_cursor allocated for a VTabCursor.
+type goVTabCursor struct {
+	base   sqlite3.Sqlite3_vtab_cursor
+	handle uintptr
+}
+
+type module struct {
+	zName   uintptr // C name
+	pModule uintptr // *sqlite3.Sqlite3_module
+	handle  uintptr // pAux, refers to the Module
+}
+
+// RegisterModule registers the virtual table module m under name.
+//
+// The new module will be available to all new connections opened after
+// executing RegisterModule.
+func RegisterModule(name string, m Module) error {
+	if _, ok := d.modules[name]; ok {
+		return fmt.Errorf("sqlite: a module named %q is already registered", name)
+	}
+
+	mod, err := newModule(name, m)
+	if err != nil {
+		return err
+	}
+
+	d.modules[name] = mod
+	return nil
+}
+
+// newModule allocates the C structures describing m. Modules live as long as
+// the program, so they are never freed.
+func newModule(name string, m Module) (*module, error) {
+	zName, err := libc.CString(name)
+	if err != nil {
+		return nil, err
+	}
+
+	pModule := libc.Xcalloc(nil, 1, types.Size_t(unsafe.Sizeof(sqlite3.Sqlite3_module{})))
+	if pModule == 0 {
+		libc.Xfree(nil, zName)
+		return nil, fmt.Errorf("sqlite: cannot allocate module %q", name)
+	}
+
+	p := (*sqlite3.Sqlite3_module)(unsafe.Pointer(pModule))
+	p.FiVersion = 1
+	p.FxCreate = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, uintptr, int32, uintptr, uintptr, uintptr) int32
+	}{vtabConnect}))
+	p.FxConnect = p.FxCreate
+	p.FxBestIndex = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, uintptr) int32
+	}{vtabBestIndex}))
+	p.FxDisconnect = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr) int32
+	}{vtabDisconnect}))
+	p.FxDestroy = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr) int32
+	}{vtabDestroy}))
+	p.FxOpen = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, uintptr) int32
+	}{vtabOpen}))
+	p.FxClose = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr) int32
+	}{vtabClose}))
+	p.FxFilter = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, int32, uintptr, int32, uintptr) int32
+	}{vtabFilter}))
+	p.FxNext = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr) int32
+	}{vtabNext}))
+	p.FxEof = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr) int32
+	}{vtabEOF}))
+	p.FxColumn = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, uintptr, int32) int32
+	}{vtabColumn}))
+	p.FxRowid = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, uintptr) int32
+	}{vtabRowid}))
+	p.FxFindFunction = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, int32, uintptr, uintptr, uintptr) int32
+	}{vtabFindFunction}))
+	return &module{zName: zName, pModule: pModule, handle: newHandle(m)}, nil
+}
+
+// int sqlite3_create_module_v2(
+//
+//	sqlite3 *db,               /* SQLite connection to register module with */
+//	const char *zName,         /* Name of the module */
+//	const sqlite3_module *p,   /* Methods for the module */
+//	void *pClientData,         /* Client data for xCreate/xConnect */
+//	void(*xDestroy)(void*)     /* Module destructor function */
+//
+// );
+func (c *conn) createModuleInternal(mod *module) error {
+	if rc := sqlite3.Xsqlite3_create_module_v2(c.tls, c.db, mod.zName, mod.pModule, mod.handle, 0); rc != sqlite3.SQLITE_OK {
+		return c.errstr(rc)
+	}
+
+	return nil
+}
+
+// sqliteString returns s as a C string allocated by sqlite3_malloc, as
+// required for error messages handed over to SQLite.
+func sqliteString(tls *libc.TLS, s string) uintptr {
+	p := sqlite3.Xsqlite3_malloc64(tls, uint64(len(s)+1))
+	if p == 0 {
+		return 0
+	}
+
+	b := (*libc.RawMem)(unsafe.Pointer(p))[: len(s)+1 : len(s)+1]
+	copy(b, s)
+	b[len(s)] = 0
+	return p
+}
+
+// setVTabError sets the error message of the virtual table pVTab to err and
+// returns SQLITE_ERROR.
+func setVTabError(tls *libc.TLS, pVTab uintptr, err error) int32 {
+	vt := (*sqlite3.Sqlite3_vtab)(unsafe.Pointer(pVTab))
+	if vt.FzErrMsg != 0 {
+		sqlite3.Xsqlite3_free(tls, vt.FzErrMsg)
+	}
+	vt.FzErrMsg = sqliteString(tls, err.Error())
+	return sqlite3.SQLITE_ERROR
+}
+
+func vtabConnect(tls *libc.TLS, db, pAux uintptr, argc int32, argv, ppVTab, pzErr uintptr) int32 {
+	m := handleValue(pAux).(Module)
+	args := make([]string, argc)
+	for i := range args {
+		args[i] = libc.GoString(*(*uintptr)(unsafe.Pointer(argv + uintptr(i)*ptrSize)))
+	}
+
+	fail := func(err error) int32 {
+		*(*uintptr)(unsafe.Pointer(pzErr)) = sqliteString(tls, err.Error())
+		return sqlite3.SQLITE_ERROR
+	}
+
+	table, schema, err := m.Connect(args)
+	if err != nil {
+		return fail(err)
+	}
+
+	zSchema, err := libc.CString(schema)
+	if err != nil {
+		table.Disconnect()
+		return fail(err)
+	}
+
+	defer libc.Xfree(tls, zSchema)
+
+	if rc := sqlite3.Xsqlite3_declare_vtab(tls, db, zSchema); rc != sqlite3.SQLITE_OK {
+		table.Disconnect()
+		return fail(fmt.Errorf("declaring virtual table %q: %s", schema, libc.GoString(sqlite3.Xsqlite3_errmsg(tls, db))))
+	}
+
+	pVTab := libc.Xcalloc(tls, 1, types.Size_t(unsafe.Sizeof(goVTab{})))
+	if pVTab == 0 {
+		table.Disconnect()
+		return sqlite3.SQLITE_NOMEM
+	}
+
+	(*goVTab)(unsafe.Pointer(pVTab)).handle = newHandle(&vtabState{table: table})
+	*(*uintptr)(unsafe.Pointer(ppVTab)) = pVTab
+	return sqlite3.SQLITE_OK
+}
+
+func vtabStateOf(pVTab uintptr) *vtabState {
+	return handleValue((*goVTab)(unsafe.Pointer(pVTab)).handle).(*vtabState)
+}
+
+func vtabTable(pVTab uintptr) VTab {
+	return vtabStateOf(pVTab).table
+}
+
+func vtabBestIndex(tls *libc.TLS, pVTab, pInfo uintptr) int32 {
+	p := (*sqlite3.Sqlite3_index_info)(unsafe.Pointer(pInfo))
+	info := &IndexInfo{
+		Constraints:   make([]IndexConstraint, p.FnConstraint),
+		OrderBy:       make([]IndexOrderBy, p.FnOrderBy),
+		ColUsed:       uint64(p.FcolUsed),
+		EstimatedCost: p.FestimatedCost,
+		EstimatedRows: int64(p.FestimatedRows),
+		tls:           tls,
+		p:             pInfo,
+	}
+	for i := range info.Constraints {
+		c := (*indexConstraint)(unsafe.Pointer(p.FaConstraint + uintptr(i)*unsafe.Sizeof(indexConstraint{})))
+		info.Constraints[i] = IndexConstraint{
+			Column: int(c.iColumn),
+			Op:     int(c.op),
+			Usable: c.usable != 0,
+		}
+	}
+	for i := range info.OrderBy {
+		o := (*indexOrderBy)(unsafe.Pointer(p.FaOrderBy + uintptr(i)*unsafe.Sizeof(indexOrderBy{})))
+		info.OrderBy[i] = IndexOrderBy{
+			Column: int(o.iColumn),
+			Desc:   o.desc != 0,
+		}
+	}
+
+	if err := vtabTable(pVTab).BestIndex(info); err != nil {
+		return setVTabError(tls, pVTab, err)
+	}
+
+	for i, c := range info.Constraints {
+		u := (*indexConstraintUsage)(unsafe.Pointer(p.FaConstraintUsage + uintptr(i)*unsafe.Sizeof(indexConstraintUsage{})))
+		u.argvIndex = int32(c.ArgvIndex)
+		u.omit = uint8(libc.Bool32(c.Omit))
+	}
+	p.FidxNum = int32(info.IdxNum)
+	if info.IdxStr != "" {
+		p.FidxStr = sqliteString(tls, info.IdxStr)
+		p.FneedToFreeIdxStr = 1
+	}
+	p.ForderByConsumed = libc.Bool32(info.OrderByConsumed)
+	p.FestimatedCost = info.EstimatedCost
+	p.FestimatedRows = info.EstimatedRows
+	p.FidxFlags = int32(info.IdxFlags)
+	return sqlite3.SQLITE_OK
+}
+
+func vtabRelease(tls *libc.TLS, pVTab uintptr, f func(VTab) error) int32 {
+	h := (*goVTab)(unsafe.Pointer(pVTab)).handle
+	st := handleValue(h).(*vtabState)
+	err := f(st.table)
+	for _, v := range st.funcs {
+		deleteHandle(v)
+	}
+	deleteHandle(h)
+	libc.Xfree(tls, pVTab)
+	if err != nil {
+		return sqlite3.SQLITE_ERROR
+	}
+
+	return sqlite3.SQLITE_OK
+}
+
+func vtabDisconnect(tls *libc.TLS, pVTab uintptr) int32 {
+	return vtabRelease(tls, pVTab, VTab.Disconnect)
+}
+
+func vtabDestroy(tls *libc.TLS, pVTab uintptr) int32 {
+	return vtabRelease(tls, pVTab, VTab.Destroy)
+}
+
+func vtabOpen(tls *libc.TLS, pVTab, ppCursor uintptr) int32 {
+	cur, err := vtabTable(pVTab).Open()
+	if err != nil {
+		return setVTabError(tls, pVTab, err)
+	}
+
+	pCursor := libc.Xcalloc(tls, 1, types.Size_t(unsafe.Sizeof(goVTabCursor{})))
+	if pCursor == 0 {
+		cur.Close()
+		return sqlite3.SQLITE_NOMEM
+	}
+
+	(*goVTabCursor)(unsafe.Pointer(pCursor)).handle = newHandle(cur)
+	*(*uintptr)(unsafe.Pointer(ppCursor)) = pCursor
+	return sqlite3.SQLITE_OK
+}
+
+func vtabCursor(pCursor uintptr) VTabCursor {
+	return handleValue((*goVTabCursor)(unsafe.Pointer(pCursor)).handle).(VTabCursor)
+}
+
+// setCursorError sets the error message of the virtual table of pCursor to
+// err and returns SQLITE_ERROR.
+func setCursorError(tls *libc.TLS, pCursor uintptr, err error) int32 {
+	return setVTabError(tls, (*goVTabCursor)(unsafe.Pointer(pCursor)).base.FpVtab, err)
+}
+
+func vtabClose(tls *libc.TLS, pCursor uintptr) int32 {
+	h := (*goVTabCursor)(unsafe.Pointer(pCursor)).handle
+	err := handleValue(h).(VTabCursor).Close()
+	deleteHandle(h)
+	libc.Xfree(tls, pCursor)
+	if err != nil {
+		return sqlite3.SQLITE_ERROR
+	}
+
+	return sqlite3.SQLITE_OK
+}
+
+func vtabFilter(tls *libc.TLS, pCursor uintptr, idxNum int32, idxStr uintptr, argc int32, argv uintptr) int32 {
+	if err := vtabCursor(pCursor).Filter(int(idxNum), libc.GoString(idxStr), functionArgs(tls, argc, argv)); err != nil {
+		return setCursorError(tls, pCursor, err)
+	}
+
+	return sqlite3.SQLITE_OK
+}
+
+func vtabNext(tls *libc.TLS, pCursor uintptr) int32 {
+	if err := vtabCursor(pCursor).Next(); err != nil {
+		return setCursorError(tls, pCursor, err)
+	}
+
+	return sqlite3.SQLITE_OK
+}
+
+func vtabEOF(tls *libc.TLS, pCursor uintptr) int32 {
+	return libc.Bool32(vtabCursor(pCursor).EOF())
+}
+
+func vtabColumn(tls *libc.TLS, pCursor, ctx uintptr, i int32) int32 {
+	v, err := vtabCursor(pCursor).Column(int(i))
+	if err == nil {
+		err = functionReturnValue(tls, ctx, v)
+	}
+	if err != nil {
+		errorResultFunction(tls, ctx)(err)
+		return sqlite3.SQLITE_ERROR
+	}
+
+	return sqlite3.SQLITE_OK
+}
+
+func vtabRowid(tls *libc.TLS, pCursor, pRowid uintptr) int32 {
+	rowid, err := vtabCursor(pCursor).Rowid()
+	if err != nil {
+		return setCursorError(tls, pCursor, err)
+	}
+
+	*(*int64)(unsafe.Pointer(pRowid)) = rowid
+	return sqlite3.SQLITE_OK
+}
+
+func vtabFindFunction(tls *libc.TLS, pVTab uintptr, nArg int32, zName, pxFunc, ppArg uintptr) int32 {
+	st := vtabStateOf(pVTab)
+	ff, ok := st.table.(VTabFindFunction)
+	if !ok {
+		return 0
+	}
+
+	// SQLite asks every time a statement using the function is prepared,
+	// and the function stays valid until the table is disconnected.
+	key := vtabFunc{libc.GoString(zName), nArg}
+	h, ok := st.funcs[key]
+	if !ok {
+		f := ff.FindFunction(key.name, int(nArg))
+		if f == nil {
+			return 0
+		}
+
+		if st.funcs == nil {
+			st.funcs = map[vtabFunc]uintptr{}
+		}
+		h = newHandle(f)
+		st.funcs[key] = h
+	}
+
+	*(*uintptr)(unsafe.Pointer(pxFunc)) = *(*uintptr)(unsafe.Pointer(&struct {
+		f func(*libc.TLS, uintptr, int32, uintptr)
+	}{vtabFunction}))
+	*(*uintptr)(unsafe.Pointer(ppArg)) = h
+	return 1
+}
+
+// vtabFunction calls a function returned by VTabFindFunction.FindFunction.
+func vtabFunction(tls *libc.TLS, ctx uintptr, argc int32, argv uintptr) {
+	f := handleValue(sqlite3.Xsqlite3_user_data(tls, ctx)).(func(*FunctionContext, []driver.Value) (driver.Value, error))
+	callFunction(tls, ctx, argc, argv, f)
+}
+
+// int sqlite3_overload_function(sqlite3*, const char *zFuncName, int nArg);
+func (c *conn) overloadFunction(name string, nArg int) error {
+	zName, err := libc.CString(name)
+	if err != nil {
+		return err
+	}
+
+	defer c.free(zName)
+
+	if rc := sqlite3.Xsqlite3_overload_function(c.tls, c.db, zName, int32(nArg)); rc != sqlite3.SQLITE_OK {
+		return c.errstr(rc)
+	}
+
+	return nil
+}
+
+// OverloadFunction declares the function name taking nArg arguments on the
+// connection c, if it does not exist yet, so that a virtual table
+// implementing VTabFindFunction can overload it. Called outside of such a
+// table, the placeholder function fails with an error.
+//
+// This is only needed for functions that exist solely as virtual table
+// overloads. See https://www.sqlite.org/c3ref/overload_function.html.
+func OverloadFunction(c *sql.Conn, name string, nArg int) error {
+	return withConn(c, func(c *conn) error { return c.overloadFunction(name, nArg) })
+}
+
+// int sqlite3_drop_modules(sqlite3 *db, const char **azKeep);
+func (c *conn) dropModules(keep []string) error {
+	var azKeep uintptr
+	if len(keep) != 0 {
+		p, err := c.malloc(int(ptrSize) * (len(keep) + 1))
+		if err != nil {
+			return err
+		}
+
+		azKeep = p
+		defer func() {
+			for i := range keep {
+				if z := *(*uintptr)(unsafe.Pointer(azKeep + uintptr(i)*ptrSize)); z != 0 {
+					c.free(z)
+				}
+			}
+			c.free(azKeep)
+		}()
+
+		for i := 0; i <= len(keep); i++ {
+			*(*uintptr)(unsafe.Pointer(azKeep + uintptr(i)*ptrSize)) = 0
+		}
+		for i, v := range keep {
+			z, err := libc.CString(v)
+			if err != nil {
+				return err
+			}
+
+			*(*uintptr)(unsafe.Pointer(azKeep + uintptr(i)*ptrSize)) = z
+		}
+	}
+
+	if rc := sqlite3.Xsqlite3_drop_modules(c.tls, c.db, azKeep); rc != sqlite3.SQLITE_OK {
+		return c.errstr(rc)
+	}
+
+	return nil
+}
+
+// DropModules removes all virtual table modules from the connection c except
+// those named in keep, eg. to prevent untrusted queries from using modules
+// like dbstat that expose the internals of the database. Virtual tables using
+// a dropped module can no longer be used on c.
+//
+// Modules registered by RegisterModule are dropped as well unless kept. The
+// effect is limited to c; other connections are not affected.
+func DropModules(c *sql.Conn, keep ...string) error {
+	return withConn(c, func(c *conn) error { return c.dropModules(keep) })
+}
@@ -0,0 +1,313 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// kvModule is a virtual table over a fixed list of key/value pairs. It pushes
+// down equality constraints on the key column.
+type kvModule struct {
+	keys   []string
+	values []int64
+
+	sync.Mutex
+	filters  []string // idxNum and args of every Filter call
+	distinct []int    // IndexInfo.Distinct of every BestIndex call
+}
+
+func (m *kvModule) Connect(args []string) (VTab, string, error) {
+	return &kvTable{m}, "create table x(key text, value integer)", nil
+}
+
+type kvTable struct{ m *kvModule }
+
+func (t *kvTable) BestIndex(info *IndexInfo) error {
+	t.m.Lock()
+	t.m.distinct = append(t.m.distinct, info.Distinct())
+	t.m.Unlock()
+	info.EstimatedCost = 1e6
+	for i, c := range info.Constraints {
+		if c.Usable && c.Column == 0 && c.Op == IndexConstraintEQ {
+			info.Constraints[i].ArgvIndex = 1
+			info.Constraints[i].Omit = true
+			info.IdxNum = 1
+			info.IdxFlags = IndexScanUnique
+			info.EstimatedCost = 1
+			info.EstimatedRows = 1
+			break
+		}
+	}
+	return nil
+}
+
+// FindFunction overloads kvmatch(column, value) to compare for equality.
+func (t *kvTable) FindFunction(name string, nArg int) func(*FunctionContext, []driver.Value) (driver.Value, error) {
+	if name != "kvmatch" || nArg != 2 {
+		return nil
+	}
+
+	return func(ctx *FunctionContext, args []driver.Value) (driver.Value, error) {
+		return args[0] == args[1], nil
+	}
+}
+
+func (t *kvTable) Open() (VTabCursor, error) { return &kvCursor{m: t.m}, nil }
+func (t *kvTable) Disconnect() error         { return nil }
+func (t *kvTable) Destroy() error            { return nil }
+
+type kvCursor struct {
+	m    *kvModule
+	rows []int
+	i    int
+}
+
+func (c *kvCursor) Filter(idxNum int, idxStr string, args []driver.Value) error {
+	c.m.Lock()
+	c.m.filters = append(c.m.filters, fmt.Sprint(idxNum, args))
+	c.m.Unlock()
+	c.rows, c.i = c.rows[:0], 0
+	for i, k := range c.m.keys {
+		if idxNum == 0 || k == args[0] {
+			c.rows = append(c.rows, i)
+		}
+	}
+	return nil
+}
+
+func (c *kvCursor) Next() error           { c.i++; return nil }
+func (c *kvCursor) EOF() bool             { return c.i >= len(c.rows) }
+func (c *kvCursor) Close() error          { return nil }
+func (c *kvCursor) Rowid() (int64, error) { return int64(c.rows[c.i]), nil }
+
+func (c *kvCursor) Column(col int) (driver.Value, error) {
+	switch col {
+	case 0:
+		return c.m.keys[c.rows[c.i]], nil
+	default:
+		return c.m.values[c.rows[c.i]], nil
+	}
+}
+
+var testKVModule = &kvModule{
+	keys:   []string{"a", "b", "c"},
+	values: []int64{1, 2, 2},
+}
+
+func init() {
+	if err := RegisterModule("kvtest", testKVModule); err != nil {
+		panic(err)
+	}
+}
+
+func TestVTab(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	testKVModule.Lock()
+	testKVModule.filters, testKVModule.distinct = nil, nil
+	testKVModule.Unlock()
+
+	if _, err := db.Exec("create virtual table kv using kvtest"); err != nil {
+		t.Fatal(err)
+	}
+
+	var n, sum int64
+	if err := db.QueryRow("select count(*), sum(value) from kv").Scan(&n, &sum); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := fmt.Sprint(n, sum), "3 5"; g != e {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	if err := db.QueryRow("select value from kv where key = ?", "b").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 2 {
+		t.Fatalf("got %v, want 2", n)
+	}
+
+	rows, err := db.Query("select distinct value from kv order by value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values []int64
+	for rows.Next() {
+		if err := rows.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+
+		values = append(values, n)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows.Close()
+	if g, e := values, []int64{1, 2}; !reflect.DeepEqual(g, e) {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	testKVModule.Lock()
+	defer testKVModule.Unlock()
+
+	if g, e := testKVModule.filters, []string{"0 []", "1 [b]", "0 []"}; !reflect.DeepEqual(g, e) {
+		t.Errorf("filters: got %q, want %q", g, e)
+	}
+
+	if g := testKVModule.distinct; len(g) == 0 || g[0] != 0 || g[len(g)-1] == 0 {
+		t.Errorf("unexpected Distinct values %v", g)
+	}
+}
+
+func TestVTabEponymous(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	var key string
+	if err := db.QueryRow("select key from kvtest where value = 1").Scan(&key); err != nil {
+		t.Fatal(err)
+	}
+
+	if key != "a" {
+		t.Fatalf("got %q, want %q", key, "a")
+	}
+}
+
+func TestOverloadFunction(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if _, err := c.ExecContext(ctx, "select kvmatch(1, 1)"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if err := OverloadFunction(c, "kvmatch", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := c.QueryContext(ctx, "select key from kvtest where kvmatch(value, 2) order by key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			t.Fatal(err)
+		}
+
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if g, e := keys, []string{"b", "c"}; !reflect.DeepEqual(g, e) {
+		t.Fatalf("got %v, want %v", g, e)
+	}
+
+	// Statements prepared again reuse the function found the first time.
+	handleCount := func() int {
+		handles.Lock()
+		defer handles.Unlock()
+
+		return len(handles.m)
+	}
+	n := handleCount()
+	for i := 0; i < 3; i++ {
+		var key string
+		if err := c.QueryRowContext(ctx, fmt.Sprintf("select key from kvtest where kvmatch(value, 2) limit %d", i+1)).Scan(&key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if g, e := handleCount(), n; g != e {
+		t.Fatalf("got %v handles, want %v", g, e)
+	}
+
+	if _, err := c.ExecContext(ctx, "select kvmatch(1, 1)"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDropModules(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if err := DropModules(c, "kvtest"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		"create virtual table ft using fts5(x)",
+		"select * from dbstat",
+	} {
+		if _, err := c.ExecContext(ctx, s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+
+	if _, err := c.ExecContext(ctx, "create virtual table kv using kvtest"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other connections keep all modules.
+	if _, err := db.Exec("create virtual table temp.ft using fts5(x)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DropModules(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.ExecContext(ctx, "create virtual table kv2 using kvtest"); err == nil {
+		t.Fatal("expected error")
+	}
+}
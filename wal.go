@@ -0,0 +1,226 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// checkpointModes maps checkpoint mode names to their sqlite3_wal_checkpoint_v2
+// constants.
+var checkpointModes = map[string]int32{
+	"passive":  sqlite3.SQLITE_CHECKPOINT_PASSIVE,
+	"full":     sqlite3.SQLITE_CHECKPOINT_FULL,
+	"restart":  sqlite3.SQLITE_CHECKPOINT_RESTART,
+	"truncate": sqlite3.SQLITE_CHECKPOINT_TRUNCATE,
+}
+
+// int sqlite3_wal_checkpoint_v2(
+//
+//	sqlite3 *db,                    /* Database handle */
+//	const char *zDb,                /* Name of attached database (or NULL) */
+//	int eMode,                      /* SQLITE_CHECKPOINT_* value */
+//	int *pnLog,                     /* OUT: Size of WAL log in frames */
+//	int *pnCkpt                     /* OUT: Total number of frames checkpointed */
+//
+// );
+//
+// An empty schema checkpoints all attached databases.
+func (c *conn) walCheckpoint(schema string, mode int32) (nLog, nCkpt int, err error) {
+	var zDb uintptr
+	if schema != "" {
+		if zDb, err = libc.CString(schema); err != nil {
+			return 0, 0, err
+		}
+
+		defer c.free(zDb)
+	}
+
+	p, err := c.malloc(2 * 4)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer c.free(p)
+
+	rc := sqlite3.Xsqlite3_wal_checkpoint_v2(c.tls, c.db, zDb, mode, p, p+4)
+	nLog = int(*(*int32)(unsafe.Pointer(p)))
+	nCkpt = int(*(*int32)(unsafe.Pointer(p + 4)))
+	if rc != sqlite3.SQLITE_OK {
+		return nLog, nCkpt, c.errstr(rc)
+	}
+
+	return nLog, nCkpt, nil
+}
+
+// Checkpoint runs sqlite3_wal_checkpoint_v2 on the connection c. The mode may
+// be "passive", "full", "restart" or "truncate" (case insensitive) and an
+// empty schema checkpoints all attached databases. It returns the size of the
+// write-ahead log in frames and the number of frames checkpointed.
+//
+// See https://www.sqlite.org/c3ref/wal_checkpoint_v2.html for details.
+func Checkpoint(c *sql.Conn, schema, mode string) (nLog, nCkpt int, err error) {
+	m, ok := checkpointModes[strings.ToLower(mode)]
+	if !ok {
+		return 0, 0, fmt.Errorf("sqlite: unknown checkpoint mode %q", mode)
+	}
+
+	err = withConn(c, func(c *conn) (err error) {
+		nLog, nCkpt, err = c.walCheckpoint(schema, m)
+		return err
+	})
+	return nLog, nCkpt, err
+}
+
+// TruncateWAL runs a truncate checkpoint of all the databases attached to the
+// connection c, which copies the whole write-ahead log to the database and
+// resets the -wal file to zero bytes, eg. to reclaim the space after a large
+// import. The checkpoint waits for the other connections as set by their
+// busy_timeout and TruncateWAL fails with SQLITE_BUSY if they still prevent
+// it, eg. by an open read transaction, from resetting the log.
+func TruncateWAL(c *sql.Conn) error {
+	return withConn(c, func(c *conn) error {
+		nLog, _, err := c.walCheckpoint("", sqlite3.SQLITE_CHECKPOINT_TRUNCATE)
+		if err != nil {
+			return err
+		}
+
+		// nLog is -1 if no database is in WAL mode.
+		if nLog > 0 {
+			return c.errcode(sqlite3.SQLITE_BUSY)
+		}
+
+		return nil
+	})
+}
+
+// WalManager checkpoints the write-ahead log of a database in the background,
+// replacing the automatic checkpoints SQLite runs on commit. Every Interval it
+// runs a passive checkpoint, which never waits for readers or writers, and if
+// the log is still larger than MaxFrames frames it tries a truncate
+// checkpoint, which resets the -wal file to zero bytes.
+//
+// Automatic checkpoints are a per-connection setting, so the database should
+// be opened with the "_pragma=wal_autocheckpoint(0)" query parameter for the
+// manager to be the only one checkpointing.
+type WalManager struct {
+	// Interval between checkpoints. Zero means one second.
+	Interval time.Duration
+
+	// MaxFrames is the size of the log, in frames, above which a truncate
+	// checkpoint is tried. Zero means never.
+	MaxFrames int
+
+	// Schema is the database to checkpoint. Empty means all attached
+	// databases.
+	Schema string
+
+	// OnError, if not nil, is called with the errors of the checkpoints.
+	// A truncate checkpoint blocked by other connections is not an error.
+	OnError func(error)
+
+	db *sql.DB
+
+	sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewWalManager returns a WalManager of db. The database must be in WAL mode.
+func NewWalManager(db *sql.DB) *WalManager {
+	return &WalManager{db: db}
+}
+
+// Start starts checkpointing in the background until Stop is called or ctx
+// is done. The manager uses one connection of db for its whole lifetime.
+func (m *WalManager) Start(ctx context.Context) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.done != nil {
+		return fmt.Errorf("sqlite: WalManager already started")
+	}
+
+	c, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	// A truncate checkpoint must give up at once instead of waiting for the
+	// writers it would block.
+	if _, err := SetBusyTimeout(c, 0); err != nil {
+		c.Close()
+		return err
+	}
+
+	if _, err := c.ExecContext(ctx, "pragma wal_autocheckpoint = 0"); err != nil {
+		c.Close()
+		return err
+	}
+
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	go m.run(ctx, c, m.done)
+	return nil
+}
+
+// Stop stops checkpointing and waits for the checkpoint in progress, if any,
+// to finish. A stopped manager may be started again.
+func (m *WalManager) Stop() {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.done == nil {
+		return
+	}
+
+	m.cancel()
+	<-m.done
+	m.cancel, m.done = nil, nil
+}
+
+func (m *WalManager) run(ctx context.Context, c *sql.Conn, done chan struct{}) {
+	defer close(done)
+	defer c.Close()
+
+	interval := m.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			m.checkpoint(c)
+		}
+	}
+}
+
+func (m *WalManager) checkpoint(c *sql.Conn) {
+	nLog, _, err := Checkpoint(c, m.Schema, "passive")
+	if err == nil && m.MaxFrames > 0 && nLog > m.MaxFrames {
+		_, _, err = Checkpoint(c, m.Schema, "truncate")
+		if e, ok := err.(*Error); ok && e.Code()&0xff == sqlite3.SQLITE_BUSY {
+			err = nil
+		}
+	}
+	if err != nil && m.OnError != nil {
+		m.OnError(err)
+	}
+}
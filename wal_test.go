@@ -0,0 +1,174 @@
+// Copyright 2023 The Sqlite Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sqlite // import "modernc.org/sqlite"
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func TestCheckpoint(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open(driverName, fn+"?_pragma=journal_mode(wal)&_pragma=wal_autocheckpoint(0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if _, err := c.ExecContext(ctx, "create table t(b); insert into t values(randomblob(10000))"); err != nil {
+		t.Fatal(err)
+	}
+
+	nLog, nCkpt, err := Checkpoint(c, "", "passive")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if nLog == 0 || nCkpt != nLog {
+		t.Fatalf("got nLog %v, nCkpt %v", nLog, nCkpt)
+	}
+
+	if nLog, nCkpt, err = Checkpoint(c, "main", "truncate"); err != nil {
+		t.Fatal(err)
+	}
+
+	if nLog != 0 || nCkpt != 0 {
+		t.Fatalf("got nLog %v, nCkpt %v", nLog, nCkpt)
+	}
+
+	if _, _, err := Checkpoint(c, "", "sometimes"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestTruncateWAL(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open(driverName, fn+"?_pragma=journal_mode(wal)&_pragma=busy_timeout(0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if _, err := c.ExecContext(ctx, "create table t(b); insert into t values(randomblob(100000))"); err != nil {
+		t.Fatal(err)
+	}
+
+	walSize := func() int64 {
+		fi, err := os.Stat(fn + "-wal")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return fi.Size()
+	}
+
+	if walSize() == 0 {
+		t.Fatal("empty WAL")
+	}
+
+	// An open read transaction prevents resetting the log.
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := tx.QueryRow("select count(*) from t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := TruncateWAL(c); err == nil {
+		t.Fatal("unexpected success")
+	} else if e, ok := err.(*Error); !ok || e.Code()&0xff != sqlite3.SQLITE_BUSY {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	tx.Rollback()
+	if err := TruncateWAL(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := walSize(); n != 0 {
+		t.Fatalf("WAL size %v", n)
+	}
+}
+
+func TestWalManager(t *testing.T) {
+	const maxFrames = 50
+
+	fn := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open(driverName, fn+"?_pragma=journal_mode(wal)&_pragma=wal_autocheckpoint(0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	if _, err := db.Exec("create table t(b)"); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewWalManager(db)
+	m.Interval = 10 * time.Millisecond
+	m.MaxFrames = maxFrames
+	m.OnError = func(err error) { t.Error(err) }
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	defer m.Stop()
+
+	if err := m.Start(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Unmanaged, the 500 inserts grow the log to 1000+ frames.
+	var max int64
+	for i := 0; i < 500; i++ {
+		if _, err := db.Exec("insert into t values(randomblob(5000))"); err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := os.Stat(fn + "-wal")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi.Size() > max {
+			max = fi.Size()
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	m.Stop()
+	t.Logf("max -wal size %v", max)
+	if limit := int64(8 * maxFrames * (4096 + 24)); max > limit {
+		t.Fatalf("-wal size %v exceeds %v", max, limit)
+	}
+}